@@ -242,6 +242,20 @@ func (s *Server) Stop() (err error) {
 	return
 }
 
+// tsigVerified returns true if the signature t of the request was verified. The dns package only verifies it
+// when the server has a secret for the key, and DoH and gRPC requests aren't verified at all. In both cases
+// TsigStatus doesn't return an error.
+func (s *Server) tsigVerified(w dns.ResponseWriter, t *dns.TSIG) bool {
+	if _, ok := s.tsigSecret[t.Hdr.Name]; !ok {
+		return false
+	}
+	switch w.(type) {
+	case *DoHWriter, *gRPCresponse:
+		return false
+	}
+	return w.TsigStatus() == nil
+}
+
 // Address together with Stop() implement caddy.GracefulServer.
 func (s *Server) Address() string { return s.Addr }
 
@@ -283,6 +297,11 @@ func (s *Server) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 		return
 	}
 
+	// Record the TSIG key of a request whose signature was verified, so plugins can rely on it.
+	if t := r.IsTsig(); t != nil && s.tsigVerified(w, t) {
+		ctx = context.WithValue(ctx, TsigKey{}, t.Hdr.Name)
+	}
+
 	// Wrap the response writer in a ScrubWriter so we automatically make the reply fit in the client's buffer.
 	w = request.NewScrubWriter(r, w)
 
@@ -445,6 +464,10 @@ type (
 
 	// ViewKey is the context key for the current view, if defined
 	ViewKey struct{}
+
+	// TsigKey is the context key for the name of the TSIG key of the request, only set if its signature was
+	// verified.
+	TsigKey struct{}
 )
 
 // EnableChaos is a map with plugin names for which we should open CH class queries as we block these by default.
@@ -115,3 +115,40 @@ func BenchmarkCoreServeDNS(b *testing.B) {
 		s.ServeDNS(ctx, w, m)
 	}
 }
+
+func TestTsigVerified(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	r.SetTsig("key.example.com.", dns.HmacSHA256, 300, 0)
+
+	var key string
+	p := plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		key, _ = ctx.Value(TsigKey{}).(string)
+		return 0, nil
+	})
+
+	tests := []struct {
+		secrets  map[string]string
+		w        dns.ResponseWriter
+		expected string
+	}{
+		// Without a secret TsigStatus returns nil, but the signature isn't verified.
+		{nil, &test.ResponseWriter{}, ""},
+		{map[string]string{"other.example.com.": "c2VjcmV0"}, &test.ResponseWriter{}, ""},
+		{map[string]string{"key.example.com.": "c2VjcmV0"}, &test.ResponseWriter{}, "key.example.com."},
+		{map[string]string{"key.example.com.": "c2VjcmV0"}, &DoHWriter{}, ""},
+	}
+	for i, tc := range tests {
+		c := testConfig("dns", p)
+		c.TsigSecret = tc.secrets
+		s, err := NewServer("127.0.0.1:53", []*Config{c})
+		if err != nil {
+			t.Fatalf("Test %d: expected no error for NewServer, got %s", i, err)
+		}
+		key = "none"
+		s.ServeDNS(context.Background(), tc.w, r)
+		if key != tc.expected {
+			t.Errorf("Test %d: expected TSIG key %q, got %q", i, tc.expected, key)
+		}
+	}
+}
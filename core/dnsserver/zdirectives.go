@@ -12,12 +12,15 @@ package dnsserver
 var Directives = []string{
 	"metadata",
 	"geoip",
+	"clients",
+	"requestinfo",
 	"cancel",
 	"tls",
 	"timeouts",
 	"reload",
 	"nsid",
 	"bufsize",
+	"cookie",
 	"root",
 	"bind",
 	"debug",
@@ -32,6 +35,7 @@ var Directives = []string{
 	"local",
 	"dns64",
 	"acl",
+	"policy",
 	"any",
 	"chaos",
 	"loadbalance",
@@ -39,6 +43,7 @@ var Directives = []string{
 	"cache",
 	"rewrite",
 	"header",
+	"flatten",
 	"dnssec",
 	"autopath",
 	"minimal",
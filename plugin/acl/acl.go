@@ -4,8 +4,10 @@ import (
 	"context"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metadata"
 	"github.com/coredns/coredns/plugin/metrics"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
 	"github.com/coredns/coredns/request"
@@ -19,6 +21,9 @@ type ACL struct {
 	Next plugin.Handler
 
 	Rules []rule
+
+	lists  []*list       // all lists read from files, each file once
+	reload time.Duration // interval to check the lists for changes
 }
 
 // rule defines a list of Zones and some ACL policies which will be
@@ -33,11 +38,19 @@ type action int
 
 // policy defines the ACL policy for DNS queries.
 // A policy performs the specified action (block/allow) on all DNS queries
-// matched by source IP or QTYPE.
+// matched by source IP, QTYPE and query name.
 type policy struct {
 	action action
 	qtypes map[uint16]struct{}
 	filter *iptree.Tree
+	nets   []*list // networks from files, in addition to filter
+	// names are the names whose queries, and those of their subdomains, are matched. Both
+	// names and nameLists are empty if all names are matched.
+	names     map[string]struct{}
+	nameLists []*list
+	// groups are the client groups, published as metadata by the clients plugin, that are matched. All
+	// clients are matched if groups is empty.
+	groups map[string]struct{}
 }
 
 const (
@@ -67,7 +80,7 @@ RulesCheckLoop:
 			continue
 		}
 
-		action := matchWithPolicies(rule.policies, w, r)
+		action := matchWithPolicies(ctx, rule.policies, w, r)
 		switch action {
 		case actionDrop:
 			{
@@ -109,7 +122,7 @@ RulesCheckLoop:
 
 // matchWithPolicies matches the DNS query with a list of ACL polices and returns suitable
 // action against the query.
-func matchWithPolicies(policies []policy, w dns.ResponseWriter, r *dns.Msg) action {
+func matchWithPolicies(ctx context.Context, policies []policy, w dns.ResponseWriter, r *dns.Msg) action {
 	state := request.Request{W: w, Req: r}
 
 	var ip net.IP
@@ -134,8 +147,7 @@ func matchWithPolicies(policies []policy, w dns.ResponseWriter, r *dns.Msg) acti
 			continue
 		}
 
-		_, contained := policy.filter.GetByIP(ip)
-		if !contained {
+		if !policy.matchIP(ip) || !policy.matchName(state.Name()) || !policy.matchGroup(ctx) {
 			continue
 		}
 
@@ -145,6 +157,50 @@ func matchWithPolicies(policies []policy, w dns.ResponseWriter, r *dns.Msg) acti
 	return actionNone
 }
 
+// matchIP returns true if ip is in one of the networks of the policy.
+func (p policy) matchIP(ip net.IP) bool {
+	if _, ok := p.filter.GetByIP(ip); ok {
+		return true
+	}
+	for _, l := range p.nets {
+		if l.containsIP(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchName returns true if name, or one of its parents, is one of the names of the policy, or if the
+// policy matches all names.
+func (p policy) matchName(name string) bool {
+	if len(p.names) == 0 && len(p.nameLists) == 0 {
+		return true
+	}
+	if matchName(p.names, name) {
+		return true
+	}
+	for _, l := range p.nameLists {
+		if l.containsName(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGroup returns true if the client is in one of the groups of the policy, or if the policy matches all
+// clients.
+func (p policy) matchGroup(ctx context.Context) bool {
+	if len(p.groups) == 0 {
+		return true
+	}
+	f := metadata.ValueFunc(ctx, "clients/group")
+	if f == nil {
+		return false
+	}
+	_, ok := p.groups[f()]
+	return ok
+}
+
 // Name implements the plugin.Handler interface.
 func (a ACL) Name() string {
 	return "acl"
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/metadata"
 	"github.com/coredns/coredns/plugin/test"
 
 	"github.com/horahoradev/dns"
@@ -220,6 +221,47 @@ func TestACLServeDNS(t *testing.T) {
 			wantRcode:        dns.RcodeSuccess,
 			expectNoResponse: true,
 		},
+		{
+			name: "Name 1 BLOCKED",
+			config: `acl example.org {
+				block name ads.example.org net 192.168.0.0/16
+			}`,
+			zones: []string{},
+			args: args{
+				domain:   "x.ads.example.org.",
+				sourceIP: "192.168.0.2",
+				qtype:    dns.TypeA,
+			},
+			wantRcode:             dns.RcodeRefused,
+			wantExtendedErrorCode: dns.ExtendedErrorCodeBlocked,
+		},
+		{
+			name: "Name 1 ALLOWED",
+			config: `acl example.org {
+				block name ads.example.org net 192.168.0.0/16
+			}`,
+			zones: []string{},
+			args: args{
+				domain:   "www.example.org.",
+				sourceIP: "192.168.0.2",
+				qtype:    dns.TypeA,
+			},
+			wantRcode: dns.RcodeSuccess,
+		},
+		{
+			name: "Name 2 FILTERED",
+			config: `acl example.org {
+				filter type AAAA name Ads.Example.Org. tracker.example.org
+			}`,
+			zones: []string{},
+			args: args{
+				domain:   "tracker.example.org.",
+				sourceIP: "10.1.0.2",
+				qtype:    dns.TypeAAAA,
+			},
+			wantRcode:             dns.RcodeSuccess,
+			wantExtendedErrorCode: dns.ExtendedErrorCodeFiltered,
+		},
 		{
 			name: "Subnet-Order 1 REFUSED",
 			config: `acl example.org {
@@ -597,3 +639,39 @@ func TestACLServeDNS(t *testing.T) {
 		})
 	}
 }
+
+func TestACLGroup(t *testing.T) {
+	a, err := parse(caddy.NewTestController("dns", `acl . {
+		allow group office
+		block group guests lab
+	}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	a.Next = test.NextHandler(dns.RcodeSuccess, nil)
+
+	tests := []struct {
+		group     string // empty means the group isn't set
+		wantRcode int
+	}{
+		{"office", dns.RcodeSuccess},
+		{"guests", dns.RcodeRefused},
+		{"lab", dns.RcodeRefused},
+		{"other", dns.RcodeSuccess},
+		{"", dns.RcodeSuccess},
+	}
+	for i, tc := range tests {
+		ctx := metadata.ContextWithMetadata(context.Background())
+		if tc.group != "" {
+			group := tc.group
+			metadata.SetValueFunc(ctx, "clients/group", func() string { return group })
+		}
+		w := &testResponseWriter{}
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeA)
+		a.ServeDNS(ctx, w, m)
+		if w.Rcode != tc.wantRcode {
+			t.Errorf("Test %d: Expected rcode %d, got %d", i, tc.wantRcode, w.Rcode)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package acl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/horahoradev/dns"
+	"github.com/infobloxopen/go-trees/iptree"
+)
+
+// listKind is the kind of entries in a list.
+type listKind int
+
+const (
+	// listNet is a list of networks and addresses.
+	listNet listKind = iota
+	// listName is a list of domain names.
+	listName
+)
+
+// list is a list of networks or names read from a file, one per line. The list is replaced as a whole when the
+// file changes, so a request sees either the old or the new list, never a mix.
+type list struct {
+	path string
+	kind listKind
+
+	sync.RWMutex
+	tree  *iptree.Tree
+	names map[string]struct{}
+
+	// mtime and size are only read and modified by a single goroutine
+	mtime time.Time
+	size  int64
+}
+
+// containsIP returns true if ip is in one of the networks of the list.
+func (l *list) containsIP(ip net.IP) bool {
+	l.RLock()
+	defer l.RUnlock()
+	_, ok := l.tree.GetByIP(ip)
+	return ok
+}
+
+// containsName returns true if name, or one of its parents, is in the list.
+func (l *list) containsName(name string) bool {
+	l.RLock()
+	defer l.RUnlock()
+	return matchName(l.names, name)
+}
+
+// read reads the file if it has changed since the last read. A file that can't be read or parsed leaves the list
+// unchanged.
+func (l *list) read() error {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if l.mtime.Equal(stat.ModTime()) && l.size == stat.Size() {
+		return nil
+	}
+
+	var (
+		tree  *iptree.Tree
+		names map[string]struct{}
+		n     int
+	)
+	switch l.kind {
+	case listNet:
+		tree, n, err = parseNets(file)
+	case listName:
+		names, err = parseNames(file)
+		n = len(names)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", l.path, err)
+	}
+
+	l.Lock()
+	l.tree, l.names = tree, names
+	l.Unlock()
+	l.mtime = stat.ModTime()
+	l.size = stat.Size()
+
+	ListEntries.WithLabelValues(l.path).Set(float64(n))
+	ListReloadTime.WithLabelValues(l.path).Set(float64(time.Now().UnixNano()) / 1e9)
+	return nil
+}
+
+// parseNets parses a list of networks and addresses, and returns them as a tree along with their number.
+func parseNets(r io.Reader) (*iptree.Tree, int, error) {
+	tree := iptree.NewTree()
+	n := 0
+	err := parseLines(r, func(line string) error {
+		_, source, err := net.ParseCIDR(normalize(line))
+		if err != nil {
+			return fmt.Errorf("illegal CIDR notation %q", line)
+		}
+		tree.InplaceInsertNet(source, struct{}{})
+		n++
+		return nil
+	})
+	return tree, n, err
+}
+
+// parseNames parses a list of domain names.
+func parseNames(r io.Reader) (map[string]struct{}, error) {
+	names := make(map[string]struct{})
+	err := parseLines(r, func(line string) error {
+		name, err := normalizeName(line)
+		if err != nil {
+			return err
+		}
+		names[name] = struct{}{}
+		return nil
+	})
+	return names, err
+}
+
+// parseLines calls fn for each line of r, skipping empty lines and comments that start with '#'.
+func parseLines(r io.Reader, fn func(line string) error) error {
+	scanner := bufio.NewScanner(r)
+	i := 0
+	for scanner.Scan() {
+		i++
+		line := scanner.Text()
+		if j := strings.IndexByte(line, '#'); j >= 0 {
+			line = line[:j]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return fmt.Errorf("line %d: %s", i, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// normalizeName returns name as a lower case, fully qualified domain name.
+func normalizeName(name string) (string, error) {
+	if _, ok := dns.IsDomainName(name); !ok {
+		return "", fmt.Errorf("illegal domain name %q", name)
+	}
+	return dns.Fqdn(strings.ToLower(name)), nil
+}
+
+// matchName returns true if name, or one of its parents, is in names.
+func matchName(names map[string]struct{}, name string) bool {
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if _, ok := names[name[off:]]; ok {
+			return true
+		}
+	}
+	return false
+}
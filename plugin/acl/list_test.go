@@ -0,0 +1,174 @@
+package acl
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/horahoradev/dns"
+)
+
+func TestParseNets(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		entries   int
+	}{
+		{"192.168.0.0/16\n10.0.0.1\n", false, 2},
+		{"# networks\n\n2001:db8::/32 # documentation\n", false, 1},
+		{"", false, 0},
+		// fails
+		{"192.168.0/16", true, 0},
+		{"example.org", true, 0},
+	}
+	for i, tc := range tests {
+		_, n, err := parseNets(strings.NewReader(tc.input))
+		if tc.shouldErr && err == nil {
+			t.Errorf("Test %d: Expected error but found nil", i)
+			continue
+		} else if !tc.shouldErr && err != nil {
+			t.Errorf("Test %d: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if !tc.shouldErr && n != tc.entries {
+			t.Errorf("Test %d: Expected %d entries, got %d", i, tc.entries, n)
+		}
+	}
+}
+
+func TestParseNames(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		entries   int
+	}{
+		{"example.org\nADS.example.NET.\n", false, 2},
+		{"# names\nexample.org # and subdomains\nexample.org.\n", false, 1},
+		// fails
+		{"example..org", true, 0},
+	}
+	for i, tc := range tests {
+		names, err := parseNames(strings.NewReader(tc.input))
+		if tc.shouldErr && err == nil {
+			t.Errorf("Test %d: Expected error but found nil", i)
+			continue
+		} else if !tc.shouldErr && err != nil {
+			t.Errorf("Test %d: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if !tc.shouldErr && len(names) != tc.entries {
+			t.Errorf("Test %d: Expected %d entries, got %d", i, tc.entries, len(names))
+		}
+	}
+}
+
+func TestMatchName(t *testing.T) {
+	names := map[string]struct{}{"example.org.": {}, "ads.example.net.": {}}
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"example.org.", true},
+		{"www.example.org.", true},
+		{"a.b.ads.example.net.", true},
+		{"example.net.", false},
+		{"badexample.org.", false},
+		{".", false},
+	}
+	for i, tc := range tests {
+		if got := matchName(names, tc.name); got != tc.expected {
+			t.Errorf("Test %d: Expected %t for %s, got %t", i, tc.expected, tc.name, got)
+		}
+	}
+}
+
+func TestListReload(t *testing.T) {
+	dir := t.TempDir()
+	nets := filepath.Join(dir, "nets.txt")
+	names := filepath.Join(dir, "names.txt")
+	if err := os.WriteFile(nets, []byte("192.168.0.0/16\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(names, []byte("ads.example.org\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := parse(caddy.NewTestController("dns", `acl . {
+		block net file `+nets+` name file `+names+`
+		drop net file `+nets+`
+	}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if len(a.lists) != 2 {
+		t.Fatalf("Expected each file to be read once, got %d lists", len(a.lists))
+	}
+	l := a.Rules[0].policies[0].nets[0]
+	if l != a.Rules[0].policies[1].nets[0] {
+		t.Errorf("Expected policies to share the list of the same file")
+	}
+
+	// The next handler responds, so requests that are dropped are the ones without a response.
+	a.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+	serve := func(name, ip string) int {
+		w := &testResponseWriter{}
+		w.setRemoteIP(ip)
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		a.ServeDNS(context.TODO(), w, m)
+		if w.Msg == nil {
+			return -1
+		}
+		return w.Rcode
+	}
+	if rcode := serve("www.ads.example.org.", "192.168.1.1"); rcode != dns.RcodeRefused {
+		t.Errorf("Expected REFUSED, got %d", rcode)
+	}
+	if rcode := serve("www.ads.example.org.", "10.1.1.1"); rcode != dns.RcodeSuccess {
+		t.Errorf("Expected NOERROR, got %d", rcode)
+	}
+
+	// A list that fails to parse is kept as it is.
+	mtime := time.Now().Add(time.Minute)
+	if err := os.WriteFile(nets, []byte("10.0.0.0/8\nbogus\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(nets, mtime, mtime)
+	if err := l.read(); err == nil {
+		t.Errorf("Expected an error reading an invalid list")
+	}
+	if !l.containsIP(net.ParseIP("192.168.1.1")) || l.containsIP(net.ParseIP("10.1.1.1")) {
+		t.Errorf("Expected the previous list to be kept")
+	}
+
+	mtime = mtime.Add(time.Minute)
+	if err := os.WriteFile(nets, []byte("10.0.0.0/8\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(nets, mtime, mtime)
+	if err := l.read(); err != nil {
+		t.Errorf("Expected no error, got %s", err)
+	}
+	if rcode := serve("www.ads.example.org.", "192.168.1.1"); rcode != dns.RcodeSuccess {
+		t.Errorf("Expected NOERROR after the reload, got %d", rcode)
+	}
+	if rcode := serve("www.ads.example.org.", "10.1.1.1"); rcode != dns.RcodeRefused {
+		t.Errorf("Expected REFUSED after the reload, got %d", rcode)
+	}
+	// The drop policy uses the same list, so it applies to other names.
+	if rcode := serve("www.example.org.", "10.1.1.1"); rcode != -1 {
+		t.Errorf("Expected no response after the reload, got %d", rcode)
+	}
+}
@@ -36,4 +36,25 @@ var (
 		Name:      "dropped_requests_total",
 		Help:      "Counter of DNS requests being dropped.",
 	}, []string{"server", "zone", "view"})
+	// ListEntries is the number of entries in a list file.
+	ListEntries = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "list_entries",
+		Help:      "The number of entries in a list file.",
+	}, []string{"file"})
+	// ListReloadTime is the timestamp of the last reload of a list file.
+	ListReloadTime = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "list_reload_timestamp_seconds",
+		Help:      "The timestamp of the last reload of a list file.",
+	}, []string{"file"})
+	// ListReloadFailures is the number of times a list file failed to reload.
+	ListReloadFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "list_reload_failures_total",
+		Help:      "Counter of list file reloads that failed.",
+	}, []string{"file"})
 )
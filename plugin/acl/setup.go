@@ -2,7 +2,9 @@ package acl
 
 import (
 	"net"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
@@ -14,6 +16,9 @@ import (
 
 const pluginName = "acl"
 
+// defaultReload is the default interval to check the list files for changes.
+const defaultReload = 5 * time.Second
+
 func init() { plugin.Register(pluginName, setup) }
 
 func newDefaultFilter() *iptree.Tree {
@@ -31,6 +36,18 @@ func setup(c *caddy.Controller) error {
 		return plugin.Error(pluginName, err)
 	}
 
+	if len(a.lists) > 0 && a.reload > 0 {
+		stop := make(chan struct{})
+		c.OnStartup(func() error {
+			go a.periodicListUpdate(stop)
+			return nil
+		})
+		c.OnShutdown(func() error {
+			close(stop)
+			return nil
+		})
+	}
+
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		a.Next = next
 		return a
@@ -39,8 +56,49 @@ func setup(c *caddy.Controller) error {
 	return nil
 }
 
+// periodicListUpdate checks the lists for changes until stop is closed.
+func (a ACL) periodicListUpdate(stop chan struct{}) {
+	ticker := time.NewTicker(a.reload)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			for _, l := range a.lists {
+				if err := l.read(); err != nil {
+					ListReloadFailures.WithLabelValues(l.path).Inc()
+					log.Errorf("Failed to reload list, keeping the previous one: %s", err)
+				}
+			}
+		}
+	}
+}
+
 func parse(c *caddy.Controller) (ACL, error) {
-	a := ACL{}
+	a := ACL{reload: defaultReload}
+	root := dnsserver.GetConfig(c).Root
+	lists := make(map[listKind]map[string]*list)
+	// getList returns the list of kind in the file path, reading it the first time.
+	getList := func(kind listKind, path string) (*list, error) {
+		if !filepath.IsAbs(path) && root != "" {
+			path = filepath.Join(root, path)
+		}
+		if lists[kind] == nil {
+			lists[kind] = make(map[string]*list)
+		}
+		if l, ok := lists[kind][path]; ok {
+			return l, nil
+		}
+		l := &list{path: path, kind: kind}
+		if err := l.read(); err != nil {
+			return nil, err
+		}
+		lists[kind][path] = l
+		a.lists = append(a.lists, l)
+		return l, nil
+	}
+
 	for c.Next() {
 		r := rule{}
 		args := c.RemainingArgs()
@@ -50,6 +108,18 @@ func parse(c *caddy.Controller) (ACL, error) {
 			p := policy{}
 
 			action := strings.ToLower(c.Val())
+			if action == "reload" {
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return a, c.ArgErr()
+				}
+				reload, err := time.ParseDuration(args[0])
+				if err != nil || reload < 0 {
+					return a, c.Errf("invalid duration for reload %q", args[0])
+				}
+				a.reload = reload
+				continue
+			}
 			if action == "allow" {
 				p.action = actionAllow
 			} else if action == "block" {
@@ -59,7 +129,7 @@ func parse(c *caddy.Controller) (ACL, error) {
 			} else if action == "drop" {
 				p.action = actionDrop
 			} else {
-				return a, c.Errf("unexpected token %q; expect 'allow', 'block', 'filter' or 'drop'", c.Val())
+				return a, c.Errf("unexpected token %q; expect 'allow', 'block', 'filter', 'drop' or 'reload'", c.Val())
 			}
 
 			p.qtypes = make(map[uint16]struct{})
@@ -71,7 +141,7 @@ func parse(c *caddy.Controller) (ACL, error) {
 			remainingTokens := c.RemainingArgs()
 			for len(remainingTokens) > 0 {
 				if !isPreservedIdentifier(remainingTokens[0]) {
-					return a, c.Errf("unexpected token %q; expect 'type | net'", remainingTokens[0])
+					return a, c.Errf("unexpected token %q; expect 'type | net | name | group'", remainingTokens[0])
 				}
 				section := strings.ToLower(remainingTokens[0])
 
@@ -102,11 +172,23 @@ func parse(c *caddy.Controller) (ACL, error) {
 					}
 				case "net":
 					hasNetSection = true
-					for _, token := range tokens {
+					for j := 0; j < len(tokens); j++ {
+						token := tokens[j]
 						if token == "*" {
 							p.filter = newDefaultFilter()
 							break
 						}
+						if strings.ToLower(token) == "file" {
+							if j++; j == len(tokens) {
+								return a, c.Err("file requires a path")
+							}
+							l, err := getList(listNet, tokens[j])
+							if err != nil {
+								return a, c.Err(err.Error())
+							}
+							p.nets = append(p.nets, l)
+							continue
+						}
 						token = normalize(token)
 						_, source, err := net.ParseCIDR(token)
 						if err != nil {
@@ -114,8 +196,38 @@ func parse(c *caddy.Controller) (ACL, error) {
 						}
 						p.filter.InplaceInsertNet(source, struct{}{})
 					}
+				case "group":
+					if p.groups == nil {
+						p.groups = make(map[string]struct{})
+					}
+					for _, token := range tokens {
+						p.groups[token] = struct{}{}
+					}
+				case "name":
+					for j := 0; j < len(tokens); j++ {
+						token := tokens[j]
+						if strings.ToLower(token) == "file" {
+							if j++; j == len(tokens) {
+								return a, c.Err("file requires a path")
+							}
+							l, err := getList(listName, tokens[j])
+							if err != nil {
+								return a, c.Err(err.Error())
+							}
+							p.nameLists = append(p.nameLists, l)
+							continue
+						}
+						name, err := normalizeName(token)
+						if err != nil {
+							return a, c.Err(err.Error())
+						}
+						if p.names == nil {
+							p.names = make(map[string]struct{})
+						}
+						p.names[name] = struct{}{}
+					}
 				default:
-					return a, c.Errf("unexpected token %q; expect 'type | net'", section)
+					return a, c.Errf("unexpected token %q; expect 'type | net | name | group'", section)
 				}
 			}
 
@@ -138,7 +250,7 @@ func parse(c *caddy.Controller) (ACL, error) {
 
 func isPreservedIdentifier(token string) bool {
 	identifier := strings.ToLower(token)
-	return identifier == "type" || identifier == "net"
+	return identifier == "type" || identifier == "net" || identifier == "name" || identifier == "group"
 }
 
 // normalize appends '/32' for any single IPv4 address and '/128' for IPv6.
@@ -95,6 +95,49 @@ func TestSetup(t *testing.T) {
 			}`,
 			false,
 		},
+		{
+			"Name 1",
+			`acl example.org {
+				block name ads.example.org tracker.example.org net 192.168.0.0/16
+			}`,
+			false,
+		},
+		{
+			"Reload 1",
+			`acl {
+				reload 10s
+				block net 192.168.0.0/16
+			}`,
+			false,
+		},
+		{
+			"Illegal name 1",
+			`acl {
+				block name example..org
+			}`,
+			true,
+		},
+		{
+			"Missing file 1",
+			`acl {
+				block net file /does/not/exist
+			}`,
+			true,
+		},
+		{
+			"Missing file 2",
+			`acl {
+				block name file
+			}`,
+			true,
+		},
+		{
+			"Illegal reload 1",
+			`acl {
+				reload -1s
+			}`,
+			true,
+		},
 		{
 			"Missing argument 1",
 			`acl {
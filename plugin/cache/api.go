@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/coredns/coredns/plugin/pkg/reuseport"
+)
+
+// api is an HTTP listener to inspect and purge caches. Caches that configure the same address share it.
+type api struct {
+	addr string
+	ln   net.Listener
+
+	mu     sync.RWMutex
+	caches []*Cache
+}
+
+// defaultAPIAddr is the address of the HTTP listener when api is used without one.
+const defaultAPIAddr = "localhost:8054"
+
+// apis holds the running listeners by address.
+var apis = struct {
+	sync.Mutex
+	m map[string]*api
+}{m: make(map[string]*api)}
+
+// startAPI adds c to the listener on addr, starting it if c is the first cache to use it.
+func startAPI(addr string, c *Cache) error {
+	apis.Lock()
+	defer apis.Unlock()
+
+	a, ok := apis.m[addr]
+	if !ok {
+		ln, err := reuseport.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		a = &api{addr: addr, ln: ln}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/cache", a.serveHTTP)
+		go func() { http.Serve(ln, mux) }()
+		apis.m[addr] = a
+	}
+
+	a.mu.Lock()
+	a.caches = append(a.caches, c)
+	a.mu.Unlock()
+	return nil
+}
+
+// stopAPI removes c from the listener on addr, and stops it if no cache uses it anymore.
+func stopAPI(addr string, c *Cache) error {
+	apis.Lock()
+	defer apis.Unlock()
+
+	a, ok := apis.m[addr]
+	if !ok {
+		return nil
+	}
+	a.mu.Lock()
+	for i := range a.caches {
+		if a.caches[i] == c {
+			a.caches = append(a.caches[:i], a.caches[i+1:]...)
+			break
+		}
+	}
+	n := len(a.caches)
+	a.mu.Unlock()
+	if n > 0 {
+		return nil
+	}
+	delete(apis.m, addr)
+	return a.ln.Close()
+}
+
+// serveHTTP lists the entries with GET and purges them with DELETE. The entries are selected with the "name"
+// parameter for a single name, or "suffix" for a name and all names below it. Purging everything requires
+// "all=true". Caches with a token are only used when the request carries it as a bearer token.
+func (a *api) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name, suffix := q.Get("name"), false
+	if s := q.Get("suffix"); s != "" {
+		name, suffix = s, true
+	}
+	if name == "" {
+		if r.Method == http.MethodDelete && q.Get("all") != "true" {
+			http.Error(w, "name, suffix or all=true is required", http.StatusBadRequest)
+			return
+		}
+		suffix = true
+	}
+	match := matcher(name, suffix)
+
+	a.mu.RLock()
+	caches := authorized(r, a.caches)
+	n := len(a.caches)
+	a.mu.RUnlock()
+	if len(caches) == 0 && n > 0 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		entries := []entry{}
+		for _, c := range caches {
+			entries = append(entries, c.entries(match)...)
+		}
+		writeJSON(w, entries)
+	case http.MethodDelete:
+		n := 0
+		for _, c := range caches {
+			p := c.purge(match)
+			cachePurges.WithLabelValues("http", c.zonesMetricLabel, c.viewMetricLabel).Add(float64(p))
+			n += p
+		}
+		log.Infof("Purged %d entries for %q, requested by %s", n, name, r.RemoteAddr)
+		writeJSON(w, struct {
+			Purged int `json:"purged"`
+		}{n})
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// authorized returns the caches that r may access: the ones without a token, and the ones whose token is given
+// as the bearer token of r.
+func authorized(r *http.Request, caches []*Cache) []*Cache {
+	token := ""
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token = strings.TrimPrefix(h, "Bearer ")
+	}
+	var allowed []*Cache
+	for _, c := range caches {
+		if c.apiToken == "" || subtle.ConstantTimeCompare([]byte(c.apiToken), []byte(token)) == 1 {
+			allowed = append(allowed, c)
+		}
+	}
+	return allowed
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPI(t *testing.T) {
+	c := New()
+	fillCache(t, c, "example.org.", "www.example.org.", "example.net.")
+	a := &api{caches: []*Cache{c}}
+
+	tests := []struct {
+		method   string
+		query    string
+		status   int
+		expected int // entries listed or purged
+	}{
+		{http.MethodGet, "", http.StatusOK, 3},
+		{http.MethodGet, "name=example.org", http.StatusOK, 1},
+		{http.MethodGet, "suffix=example.org", http.StatusOK, 2},
+		{http.MethodPost, "", http.StatusMethodNotAllowed, 0},
+		{http.MethodDelete, "", http.StatusBadRequest, 0},
+		{http.MethodDelete, "name=www.example.org", http.StatusOK, 1},
+		{http.MethodGet, "", http.StatusOK, 2},
+		{http.MethodDelete, "all=true", http.StatusOK, 2},
+		{http.MethodGet, "", http.StatusOK, 0},
+	}
+
+	for i, tc := range tests {
+		rec := httptest.NewRecorder()
+		a.serveHTTP(rec, httptest.NewRequest(tc.method, "/cache?"+tc.query, nil))
+		if rec.Code != tc.status {
+			t.Errorf("Test %d: expected status %d, got %d", i, tc.status, rec.Code)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+
+		n := 0
+		if tc.method == http.MethodGet {
+			var entries []entry
+			if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+				t.Fatalf("Test %d: expected a list of entries, got %s", i, err)
+			}
+			n = len(entries)
+		} else {
+			var purged struct{ Purged int }
+			if err := json.Unmarshal(rec.Body.Bytes(), &purged); err != nil {
+				t.Fatalf("Test %d: expected the number of purged entries, got %s", i, err)
+			}
+			n = purged.Purged
+		}
+		if n != tc.expected {
+			t.Errorf("Test %d: expected %d entries, got %d", i, tc.expected, n)
+		}
+	}
+}
+
+func TestAPIToken(t *testing.T) {
+	c1, c2 := New(), New()
+	fillCache(t, c1, "example.org.")
+	fillCache(t, c2, "example.net.")
+	c2.apiToken = "s3cret"
+	a := &api{caches: []*Cache{c1, c2}}
+
+	tests := []struct {
+		caches        []*Cache
+		authorization string
+		status        int
+		expected      int // entries listed
+	}{
+		{[]*Cache{c1, c2}, "", http.StatusOK, 1},
+		{[]*Cache{c1, c2}, "Bearer s3cret", http.StatusOK, 2},
+		{[]*Cache{c1, c2}, "Bearer wrong", http.StatusOK, 1},
+		{[]*Cache{c2}, "", http.StatusUnauthorized, 0},
+		{[]*Cache{c2}, "s3cret", http.StatusUnauthorized, 0},
+		{[]*Cache{c2}, "Bearer s3cret", http.StatusOK, 1},
+	}
+
+	for i, tc := range tests {
+		a.caches = tc.caches
+		req := httptest.NewRequest(http.MethodGet, "/cache", nil)
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		rec := httptest.NewRecorder()
+		a.serveHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("Test %d: expected status %d, got %d", i, tc.status, rec.Code)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		var entries []entry
+		if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+			t.Fatalf("Test %d: expected a list of entries, got %s", i, err)
+		}
+		if len(entries) != tc.expected {
+			t.Errorf("Test %d: expected %d entries, got %d", i, tc.expected, len(entries))
+		}
+	}
+}
+
+func TestAPIShared(t *testing.T) {
+	c1, c2 := New(), New()
+	if err := startAPI("127.0.0.1:0", c1); err != nil {
+		t.Fatal(err)
+	}
+	if err := startAPI("127.0.0.1:0", c2); err != nil {
+		t.Fatal(err)
+	}
+	a := apis.m["127.0.0.1:0"]
+	if len(a.caches) != 2 {
+		t.Errorf("Expected 2 caches to share the listener, got %d", len(a.caches))
+	}
+
+	stopAPI("127.0.0.1:0", c1)
+	if _, ok := apis.m["127.0.0.1:0"]; !ok {
+		t.Errorf("Expected the listener to keep running while a cache uses it")
+	}
+	stopAPI("127.0.0.1:0", c2)
+	if _, ok := apis.m["127.0.0.1:0"]; ok {
+		t.Errorf("Expected the listener to be stopped")
+	}
+}
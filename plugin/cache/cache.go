@@ -4,12 +4,14 @@ package cache
 import (
 	"hash/fnv"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/pkg/cache"
 	"github.com/coredns/coredns/plugin/pkg/dnsutil"
 	"github.com/coredns/coredns/plugin/pkg/response"
+	"github.com/coredns/coredns/plugin/pkg/singleflight"
 	"github.com/coredns/coredns/request"
 
 	"github.com/horahoradev/dns"
@@ -40,14 +42,43 @@ type Cache struct {
 	duration   time.Duration
 	percentage int
 
+	// Popularity, an item's score halves every halfLife. With decay, prefetching and refreshing in the
+	// background use it instead of the number of hits within duration.
+	halfLife time.Duration
+	decay    bool
+
+	// Warm-up
+	warmup    []question // questions to resolve at startup
+	warmupTop int        // number of the most popular questions in the snapshot to resolve at startup
+
 	// Stale serve
-	staleUpTo   time.Duration
-	verifyStale bool
+	staleUpTo    time.Duration
+	verifyStale  bool
+	staleTimeout time.Duration // client response timeout when verifying, 0 waits for the refresh
+
+	// Identical requests that miss the cache are coalesced.
+	inflight *singleflight.Group
+
+	// Memory budget in bytes for both caches, 0 means they are only limited in entries.
+	maxBytes int64
 
 	// Positive/negative zone exceptions
 	pexcept []string
 	nexcept []string
 
+	// EDNS Client Subnet
+	ecsVariants int          // maximum number of subnets a question is cached for, 0 disables caching scoped replies
+	variants    *cache.Cache // subnets per question
+
+	// Snapshots
+	persist *persist
+	loading uint32 // set to 1 while the snapshot is being loaded
+
+	// Inspection and purging
+	api       string              // address of the HTTP listener
+	apiToken  string              // bearer token required by the HTTP listener, if not empty
+	purgeKeys map[string]struct{} // TSIG keys that can sign purge messages
+
 	// Testing.
 	now func() time.Time
 }
@@ -56,20 +87,24 @@ type Cache struct {
 // caller to set the Next handler.
 func New() *Cache {
 	return &Cache{
-		Zones:      []string{"."},
-		pcap:       defaultCap,
-		pcache:     cache.New(defaultCap),
-		pttl:       maxTTL,
-		minpttl:    minTTL,
-		ncap:       defaultCap,
-		ncache:     cache.New(defaultCap),
-		nttl:       maxNTTL,
-		minnttl:    minNTTL,
-		failttl:    minNTTL,
-		prefetch:   0,
-		duration:   1 * time.Minute,
-		percentage: 10,
-		now:        time.Now,
+		Zones:       []string{"."},
+		pcap:        defaultCap,
+		pcache:      cache.New(defaultCap),
+		pttl:        maxTTL,
+		minpttl:     minTTL,
+		ncap:        defaultCap,
+		ncache:      cache.New(defaultCap),
+		nttl:        maxNTTL,
+		minnttl:     minNTTL,
+		failttl:     minNTTL,
+		prefetch:    0,
+		duration:    1 * time.Minute,
+		percentage:  10,
+		halfLife:    defaultHalfLife,
+		ecsVariants: defaultECSVariants,
+		variants:    cache.New(2 * defaultCap),
+		inflight:    new(singleflight.Group),
+		now:         time.Now,
 	}
 }
 
@@ -132,6 +167,10 @@ type ResponseWriter struct {
 
 	wildcardFunc func() string // function to retrieve wildcard name that synthesized the result.
 
+	share  bool     // When true keep a copy of the reply for coalesced requests.
+	shared *dns.Msg // Copy of the reply written to the client.
+	scoped bool     // When true the reply only applies to the client's subnet.
+
 	pexcept []string // positive zone exceptions
 	nexcept []string // negative zone exceptions
 }
@@ -175,6 +214,20 @@ func (w *ResponseWriter) WriteMsg(res *dns.Msg) error {
 
 	// key returns empty string for anything we don't want to cache.
 	hasKey, key := key(w.state.Name(), res, mt, w.do)
+	// Replies that only apply to the client's subnet are cached per subnet.
+	s, scoped, valid := replySubnet(w.state, res)
+	if hasKey {
+		switch {
+		case !valid:
+			hasKey = false
+			cacheDrops.WithLabelValues(w.server, w.zonesMetricLabel, w.viewMetricLabel).Inc()
+		case scoped && w.ecsVariants == 0:
+			hasKey = false
+		case scoped:
+			key = w.addVariant(key, s)
+		}
+	}
+	w.scoped = scoped
 
 	msgTTL := dnsutil.MinimalTTL(res, mt)
 	var duration time.Duration
@@ -191,6 +244,10 @@ func (w *ResponseWriter) WriteMsg(res *dns.Msg) error {
 			w.set(res, key, mt, duration)
 			cacheSize.WithLabelValues(w.server, Success, w.zonesMetricLabel, w.viewMetricLabel).Set(float64(w.pcache.Len()))
 			cacheSize.WithLabelValues(w.server, Denial, w.zonesMetricLabel, w.viewMetricLabel).Set(float64(w.ncache.Len()))
+			if w.maxBytes > 0 {
+				cacheBytes.WithLabelValues(w.server, Success, w.zonesMetricLabel, w.viewMetricLabel).Set(float64(w.pcache.Bytes()))
+				cacheBytes.WithLabelValues(w.server, Denial, w.zonesMetricLabel, w.viewMetricLabel).Set(float64(w.ncache.Bytes()))
+			}
 		} else {
 			// Don't log it, but increment counter
 			cacheDrops.WithLabelValues(w.server, w.zonesMetricLabel, w.viewMetricLabel).Inc()
@@ -202,10 +259,16 @@ func (w *ResponseWriter) WriteMsg(res *dns.Msg) error {
 	}
 
 	// Apply capped TTL to this reply to avoid jarring TTL experience 1799 -> 8 (e.g.)
+	// The records are shared with the item just cached, which coalesced requests may be reading, so copy them.
 	ttl := uint32(duration.Seconds())
-	res.Answer = filterRRSlice(res.Answer, ttl, false)
-	res.Ns = filterRRSlice(res.Ns, ttl, false)
-	res.Extra = filterRRSlice(res.Extra, ttl, false)
+	res.Answer = filterRRSlice(res.Answer, ttl, true)
+	res.Ns = filterRRSlice(res.Ns, ttl, true)
+	res.Extra = filterRRSlice(res.Extra, ttl, true)
+	// The client gets its own ECS option back, with the scope of the reply, and none if it didn't send one.
+	removeECS(res)
+	if ecs := ecsOption(w.state.Req); ecs != nil && valid {
+		setECS(res, w.state.Req, ecs, s.scope)
+	}
 
 	if !w.do && !w.ad {
 		// unset AD bit if requester is not OK with DNSSEC
@@ -213,6 +276,9 @@ func (w *ResponseWriter) WriteMsg(res *dns.Msg) error {
 		res.AuthenticatedData = false
 	}
 
+	if w.share {
+		w.shared = res.Copy()
+	}
 	return w.ResponseWriter.WriteMsg(res)
 }
 
@@ -226,6 +292,7 @@ func (w *ResponseWriter) set(m *dns.Msg, key uint64, mt response.Type, duration
 			return
 		}
 		i := newItem(m, w.now(), duration)
+		i.do = w.do
 		if w.wildcardFunc != nil {
 			i.wildcard = w.wildcardFunc()
 		}
@@ -243,6 +310,7 @@ func (w *ResponseWriter) set(m *dns.Msg, key uint64, mt response.Type, duration
 			return
 		}
 		i := newItem(m, w.now(), duration)
+		i.do = w.do
 		if w.wildcardFunc != nil {
 			i.wildcard = w.wildcardFunc()
 		}
@@ -272,28 +340,43 @@ func (w *ResponseWriter) Write(buf []byte) (int, error) {
 type verifyStaleResponseWriter struct {
 	*ResponseWriter
 	refreshed bool // set to true if the last WriteMsg wrote to ResponseWriter, false otherwise.
+
+	mu       sync.Mutex
+	detached bool // set to true when the client got the stale entry, later replies only update the cache.
 }
 
 // newVerifyStaleResponseWriter returns a ResponseWriter to be used when verifying stale cache
 // entries. It only forward writes if an entry was successfully refreshed according to RFC8767,
 // section 4 (response is NoError or NXDomain), and ignores any other response.
 func newVerifyStaleResponseWriter(w *ResponseWriter) *verifyStaleResponseWriter {
-	return &verifyStaleResponseWriter{
-		w,
-		false,
-	}
+	return &verifyStaleResponseWriter{ResponseWriter: w}
 }
 
 // WriteMsg implements the dns.ResponseWriter interface.
 func (w *verifyStaleResponseWriter) WriteMsg(res *dns.Msg) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	w.refreshed = false
 	if res.Rcode == dns.RcodeSuccess || res.Rcode == dns.RcodeNameError {
-		w.refreshed = true
+		w.refreshed = !w.detached
 		return w.ResponseWriter.WriteMsg(res) // stores to the cache and send to client
 	}
 	return nil // else discard
 }
 
+// detach makes later replies only update the cache, because the client is sent the stale entry. It returns false
+// if the client already got a refreshed reply.
+func (w *verifyStaleResponseWriter) detach() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.refreshed {
+		return false
+	}
+	w.detached = true
+	w.ResponseWriter.prefetch = true
+	return true
+}
+
 const (
 	maxTTL  = dnsutil.MaximumDefaulTTL
 	minTTL  = dnsutil.MinimalDefaultTTL
@@ -424,7 +424,7 @@ func TestServeFromStaleCacheFetchVerify(t *testing.T) {
 		{"cached.org.", dns.RcodeSuccess, 200, 3, dns.RcodeSuccess, 200},
 
 		// After the TTL expired, if the server fails we should get the cached entry
-		{"cached.org.", dns.RcodeServerFailure, 200, 7, dns.RcodeSuccess, 0},
+		{"cached.org.", dns.RcodeServerFailure, 200, 7, dns.RcodeSuccess, staleTTL},
 
 		// After 1 more minutes, if the server serves nxdomain we should see them (despite being within the serve stale period)
 		{"cached.org.", dns.RcodeNameError, 150, 8, dns.RcodeNameError, 150},
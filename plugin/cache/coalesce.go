@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"context"
+	"hash/fnv"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+// flight is the outcome of a request that was sent to the next plugin on behalf of identical requests.
+type flight struct {
+	rcode  int
+	msg    *dns.Msg // reply written to the client, nil if there was none
+	scoped bool     // the reply only applies to the client's subnet
+}
+
+// coalesce sends the request to the next plugin, unless an identical request is already in flight. In that case
+// it waits for it to finish and replies from the cache, or, if the reply couldn't be cached, with a copy of it.
+// Only if neither is possible the request is sent to the next plugin after all.
+func (c *Cache) coalesce(ctx context.Context, state request.Request, crr *ResponseWriter) (int, error) {
+	k := coalesceKey(state)
+	leader := false
+	v, err := c.inflight.Do(k, func() (interface{}, error) {
+		leader = true
+		crr.share = true
+		rcode, err := c.doRefresh(ctx, state, crr)
+		return &flight{rcode: rcode, msg: crr.shared, scoped: crr.scoped}, err
+	})
+	if leader {
+		return v.(*flight).rcode, err
+	}
+
+	now := c.now().UTC()
+	if i := c.exists(state); i != nil && i.ttl(now) > 0 {
+		cacheCoalesced.WithLabelValues(crr.server, "cache", c.zonesMetricLabel, c.viewMetricLabel).Inc()
+		resp := i.toMsg(state.Req, now, crr.do, crr.ad)
+		if ecs := ecsOption(state.Req); ecs != nil {
+			setECS(resp, state.Req, ecs, i.scope)
+		}
+		crr.ResponseWriter.WriteMsg(resp)
+		return dns.RcodeSuccess, nil
+	}
+
+	f := v.(*flight)
+	if f.msg == nil || f.scoped {
+		return c.doRefresh(ctx, state, crr)
+	}
+	cacheCoalesced.WithLabelValues(crr.server, "shared", c.zonesMetricLabel, c.viewMetricLabel).Inc()
+	resp := f.msg.Copy()
+	resp.Id = state.Req.Id
+	resp.Question = append([]dns.Question(nil), state.Req.Question...)
+	if !crr.do && !crr.ad {
+		resp.AuthenticatedData = false
+	}
+	if state.Req.IsEdns0() == nil {
+		removeOPT(resp)
+	}
+	crr.ResponseWriter.WriteMsg(resp)
+	return f.rcode, err
+}
+
+// coalesceKey returns the key of the requests that are identical to state. Unlike the cache key it includes the
+// CD bit, a reply to a request with CD may contain data that failed validation.
+func coalesceKey(state request.Request) uint64 {
+	h := fnv.New64()
+	for _, bit := range []bool{state.Do(), state.Req.CheckingDisabled} {
+		if bit {
+			h.Write(one)
+		} else {
+			h.Write(zero)
+		}
+	}
+	qtype := state.QType()
+	h.Write([]byte{byte(qtype >> 8)})
+	h.Write([]byte{byte(qtype)})
+	h.Write([]byte(state.Name()))
+	return h.Sum64()
+}
+
+// removeOPT removes the OPT RR from m.
+func removeOPT(m *dns.Msg) {
+	extra := m.Extra[:0]
+	for _, rr := range m.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+	m.Extra = extra
+}
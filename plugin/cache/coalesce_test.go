@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/horahoradev/dns"
+)
+
+func TestCoalesce(t *testing.T) {
+	tests := []struct {
+		truncated bool  // truncated replies aren't cached, so waiters get a copy
+		cd        bool  // half of the requests set CD, they aren't identical to the others
+		queries   int32 // queries to the backend
+	}{
+		{false, false, 1},
+		{true, false, 1},
+		{false, true, 2},
+		{true, true, 2},
+	}
+
+	for i, tc := range tests {
+		var queries int32
+		called := make(chan struct{}, 1)
+		release := make(chan struct{})
+
+		c := New()
+		c.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+			atomic.AddInt32(&queries, 1)
+			called <- struct{}{}
+			<-release
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Truncated = tc.truncated
+			m.Answer = []dns.RR{test.A("example.org. 300 IN A 127.0.0.1")}
+			w.WriteMsg(m)
+			return dns.RcodeSuccess, nil
+		})
+
+		const n = 10
+		recs := make([]*dnstest.Recorder, n)
+		var wg sync.WaitGroup
+		serve := func(j int) {
+			defer wg.Done()
+			m := new(dns.Msg)
+			m.SetQuestion("example.org.", dns.TypeA)
+			m.Id = uint16(j)
+			m.CheckingDisabled = tc.cd && j%2 == 1
+			recs[j] = dnstest.NewRecorder(&test.ResponseWriter{})
+			c.ServeDNS(context.TODO(), recs[j], m)
+		}
+
+		wg.Add(n)
+		go serve(0)
+		<-called
+		for j := 1; j < n; j++ {
+			go serve(j)
+		}
+		// Give the others time to wait for the first one.
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		if q := atomic.LoadInt32(&queries); q != tc.queries {
+			t.Errorf("Test %d: expected %d queries to the backend, got %d", i, tc.queries, q)
+		}
+		for j, rec := range recs {
+			if rec.Msg == nil || len(rec.Msg.Answer) != 1 {
+				t.Fatalf("Test %d: expected an answer for request %d, got %v", i, j, rec.Msg)
+			}
+			if rec.Msg.Id != uint16(j) {
+				t.Errorf("Test %d: expected ID %d, got %d", i, j, rec.Msg.Id)
+			}
+		}
+	}
+}
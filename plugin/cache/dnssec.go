@@ -12,12 +12,12 @@ func filterRRSlice(rrs []dns.RR, ttl uint32, dup bool) []dns.RR {
 		if r.Header().Rrtype == dns.TypeOPT {
 			continue
 		}
-		r.Header().Ttl = ttl
+		// With dup, copy before setting the TTL so the RRs in rrs, which may be shared, are left untouched.
 		if dup {
-			rs[j] = dns.Copy(r)
-		} else {
-			rs[j] = r
+			r = dns.Copy(r)
 		}
+		r.Header().Ttl = ttl
+		rs[j] = r
 		j++
 	}
 	return rs[:j]
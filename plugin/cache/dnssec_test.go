@@ -124,3 +124,21 @@ func TestFilterRRSlice(t *testing.T) {
 		t.Errorf("Expected 2 RRSIGs after filtering, got %d", rrsig)
 	}
 }
+
+func TestFilterRRSliceDup(t *testing.T) {
+	rrs := []dns.RR{
+		test.A("leptone.example.org.	1781	IN	A	195.201.182.103"),
+		test.OPT(4096, false),
+	}
+
+	filtered := filterRRSlice(rrs, 10, true)
+	if len(filtered) != 1 || filtered[0].Header().Ttl != 10 {
+		t.Fatalf("Expected 1 RR with TTL 10 after filtering, got %v", filtered)
+	}
+	if rrs[0].Header().Ttl != 1781 {
+		t.Errorf("Expected the TTL of the shared RR to be left untouched, got %d", rrs[0].Header().Ttl)
+	}
+	if filtered[0] == rrs[0] {
+		t.Errorf("Expected a copy of the RR")
+	}
+}
@@ -0,0 +1,217 @@
+package cache
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"net"
+	"sync"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+// subnet is the client subnet a cached reply applies to, see RFC 7871, section 7.3. Replies with a scope prefix
+// length of 0 apply to all clients and are cached under the key of the question alone.
+type subnet struct {
+	family uint16
+	scope  uint8
+	addr   string // address masked to scope
+	key    uint64 // key the reply is cached under
+}
+
+// variants are the subnets for which replies to the same question are cached, oldest first.
+type variants struct {
+	sync.Mutex
+	subnets []subnet
+}
+
+// clientSubnet returns the family, source prefix length and address of the client, taken from its ECS option,
+// or, if it didn't send one, from its address.
+func clientSubnet(state request.Request) (uint16, uint8, net.IP) {
+	if ecs := ecsOption(state.Req); ecs != nil {
+		return ecs.Family, ecs.SourceNetmask, ecs.Address
+	}
+	ip := net.ParseIP(state.IP())
+	if ip4 := ip.To4(); ip4 != nil {
+		return 1, net.IPv4len * 8, ip4
+	}
+	return 2, net.IPv6len * 8, ip
+}
+
+// replySubnet returns the subnet that the reply res to the request in state applies to. The second return value
+// is false if the reply applies to all clients. The third one is false if the ECS option in the reply doesn't
+// match the one in the request, such a reply must not be cached.
+func replySubnet(state request.Request, res *dns.Msg) (subnet, bool, bool) {
+	ecs := ecsOption(res)
+	if ecs == nil {
+		return subnet{}, false, true
+	}
+	if req := ecsOption(state.Req); req != nil {
+		if req.Family != ecs.Family || req.SourceNetmask != ecs.SourceNetmask ||
+			!mask(req.Address, req.Family, req.SourceNetmask).Equal(mask(ecs.Address, ecs.Family, ecs.SourceNetmask)) {
+			return subnet{}, false, false
+		}
+	}
+	// A scope longer than the source prefix length can't be used for more specific clients than the ones
+	// covered by the source, RFC 7871, section 7.3.1.
+	scope := ecs.SourceScope
+	if scope > ecs.SourceNetmask {
+		scope = ecs.SourceNetmask
+	}
+	if scope == 0 {
+		return subnet{}, false, true
+	}
+	addr := mask(ecs.Address, ecs.Family, scope)
+	if addr == nil {
+		return subnet{}, false, false
+	}
+	return subnet{family: ecs.Family, scope: scope, addr: addr.String()}, true, true
+}
+
+// contains returns true if the client with family, source prefix length and address ip is in s.
+func (s subnet) contains(family uint16, source uint8, ip net.IP) bool {
+	if s.family != family || source < s.scope {
+		return false
+	}
+	addr := mask(ip, family, s.scope)
+	return addr != nil && addr.String() == s.addr
+}
+
+// addVariant records that a reply for subnet s is cached for the question with key k, and returns the key to
+// cache it under. When there are already ecsVariants subnets for the question, the oldest is removed from the
+// cache.
+func (c *Cache) addVariant(k uint64, s subnet) uint64 {
+	s.key = subnetKey(k, s)
+
+	var v *variants
+	if el, ok := c.variants.Get(k); ok {
+		v = el.(*variants)
+	} else {
+		v = new(variants)
+		c.variants.Add(k, v)
+	}
+
+	v.Lock()
+	defer v.Unlock()
+	for _, x := range v.subnets {
+		if x.key == s.key {
+			return s.key
+		}
+	}
+	if len(v.subnets) >= c.ecsVariants {
+		oldest := v.subnets[0]
+		c.pcache.Remove(oldest.key)
+		c.ncache.Remove(oldest.key)
+		v.subnets = v.subnets[1:]
+	}
+	v.subnets = append(v.subnets, s)
+	return s.key
+}
+
+// keys returns the keys under which replies to the question with key k that apply to the client in state may be
+// cached: the subnets containing the client, most specific first, followed by k itself.
+func (c *Cache) keys(state request.Request, k uint64) []uint64 {
+	el, ok := c.variants.Get(k)
+	if !ok {
+		return []uint64{k}
+	}
+	v := el.(*variants)
+	family, source, ip := clientSubnet(state)
+
+	v.Lock()
+	var best []subnet
+	for _, s := range v.subnets {
+		if s.contains(family, source, ip) {
+			best = append(best, s)
+		}
+	}
+	v.Unlock()
+
+	// Insertion sort on scope, there are only a few variants.
+	for i := 1; i < len(best); i++ {
+		for j := i; j > 0 && best[j].scope > best[j-1].scope; j-- {
+			best[j], best[j-1] = best[j-1], best[j]
+		}
+	}
+	keys := make([]uint64, 0, len(best)+1)
+	for _, s := range best {
+		keys = append(keys, s.key)
+	}
+	return append(keys, k)
+}
+
+// subnetKey returns the key of the reply for subnet s to the question with key k.
+func subnetKey(k uint64, s subnet) uint64 {
+	h := fnv.New64()
+	b := make([]byte, 11)
+	binary.BigEndian.PutUint64(b, k)
+	binary.BigEndian.PutUint16(b[8:], s.family)
+	b[10] = s.scope
+	h.Write(b)
+	h.Write([]byte(s.addr))
+	return h.Sum64()
+}
+
+// mask returns ip masked to bits, or nil if ip isn't an address of family.
+func mask(ip net.IP, family uint16, bits uint8) net.IP {
+	switch family {
+	case 1:
+		if ip4 := ip.To4(); ip4 != nil && bits <= net.IPv4len*8 {
+			return ip4.Mask(net.CIDRMask(int(bits), net.IPv4len*8))
+		}
+	case 2:
+		if ip16 := ip.To16(); ip16 != nil && bits <= net.IPv6len*8 {
+			return ip16.Mask(net.CIDRMask(int(bits), net.IPv6len*8))
+		}
+	}
+	return nil
+}
+
+// ecsOption returns the ECS option in m, or nil if there is none.
+func ecsOption(m *dns.Msg) *dns.EDNS0_SUBNET {
+	o := m.IsEdns0()
+	if o == nil {
+		return nil
+	}
+	for _, s := range o.Option {
+		if e, ok := s.(*dns.EDNS0_SUBNET); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// removeECS removes the ECS options from m.
+func removeECS(m *dns.Msg) {
+	o := m.IsEdns0()
+	if o == nil || ecsOption(m) == nil {
+		return
+	}
+	options := make([]dns.EDNS0, 0, len(o.Option)-1)
+	for _, s := range o.Option {
+		if _, ok := s.(*dns.EDNS0_SUBNET); !ok {
+			options = append(options, s)
+		}
+	}
+	o.Option = options
+}
+
+// setECS adds the client's ECS option ecs with scope prefix length scope to the reply m to the request r.
+func setECS(m, r *dns.Msg, ecs *dns.EDNS0_SUBNET, scope uint8) {
+	o := m.IsEdns0()
+	if o == nil {
+		ro := r.IsEdns0()
+		m.SetEdns0(ro.UDPSize(), ro.Do())
+		o = m.IsEdns0()
+	}
+	o.Option = append(o.Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        ecs.Family,
+		SourceNetmask: ecs.SourceNetmask,
+		SourceScope:   scope,
+		Address:       ecs.Address,
+	})
+}
+
+const defaultECSVariants = 16
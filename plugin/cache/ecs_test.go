@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+func ecsMsg(addr string, source, scope uint8) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	m.SetEdns0(4096, false)
+	family, ip := uint16(1), net.ParseIP(addr)
+	if ip.To4() == nil {
+		family = 2
+	}
+	ecs := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: family, SourceNetmask: source, SourceScope: scope, Address: ip}
+	m.IsEdns0().Option = append(m.IsEdns0().Option, ecs)
+	return m
+}
+
+func TestReplySubnet(t *testing.T) {
+	plain := new(dns.Msg)
+	plain.SetQuestion("example.org.", dns.TypeA)
+
+	tests := []struct {
+		req, res *dns.Msg
+		scoped   bool
+		valid    bool
+		expected string
+	}{
+		{plain, plain, false, true, ""},
+		{ecsMsg("192.0.2.1", 32, 0), ecsMsg("192.0.2.1", 32, 0), false, true, ""},
+		{ecsMsg("192.0.2.1", 32, 0), ecsMsg("192.0.2.1", 32, 24), true, true, "192.0.2.0/24"},
+		{ecsMsg("192.0.2.1", 24, 0), ecsMsg("192.0.2.0", 24, 32), true, true, "192.0.2.0/24"},
+		{plain, ecsMsg("192.0.2.1", 24, 16), true, true, "192.0.0.0/16"},
+		{ecsMsg("2001:db8::1", 56, 0), ecsMsg("2001:db8::", 56, 48), true, true, "2001:db8::/48"},
+		// mismatches
+		{ecsMsg("192.0.2.1", 32, 0), ecsMsg("198.51.100.1", 32, 24), false, false, ""},
+		{ecsMsg("192.0.2.1", 32, 0), ecsMsg("192.0.2.1", 24, 24), false, false, ""},
+	}
+
+	for i, tc := range tests {
+		state := request.Request{W: &test.ResponseWriter{}, Req: tc.req}
+		s, scoped, valid := replySubnet(state, tc.res)
+		if scoped != tc.scoped || valid != tc.valid {
+			t.Errorf("Test %d: expected scoped %t and valid %t, got %t and %t", i, tc.scoped, tc.valid, scoped, valid)
+			continue
+		}
+		if !scoped {
+			continue
+		}
+		if got := fmt.Sprintf("%s/%d", s.addr, s.scope); got != tc.expected {
+			t.Errorf("Test %d: expected subnet %s, got %s", i, tc.expected, got)
+		}
+	}
+}
+
+// ecsBackend answers with the address of the client subnet, valid for the /24 it is in.
+func ecsBackend(queries *int) plugin.Handler {
+	return plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		*queries++
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Response, m.RecursionAvailable = true, true
+		addr := "127.0.0.1"
+		if ecs := ecsOption(r); ecs != nil {
+			addr = ecs.Address.String()
+			m.SetEdns0(4096, false)
+			e := *ecs
+			e.SourceScope = 24
+			m.IsEdns0().Option = append(m.IsEdns0().Option, &e)
+		}
+		m.Answer = []dns.RR{test.A("example.org. 300 IN A " + addr)}
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+}
+
+func TestCacheECS(t *testing.T) {
+	queries := 0
+	c := New()
+	c.ecsVariants = 2
+	c.Next = ecsBackend(&queries)
+
+	tests := []struct {
+		client   string // client subnet, empty for a query without ECS
+		queries  int    // total number of queries that reached the backend
+		expected string // address in the answer
+		scope    uint8
+	}{
+		{"192.0.2.1", 1, "192.0.2.1", 24},
+		{"192.0.2.200", 1, "192.0.2.1", 24},
+		{"198.51.100.1", 2, "198.51.100.1", 24},
+		{"198.51.100.2", 2, "198.51.100.1", 24},
+		// a third subnet evicts the oldest one
+		{"203.0.113.1", 3, "203.0.113.1", 24},
+		{"192.0.2.200", 4, "192.0.2.200", 24},
+		{"198.51.100.2", 5, "198.51.100.2", 24},
+		{"203.0.113.2", 6, "203.0.113.2", 24},
+		// without ECS, only replies for all clients apply
+		{"", 7, "127.0.0.1", 0},
+		{"", 7, "127.0.0.1", 0},
+		// and they apply to clients in subnets without a reply of their own
+		{"192.0.2.99", 7, "127.0.0.1", 0},
+	}
+
+	for i, tc := range tests {
+		req := new(dns.Msg)
+		req.SetQuestion("example.org.", dns.TypeA)
+		if tc.client != "" {
+			req = ecsMsg(tc.client, 32, 0)
+		}
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		c.ServeDNS(context.TODO(), rec, req)
+
+		if queries != tc.queries {
+			t.Errorf("Test %d: expected %d queries to the backend, got %d", i, tc.queries, queries)
+		}
+		if a := rec.Msg.Answer[0].(*dns.A).A.String(); a != tc.expected {
+			t.Errorf("Test %d: expected answer %s, got %s", i, tc.expected, a)
+		}
+		if tc.client == "" {
+			continue
+		}
+		ecs := ecsOption(rec.Msg)
+		if ecs == nil {
+			t.Fatalf("Test %d: expected an ECS option in the reply", i)
+		}
+		if ecs.Address.String() != tc.client || ecs.SourceScope != tc.scope {
+			t.Errorf("Test %d: expected ECS %s/32/%d, got %s", i, tc.client, tc.scope, ecs)
+		}
+	}
+}
+
+func TestCacheECSDisabled(t *testing.T) {
+	queries := 0
+	c := New()
+	c.ecsVariants = 0
+	c.Next = ecsBackend(&queries)
+
+	for i := 0; i < 2; i++ {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		c.ServeDNS(context.TODO(), rec, ecsMsg("192.0.2.1", 32, 0))
+	}
+	if queries != 2 {
+		t.Errorf("Expected replies with a non-zero scope not to be cached, got %d queries", queries)
+	}
+}
@@ -0,0 +1,55 @@
+package freq
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Popularity is an exponentially decayed count of events: every event adds 1 to the score, and the score
+// halves every half-life. Recent events therefore count for more than old ones, without a fixed window.
+type Popularity struct {
+	last  time.Time
+	score float64
+
+	sync.Mutex
+}
+
+// NewPopularity returns a new Popularity with a score of 0 at t.
+func NewPopularity(t time.Time) *Popularity {
+	return &Popularity{last: t}
+}
+
+// Hit adds an event at now and returns the new score.
+func (p *Popularity) Hit(halfLife time.Duration, now time.Time) float64 {
+	p.Lock()
+	defer p.Unlock()
+	p.score = p.decayed(halfLife, now) + 1
+	if now.After(p.last) {
+		p.last = now
+	}
+	return p.score
+}
+
+// Score returns the score at now.
+func (p *Popularity) Score(halfLife time.Duration, now time.Time) float64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.decayed(halfLife, now)
+}
+
+// Reset sets the score at t to score.
+func (p *Popularity) Reset(t time.Time, score float64) {
+	p.Lock()
+	defer p.Unlock()
+	p.last = t
+	p.score = score
+}
+
+func (p *Popularity) decayed(halfLife time.Duration, now time.Time) float64 {
+	elapsed := now.Sub(p.last)
+	if elapsed <= 0 || halfLife <= 0 {
+		return p.score
+	}
+	return p.score * math.Exp2(-float64(elapsed)/float64(halfLife))
+}
@@ -0,0 +1,30 @@
+package freq
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPopularity(t *testing.T) {
+	now := time.Now()
+	p := NewPopularity(now)
+
+	for i := 0; i < 4; i++ {
+		p.Hit(time.Minute, now)
+	}
+	if s := p.Score(time.Minute, now); s != 4 {
+		t.Errorf("Expected score 4, got %f", s)
+	}
+	if s := p.Score(time.Minute, now.Add(time.Minute)); s != 2 {
+		t.Errorf("Expected score 2 after one half-life, got %f", s)
+	}
+	if s := p.Hit(time.Minute, now.Add(2*time.Minute)); s != 2 {
+		t.Errorf("Expected score 2 after two half-lives and a hit, got %f", s)
+	}
+
+	p.Reset(now, 8)
+	if s := p.Score(time.Minute, now.Add(3*time.Minute)); math.Abs(s-1) > 1e-9 {
+		t.Errorf("Expected score 1 after resetting, got %f", s)
+	}
+}
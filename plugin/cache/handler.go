@@ -3,6 +3,7 @@ package cache
 import (
 	"context"
 	"math"
+	"strconv"
 	"time"
 
 	"github.com/coredns/coredns/plugin"
@@ -15,6 +16,10 @@ import (
 
 // ServeDNS implements the plugin.Handler interface.
 func (c *Cache) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	if c.purgeKeys != nil && c.purgeNotify(w, r) {
+		return dns.RcodeSuccess, nil
+	}
+
 	rc := r.Copy() // We potentially modify r, to prevent other plugins from seeing this (r is a pointer), copy r into rc.
 	state := request.Request{W: w, Req: rc}
 	do := state.Do()
@@ -38,27 +43,40 @@ func (c *Cache) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 	if i == nil {
 		crr := &ResponseWriter{ResponseWriter: w, Cache: c, state: state, server: server, do: do, ad: ad,
 			nexcept: c.nexcept, pexcept: c.pexcept, wildcardFunc: wildcardFunc(ctx)}
+		// Requests with an ECS option may get different replies, only coalesce the ones without.
+		if ecsOption(rc) == nil {
+			return c.coalesce(ctx, state, crr)
+		}
 		return c.doRefresh(ctx, state, crr)
 	}
 	ttl = i.ttl(now)
+	i.popularity.Hit(c.halfLife, now)
+	stale := ""
 	if ttl < 0 {
 		// serve stale behavior
+		stale = staleImmediate
 		if c.verifyStale {
-			crr := &ResponseWriter{ResponseWriter: w, Cache: c, state: state, server: server, do: do}
-			cw := newVerifyStaleResponseWriter(crr)
-			ret, err := c.doRefresh(ctx, state, cw)
-			if cw.refreshed {
+			crr := &ResponseWriter{ResponseWriter: w, Cache: c, state: state, server: server, do: do,
+				remoteAddr: w.RemoteAddr()}
+			refreshed, reason, ret, err := c.refreshStale(ctx, state, newVerifyStaleResponseWriter(crr))
+			if refreshed {
 				return ret, err
 			}
+			stale = reason
 		}
 
-		// Adjust the time to get a 0 TTL in the reply built from a stale item.
-		now = now.Add(time.Duration(ttl) * time.Second)
+		// Adjust the time to get the stale TTL in the reply built from a stale item.
+		now = now.Add(time.Duration(ttl-staleTTL) * time.Second)
 		if !c.verifyStale {
 			cw := newPrefetchResponseWriter(server, state, c)
 			go c.doPrefetch(ctx, state, cw, i, now)
 		}
 		servedStale.WithLabelValues(server, c.zonesMetricLabel, c.viewMetricLabel).Inc()
+		typ := Success
+		if i.Rcode == dns.RcodeNameError {
+			typ = Denial
+		}
+		servedStaleReasons.WithLabelValues(server, stale, typ, c.zonesMetricLabel, c.viewMetricLabel).Inc()
 	} else if c.shouldPrefetch(i, now) {
 		cw := newPrefetchResponseWriter(server, state, c)
 		go c.doPrefetch(ctx, state, cw, i, now)
@@ -72,6 +90,12 @@ func (c *Cache) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 	}
 
 	resp := i.toMsg(r, now, do, ad)
+	if ecs := ecsOption(r); ecs != nil {
+		setECS(resp, r, ecs, i.scope)
+	}
+	if stale != "" {
+		setStaleEDE(resp, r, i.Rcode)
+	}
 	w.WriteMsg(resp)
 	return dns.RcodeSuccess, nil
 }
@@ -95,6 +119,7 @@ func (c *Cache) doPrefetch(ctx context.Context, state request.Request, cw *Respo
 	// into the new item that was stored in the cache.
 	if i1 := c.exists(state); i1 != nil {
 		i1.Freq.Reset(now, i.Freq.Hits())
+		i1.popularity.Reset(now, i.popularity.Score(c.halfLife, now))
 	}
 }
 
@@ -108,6 +133,9 @@ func (c *Cache) shouldPrefetch(i *item, now time.Time) bool {
 	}
 	i.Freq.Update(c.duration, now)
 	threshold := int(math.Ceil(float64(c.percentage) / 100 * float64(i.origTTL)))
+	if c.decay {
+		return i.popularity.Score(c.halfLife, now) >= float64(c.prefetch) && i.ttl(now) <= threshold
+	}
 	return i.Freq.Hits() >= c.prefetch && i.ttl(now) <= threshold
 }
 
@@ -119,20 +147,24 @@ func (c *Cache) getIgnoreTTL(now time.Time, state request.Request, server string
 	k := hash(state.Name(), state.QType(), state.Do())
 	cacheRequests.WithLabelValues(server, c.zonesMetricLabel, c.viewMetricLabel).Inc()
 
-	if i, ok := c.ncache.Get(k); ok {
-		itm := i.(*item)
-		ttl := itm.ttl(now)
-		if itm.matches(state) && (ttl > 0 || (c.staleUpTo > 0 && -ttl < int(c.staleUpTo.Seconds()))) {
-			cacheHits.WithLabelValues(server, Denial, c.zonesMetricLabel, c.viewMetricLabel).Inc()
-			return i.(*item)
+	for _, k := range c.keys(state, k) {
+		if i, ok := c.ncache.Get(k); ok {
+			itm := i.(*item)
+			ttl := itm.ttl(now)
+			if itm.matches(state) && (ttl > 0 || (c.staleUpTo > 0 && -ttl < int(c.staleUpTo.Seconds()))) {
+				cacheHits.WithLabelValues(server, Denial, c.zonesMetricLabel, c.viewMetricLabel).Inc()
+				ecsHits.WithLabelValues(server, Denial, strconv.Itoa(int(itm.scope)), c.zonesMetricLabel, c.viewMetricLabel).Inc()
+				return i.(*item)
+			}
 		}
-	}
-	if i, ok := c.pcache.Get(k); ok {
-		itm := i.(*item)
-		ttl := itm.ttl(now)
-		if itm.matches(state) && (ttl > 0 || (c.staleUpTo > 0 && -ttl < int(c.staleUpTo.Seconds()))) {
-			cacheHits.WithLabelValues(server, Success, c.zonesMetricLabel, c.viewMetricLabel).Inc()
-			return i.(*item)
+		if i, ok := c.pcache.Get(k); ok {
+			itm := i.(*item)
+			ttl := itm.ttl(now)
+			if itm.matches(state) && (ttl > 0 || (c.staleUpTo > 0 && -ttl < int(c.staleUpTo.Seconds()))) {
+				cacheHits.WithLabelValues(server, Success, c.zonesMetricLabel, c.viewMetricLabel).Inc()
+				ecsHits.WithLabelValues(server, Success, strconv.Itoa(int(itm.scope)), c.zonesMetricLabel, c.viewMetricLabel).Inc()
+				return i.(*item)
+			}
 		}
 	}
 	cacheMisses.WithLabelValues(server, c.zonesMetricLabel, c.viewMetricLabel).Inc()
@@ -141,11 +173,13 @@ func (c *Cache) getIgnoreTTL(now time.Time, state request.Request, server string
 
 func (c *Cache) exists(state request.Request) *item {
 	k := hash(state.Name(), state.QType(), state.Do())
-	if i, ok := c.ncache.Get(k); ok {
-		return i.(*item)
-	}
-	if i, ok := c.pcache.Get(k); ok {
-		return i.(*item)
+	for _, k := range c.keys(state, k) {
+		if i, ok := c.ncache.Get(k); ok {
+			return i.(*item)
+		}
+		if i, ok := c.pcache.Get(k); ok {
+			return i.(*item)
+		}
 	}
 	return nil
 }
@@ -20,11 +20,14 @@ type item struct {
 	Ns                 []dns.RR
 	Extra              []dns.RR
 	wildcard           string
+	scope              uint8 // ECS scope prefix length, 0 if the reply applies to all clients
+	do                 bool  // the reply is for a request with the DO bit set
 
 	origTTL uint32
 	stored  time.Time
 
 	*freq.Freq
+	popularity *freq.Popularity
 }
 
 func newItem(m *dns.Msg, now time.Time, d time.Duration) *item {
@@ -38,6 +41,12 @@ func newItem(m *dns.Msg, now time.Time, d time.Duration) *item {
 	i.RecursionAvailable = m.RecursionAvailable
 	i.Answer = m.Answer
 	i.Ns = m.Ns
+	if ecs := ecsOption(m); ecs != nil {
+		i.scope = ecs.SourceScope
+		if i.scope > ecs.SourceNetmask {
+			i.scope = ecs.SourceNetmask
+		}
+	}
 	i.Extra = make([]dns.RR, len(m.Extra))
 	// Don't copy OPT records as these are hop-by-hop.
 	j := 0
@@ -54,6 +63,7 @@ func newItem(m *dns.Msg, now time.Time, d time.Duration) *item {
 	i.stored = now.UTC()
 
 	i.Freq = new(freq.Freq)
+	i.popularity = freq.NewPopularity(i.stored)
 
 	return i
 }
@@ -94,6 +104,19 @@ func (i *item) toMsg(m *dns.Msg, now time.Time, do bool, ad bool) *dns.Msg {
 	return m1
 }
 
+// itemSize returns an estimate of the memory used by the item in bytes: the wire size of its records, plus a fixed
+// overhead per record and per item.
+func itemSize(el interface{}) int {
+	i := el.(*item)
+	n := itemOverhead + len(i.Name) + len(i.wildcard)
+	for _, section := range [][]dns.RR{i.Answer, i.Ns, i.Extra} {
+		for _, rr := range section {
+			n += rrOverhead + dns.Len(rr)
+		}
+	}
+	return n
+}
+
 func (i *item) ttl(now time.Time) int {
 	ttl := int(i.origTTL) - int(now.UTC().Sub(i.stored).Seconds())
 	return ttl
@@ -105,3 +128,9 @@ func (i *item) matches(state request.Request) bool {
 	}
 	return false
 }
+
+// Approximate memory used by an item and a record besides their wire size.
+const (
+	itemOverhead = 256
+	rrOverhead   = 64
+)
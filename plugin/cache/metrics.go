@@ -15,6 +15,13 @@ var (
 		Name:      "entries",
 		Help:      "The number of elements in the cache.",
 	}, []string{"server", "type", "zones", "view"})
+	// cacheBytes is the estimated memory used by the elements in the cache by cache type.
+	cacheBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cache",
+		Name:      "bytes",
+		Help:      "The estimated memory used by the elements in the cache, if it is limited with max_memory.",
+	}, []string{"server", "type", "zones", "view"})
 	// cacheRequests is a counter of all requests through the cache.
 	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: plugin.Namespace,
@@ -29,6 +36,13 @@ var (
 		Name:      "hits_total",
 		Help:      "The count of cache hits.",
 	}, []string{"server", "type", "zones", "view"})
+	// ecsHits is counter of cache hits by cache type and ECS scope prefix length.
+	ecsHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cache",
+		Name:      "ecs_hits_total",
+		Help:      "The count of cache hits by ECS scope prefix length.",
+	}, []string{"server", "type", "scope", "zones", "view"})
 	// cacheMisses is the counter of cache misses. - Deprecated
 	cacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: plugin.Namespace,
@@ -43,6 +57,34 @@ var (
 		Name:      "prefetch_total",
 		Help:      "The number of times the cache has prefetched a cached item.",
 	}, []string{"server", "zones", "view"})
+	// servedStaleReasons is the number of requests served from stale cache entries by reason and cache type.
+	servedStaleReasons = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cache",
+		Name:      "served_stale_reasons_total",
+		Help:      "The number of requests served from stale cache entries by reason.",
+	}, []string{"server", "reason", "type", "zones", "view"})
+	// cacheCoalesced is the number of requests that waited for an identical request instead of missing the cache.
+	cacheCoalesced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cache",
+		Name:      "coalesced_total",
+		Help:      "The number of requests answered with the reply to an identical request that was in flight.",
+	}, []string{"server", "source", "zones", "view"})
+	// cachePopularRefreshes is the number of popular entries refreshed in the background before they expire.
+	cachePopularRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cache",
+		Name:      "popular_refreshes_total",
+		Help:      "The number of popular entries refreshed in the background before they expire.",
+	}, []string{"zones", "view"})
+	// cacheWarmups is the number of questions resolved to warm up the cache.
+	cacheWarmups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cache",
+		Name:      "warmup_total",
+		Help:      "The number of questions resolved to warm up the cache at startup.",
+	}, []string{"source", "zones", "view"})
 	// cacheDrops is the number responses that are not cached, because the reply is malformed.
 	cacheDrops = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: plugin.Namespace,
@@ -57,6 +99,13 @@ var (
 		Name:      "served_stale_total",
 		Help:      "The number of requests served from stale cache entries.",
 	}, []string{"server", "zones", "view"})
+	// cachePurges is the number of entries purged through the API or a purge message.
+	cachePurges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cache",
+		Name:      "purged_total",
+		Help:      "The number of entries purged through the API or a purge message.",
+	}, []string{"source", "zones", "view"})
 	// evictions is the counter of cache evictions.
 	evictions = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: plugin.Namespace,
@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/horahoradev/dns"
+)
+
+// snapshotEntry is a cached reply as it is stored in a snapshot file. The reply itself is stored as a packed
+// message, the expiry time is absolute so entries can be restored with the TTL they have left.
+type snapshotEntry struct {
+	Key      uint64
+	Denial   bool
+	Msg      []byte
+	Wildcard string
+	Stored   time.Time
+	Expires  time.Time
+	DO       bool
+	Score    float64 // popularity when the snapshot was written
+
+	// Replies that only apply to a client subnet, see ecs.go. Base is the key of the question.
+	Scope  uint8
+	Base   uint64
+	Family uint16
+	Addr   string
+}
+
+// snapshotHeader is written before the entries, entries follow until the end of the file.
+type snapshotHeader struct {
+	Version int
+	Written time.Time
+	Popular []question // the most popular questions, most popular first
+}
+
+// persist holds the settings for saving the cache to, and loading it from, a snapshot file.
+type persist struct {
+	file     string
+	interval time.Duration // 0 means the snapshot is only written on shutdown
+
+	popular []question // the most popular questions in the loaded snapshot
+}
+
+// save writes all entries of the cache that haven't expired, beyond the time they may be served stale, to the
+// snapshot file. The file is replaced atomically. It returns the number of entries written.
+func (c *Cache) save(file string) (int, error) {
+	now := c.now().UTC()
+
+	// Scoped entries need the question and subnet they belong to, so they can be restored as a variant.
+	subnets := map[uint64]snapshotEntry{}
+	c.variants.Walk(func(items map[uint64]interface{}, key uint64) bool {
+		v := items[key].(*variants)
+		v.Lock()
+		for _, s := range v.subnets {
+			subnets[s.key] = snapshotEntry{Base: key, Scope: s.scope, Family: s.family, Addr: s.addr}
+		}
+		v.Unlock()
+		return true
+	})
+
+	var entries []snapshotEntry
+	walk := func(denial bool) func(map[uint64]interface{}, uint64) bool {
+		return func(items map[uint64]interface{}, key uint64) bool {
+			i, ok := items[key].(*item)
+			if !ok || c.expired(i, now) {
+				return true
+			}
+			buf, err := i.pack()
+			if err != nil {
+				return true
+			}
+			e := snapshotEntry{Key: key, Denial: denial, Msg: buf, Wildcard: i.wildcard, Stored: i.stored,
+				Expires: i.stored.Add(time.Duration(i.origTTL) * time.Second), DO: i.do,
+				Score: i.popularity.Score(c.halfLife, now)}
+			if i.scope > 0 {
+				s, ok := subnets[key]
+				if !ok {
+					return true
+				}
+				e.Scope, e.Base, e.Family, e.Addr = s.Scope, s.Base, s.Family, s.Addr
+			}
+			entries = append(entries, e)
+			return true
+		}
+	}
+	c.pcache.Walk(walk(false))
+	c.ncache.Walk(walk(true))
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := gob.NewEncoder(w)
+	h := snapshotHeader{Version: snapshotVersion, Written: now}
+	if c.warmupTop > 0 {
+		h.Popular = c.popular(c.warmupTop, now)
+	}
+	if err := enc.Encode(h); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			tmp.Close()
+			return 0, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return len(entries), os.Rename(tmp.Name(), file)
+}
+
+// load adds the entries from the snapshot file to the cache, skipping the ones that have expired. A missing
+// file isn't an error. It returns the number of entries added.
+func (c *Cache) load(file string) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	dec := gob.NewDecoder(bufio.NewReader(f))
+	var h snapshotHeader
+	if err := dec.Decode(&h); err != nil {
+		return 0, err
+	}
+	if h.Version != snapshotVersion {
+		return 0, errors.New("unsupported snapshot version")
+	}
+	if c.persist != nil {
+		c.persist.popular = h.Popular
+	}
+
+	now := c.now().UTC()
+	n := 0
+	for {
+		var e snapshotEntry
+		if err := dec.Decode(&e); err != nil {
+			if err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+
+		m := new(dns.Msg)
+		if err := m.Unpack(e.Msg); err != nil {
+			continue
+		}
+		i := newItem(m, e.Stored, e.Expires.Sub(e.Stored))
+		i.wildcard = e.Wildcard
+		if c.expired(i, now) {
+			continue
+		}
+		i.scope = e.Scope
+		i.do = e.DO
+		i.popularity.Reset(h.Written, e.Score)
+
+		key := e.Key
+		if e.Scope > 0 {
+			if c.ecsVariants == 0 {
+				continue
+			}
+			key = c.addVariant(e.Base, subnet{family: e.Family, scope: e.Scope, addr: e.Addr})
+		}
+		if e.Denial {
+			c.ncache.Add(key, i)
+		} else {
+			c.pcache.Add(key, i)
+		}
+		n++
+	}
+}
+
+// expired returns true if i can't be served anymore, not even as a stale entry.
+func (c *Cache) expired(i *item, now time.Time) bool {
+	ttl := i.ttl(now)
+	return ttl <= 0 && -ttl >= int(c.staleUpTo.Seconds())
+}
+
+// pack returns i as a packed message.
+func (i *item) pack() ([]byte, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(i.Name, i.QType)
+	m.Response = true
+	m.Rcode = i.Rcode
+	m.AuthenticatedData = i.AuthenticatedData
+	m.RecursionAvailable = i.RecursionAvailable
+	m.Answer = i.Answer
+	m.Ns = i.Ns
+	m.Extra = i.Extra
+	return m.Pack()
+}
+
+// Ready implements the ready.Readiness interface. The cache is ready once the snapshot is loaded.
+func (c *Cache) Ready() bool { return atomic.LoadUint32(&c.loading) == 0 }
+
+const (
+	snapshotVersion = 1
+
+	defaultPersistInterval = 5 * time.Minute
+)
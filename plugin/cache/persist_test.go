@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/horahoradev/dns"
+)
+
+// persistBackend answers NXDOMAIN for names in nxdomain.example.org. and otherwise hands the query to ecsBackend.
+func persistBackend(queries *int) plugin.Handler {
+	next := ecsBackend(queries)
+	return plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		if r.Question[0].Name != "nxdomain.example.org." {
+			return next.ServeDNS(ctx, w, r)
+		}
+		*queries++
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeNameError)
+		m.Ns = []dns.RR{test.SOA("example.org. 60 IN SOA ns.example.org. admin.example.org. 1 3600 600 86400 60")}
+		w.WriteMsg(m)
+		return dns.RcodeNameError, nil
+	})
+}
+
+func TestPersistSaveLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache.snapshot")
+	now := time.Now().UTC()
+
+	queries := 0
+	c := New()
+	c.Next = persistBackend(&queries)
+	c.now = func() time.Time { return now }
+
+	nxdomain := new(dns.Msg)
+	nxdomain.SetQuestion("nxdomain.example.org.", dns.TypeA)
+	plain := new(dns.Msg)
+	plain.SetQuestion("example.org.", dns.TypeA)
+	reqs := []*dns.Msg{ecsMsg("192.0.2.1", 32, 0), plain, nxdomain}
+	for _, req := range reqs {
+		c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+	}
+	if queries != len(reqs) {
+		t.Fatalf("Expected %d queries to the backend, got %d", len(reqs), queries)
+	}
+
+	n, err := c.save(file)
+	if err != nil {
+		t.Fatalf("Expected no error saving the snapshot, got %s", err)
+	}
+	if n != len(reqs) {
+		t.Errorf("Expected %d entries saved, got %d", len(reqs), n)
+	}
+
+	// Load 100 seconds later: the negative entry, with a TTL of 60, has expired.
+	queries = 0
+	c1 := New()
+	c1.Next = persistBackend(&queries)
+	c1.now = func() time.Time { return now.Add(100 * time.Second) }
+	n, err = c1.load(file)
+	if err != nil {
+		t.Fatalf("Expected no error loading the snapshot, got %s", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 entries loaded, got %d", n)
+	}
+
+	tests := []struct {
+		req      *dns.Msg
+		queries  int
+		expected string // address in the answer, empty for NXDOMAIN
+		ttl      uint32
+	}{
+		{plain, 0, "127.0.0.1", 200},
+		{ecsMsg("192.0.2.200", 32, 0), 0, "192.0.2.1", 200},
+		{ecsMsg("198.51.100.1", 32, 0), 0, "127.0.0.1", 200},
+		{nxdomain, 1, "", 0},
+	}
+	for i, tc := range tests {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		c1.ServeDNS(context.TODO(), rec, tc.req)
+		if queries != tc.queries {
+			t.Errorf("Test %d: expected %d queries to the backend, got %d", i, tc.queries, queries)
+		}
+		if tc.expected == "" {
+			if rec.Msg.Rcode != dns.RcodeNameError {
+				t.Errorf("Test %d: expected NXDOMAIN, got %s", i, dns.RcodeToString[rec.Msg.Rcode])
+			}
+			continue
+		}
+		a := rec.Msg.Answer[0].(*dns.A)
+		if a.A.String() != tc.expected || a.Hdr.Ttl != tc.ttl {
+			t.Errorf("Test %d: expected answer %s with TTL %d, got %s", i, tc.expected, tc.ttl, a)
+		}
+	}
+}
+
+func TestPersistLoadMissing(t *testing.T) {
+	c := New()
+	n, err := c.load(filepath.Join(t.TempDir(), "missing"))
+	if n != 0 || err != nil {
+		t.Errorf("Expected a missing snapshot to load nothing without error, got %d and %v", n, err)
+	}
+}
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+// entry is a cached reply as it is listed by the API.
+type entry struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Class  string   `json:"class"` // success or denial
+	Rcode  string   `json:"rcode"`
+	TTL    int      `json:"ttl"` // seconds left, negative for stale entries
+	Scope  uint8    `json:"scope,omitempty"`
+	Answer []string `json:"answer,omitempty"`
+	Zones  string   `json:"zones"`
+	View   string   `json:"view,omitempty"`
+}
+
+// matcher returns a function that returns true for names that are equal to name, or, if suffix is true, equal
+// to or below name. An empty name is the root zone.
+func matcher(name string, suffix bool) func(string) bool {
+	name = plugin.Name(name).Normalize()
+	return func(s string) bool {
+		s = strings.ToLower(s)
+		if suffix {
+			return plugin.Name(name).Matches(s)
+		}
+		return s == name
+	}
+}
+
+// entries returns the entries in the cache for which match returns true.
+func (c *Cache) entries(match func(string) bool) []entry {
+	now := c.now().UTC()
+	var entries []entry
+	walk := func(class string) func(map[uint64]interface{}, uint64) bool {
+		return func(items map[uint64]interface{}, key uint64) bool {
+			i, ok := items[key].(*item)
+			if !ok || !match(i.Name) {
+				return true
+			}
+			e := entry{
+				Name:  i.Name,
+				Type:  dns.Type(i.QType).String(),
+				Class: class,
+				Rcode: dns.RcodeToString[i.Rcode],
+				TTL:   i.ttl(now),
+				Scope: i.scope,
+				Zones: c.zonesMetricLabel,
+				View:  c.viewMetricLabel,
+			}
+			for _, rr := range i.Answer {
+				e.Answer = append(e.Answer, rr.String())
+			}
+			entries = append(entries, e)
+			return true
+		}
+	}
+	c.pcache.Walk(walk(Success))
+	c.ncache.Walk(walk(Denial))
+	return entries
+}
+
+// purge removes the entries for which match returns true from the cache and returns the number removed.
+func (c *Cache) purge(match func(string) bool) int {
+	n := 0
+	walk := func(items map[uint64]interface{}, key uint64) bool {
+		if i, ok := items[key].(*item); ok && match(i.Name) {
+			delete(items, key)
+			n++
+		}
+		return true
+	}
+	c.pcache.Walk(walk)
+	c.ncache.Walk(walk)
+	return n
+}
+
+// purgeNotify handles a NOTIFY signed with one of the purge keys: a NOTIFY for type SOA purges the name and all
+// names below it, for any other type only the name itself. It returns false if r isn't such a message.
+func (c *Cache) purgeNotify(w dns.ResponseWriter, r *dns.Msg) bool {
+	if r.Opcode != dns.OpcodeNotify || len(r.Question) != 1 {
+		return false
+	}
+	t := r.IsTsig()
+	if t == nil {
+		return false
+	}
+	if _, ok := c.purgeKeys[strings.ToLower(t.Hdr.Name)]; !ok {
+		return false
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	if err := w.TsigStatus(); err != nil {
+		log.Warningf("Rejecting purge with invalid TSIG from %s: %s", w.RemoteAddr(), err)
+		m.Rcode = dns.RcodeNotAuth
+		w.WriteMsg(m)
+		return true
+	}
+
+	state := request.Request{W: w, Req: r}
+	n := c.purge(matcher(state.Name(), state.QType() == dns.TypeSOA))
+	cachePurges.WithLabelValues("dns", c.zonesMetricLabel, c.viewMetricLabel).Add(float64(n))
+	log.Infof("Purged %d entries for %s %s, requested by %s", n, state.Name(), state.Type(), state.IP())
+
+	m.Authoritative = true
+	m.SetTsig(t.Hdr.Name, t.Algorithm, 300, time.Now().Unix())
+	w.WriteMsg(m)
+	return true
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/horahoradev/dns"
+)
+
+// fillCache queries c for each name, the backend answers with an A record.
+func fillCache(t *testing.T, c *Cache, names ...string) {
+	c.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{test.A(r.Question[0].Name + " 300 IN A 127.0.0.1")}
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+	for _, name := range names {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), m)
+	}
+	if c.pcache.Len() != len(names) {
+		t.Fatalf("Expected %d entries in the cache, got %d", len(names), c.pcache.Len())
+	}
+}
+
+func TestPurge(t *testing.T) {
+	names := []string{"example.org.", "www.example.org.", "a.b.example.org.", "example.net.", "notexample.org."}
+	tests := []struct {
+		name     string
+		suffix   bool
+		expected int
+	}{
+		{"example.org.", false, 1},
+		{"WWW.Example.ORG", false, 1},
+		{"b.example.org.", false, 0},
+		{"example.org.", true, 3},
+		{"b.example.org.", true, 1},
+		{"org.", true, 4},
+		{"", true, 5},
+	}
+
+	for i, tc := range tests {
+		c := New()
+		fillCache(t, c, names...)
+		match := matcher(tc.name, tc.suffix)
+		if n := len(c.entries(match)); n != tc.expected {
+			t.Errorf("Test %d: expected %d entries listed, got %d", i, tc.expected, n)
+		}
+		if n := c.purge(match); n != tc.expected {
+			t.Errorf("Test %d: expected %d entries purged, got %d", i, tc.expected, n)
+		}
+		if n := c.pcache.Len(); n != len(names)-tc.expected {
+			t.Errorf("Test %d: expected %d entries left, got %d", i, len(names)-tc.expected, n)
+		}
+	}
+}
+
+func TestPurgeNotify(t *testing.T) {
+	tests := []struct {
+		name     string
+		qtype    uint16
+		key      string // TSIG key, empty for an unsigned message
+		handled  bool
+		expected int // entries left
+	}{
+		{"example.org.", dns.TypeSOA, "purge.", true, 1},
+		{"example.org.", dns.TypeA, "purge.", true, 2},
+		{"example.org.", dns.TypeSOA, "", false, 3},
+		{"example.org.", dns.TypeSOA, "other.", false, 3},
+	}
+
+	for i, tc := range tests {
+		c := New()
+		c.purgeKeys = map[string]struct{}{"purge.": {}}
+		fillCache(t, c, "example.org.", "www.example.org.", "example.net.")
+
+		m := new(dns.Msg)
+		m.SetNotify(tc.name)
+		m.Question[0].Qtype = tc.qtype
+		if tc.key != "" {
+			m.SetTsig(tc.key, dns.HmacSHA256, 300, 0)
+		}
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if handled := c.purgeNotify(rec, m); handled != tc.handled {
+			t.Errorf("Test %d: expected handled to be %t", i, tc.handled)
+		}
+		if n := c.pcache.Len(); n != tc.expected {
+			t.Errorf("Test %d: expected %d entries left, got %d", i, tc.expected, n)
+		}
+		if tc.handled && (rec.Msg.Rcode != dns.RcodeSuccess || rec.Msg.IsTsig() == nil) {
+			t.Errorf("Test %d: expected a signed NOERROR reply, got %v", i, rec.Msg)
+		}
+	}
+}
@@ -3,6 +3,9 @@ package cache
 import (
 	"errors"
 	"fmt"
+	"net"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -29,6 +32,52 @@ func setup(c *caddy.Controller) error {
 		return nil
 	})
 
+	if ca.persist != nil || len(ca.warmup) > 0 || ca.decay {
+		var stop chan struct{}
+		c.OnStartup(func() error {
+			stop = make(chan struct{})
+			go ca.run(stop)
+			return nil
+		})
+		c.OnShutdown(func() error {
+			if stop != nil {
+				close(stop)
+				stop = nil
+			}
+			return nil
+		})
+		if ca.persist != nil {
+			save := func() error {
+				n, err := ca.save(ca.persist.file)
+				if err != nil {
+					return err
+				}
+				log.Infof("Saved %d entries to cache snapshot %s", n, ca.persist.file)
+				return nil
+			}
+			// Save before the new instance starts and loads the snapshot, a failure shouldn't stop the reload.
+			c.OnRestart(func() error {
+				if err := save(); err != nil {
+					log.Warningf("Failed to save cache snapshot %s: %s", ca.persist.file, err)
+				}
+				return nil
+			})
+			c.OnFinalShutdown(func() error {
+				if err := save(); err != nil {
+					return plugin.Error("cache", err)
+				}
+				return nil
+			})
+		}
+	}
+
+	if ca.api != "" {
+		c.OnStartup(func() error { return startAPI(ca.api, ca) })
+		c.OnRestartFailed(func() error { return startAPI(ca.api, ca) })
+		c.OnRestart(func() error { return stopAPI(ca.api, ca) })
+		c.OnFinalShutdown(func() error { return stopAPI(ca.api, ca) })
+	}
+
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		ca.Next = next
 		return ca
@@ -172,7 +221,7 @@ func cacheParse(c *caddy.Controller) (*Cache, error) {
 
 			case "serve_stale":
 				args := c.RemainingArgs()
-				if len(args) > 2 {
+				if len(args) > 3 {
 					return nil, c.ArgErr()
 				}
 				ca.staleUpTo = 1 * time.Hour
@@ -194,6 +243,20 @@ func cacheParse(c *caddy.Controller) (*Cache, error) {
 					}
 					ca.verifyStale = mode == "verify"
 				}
+				ca.staleTimeout = 0
+				if len(args) > 2 {
+					if !ca.verifyStale {
+						return nil, errors.New("serve_stale timeout requires the verify refresh mode")
+					}
+					d, err := time.ParseDuration(args[2])
+					if err != nil {
+						return nil, err
+					}
+					if d <= 0 {
+						return nil, errors.New("invalid non-positive timeout for serve_stale")
+					}
+					ca.staleTimeout = d
+				}
 			case "servfail":
 				args := c.RemainingArgs()
 				if len(args) != 1 {
@@ -240,16 +303,194 @@ func cacheParse(c *caddy.Controller) (*Cache, error) {
 				default:
 					return nil, fmt.Errorf("cache type for disable must be %q or %q", Success, Denial)
 				}
+			case "ecs_variants":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				n, err := strconv.Atoi(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if n < 0 {
+					return nil, fmt.Errorf("ecs_variants can not be negative: %d", n)
+				}
+				ca.ecsVariants = n
+			case "persist":
+				args := c.RemainingArgs()
+				if len(args) == 0 || len(args) > 2 {
+					return nil, c.ArgErr()
+				}
+				p := &persist{file: args[0], interval: defaultPersistInterval}
+				if !filepath.IsAbs(p.file) && dnsserver.GetConfig(c).Root != "" {
+					p.file = filepath.Join(dnsserver.GetConfig(c).Root, p.file)
+				}
+				if len(args) > 1 {
+					d, err := time.ParseDuration(args[1])
+					if err != nil {
+						return nil, err
+					}
+					if d < 0 {
+						return nil, errors.New("invalid negative interval for persist")
+					}
+					p.interval = d
+				}
+				ca.persist = p
+			case "max_memory":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				b, err := parseBytes(args[0])
+				if err != nil {
+					return nil, err
+				}
+				ca.maxBytes = b
+			case "warmup":
+				// warmup FILE
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				file := args[0]
+				if !filepath.IsAbs(file) && dnsserver.GetConfig(c).Root != "" {
+					file = filepath.Join(dnsserver.GetConfig(c).Root, file)
+				}
+				f, err := os.Open(file)
+				if err != nil {
+					return nil, err
+				}
+				qs, err := parseWarmup(f)
+				f.Close()
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s", file, err)
+				}
+				ca.warmup = append(ca.warmup, qs...)
+			case "warmup_top":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				n, err := strconv.Atoi(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if n < 0 {
+					return nil, fmt.Errorf("warmup_top can not be negative: %d", n)
+				}
+				ca.warmupTop = n
+			case "popularity":
+				// popularity HALFLIFE
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				d, err := time.ParseDuration(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if d <= 0 {
+					return nil, errors.New("invalid non-positive half-life for popularity")
+				}
+				ca.halfLife = d
+				ca.decay = true
+			case "api":
+				args := c.RemainingArgs()
+				if len(args) > 2 {
+					return nil, c.ArgErr()
+				}
+				ca.api = defaultAPIAddr
+				if len(args) > 0 {
+					host, port, err := net.SplitHostPort(args[0])
+					if err != nil {
+						return nil, err
+					}
+					// Without a host, only listen on the loopback interface.
+					if host == "" {
+						host = "localhost"
+					}
+					ca.api = net.JoinHostPort(host, port)
+				}
+				if len(args) > 1 {
+					ca.apiToken = args[1]
+				}
+			case "purge_key":
+				// purge_key NAME SECRET, the secret is added to the TSIG secrets of the server.
+				args := c.RemainingArgs()
+				if len(args) != 2 {
+					return nil, c.ArgErr()
+				}
+				config := dnsserver.GetConfig(c)
+				k := plugin.Name(args[0]).Normalize()
+				if s, exists := config.TsigSecret[k]; exists && s != args[1] {
+					return nil, fmt.Errorf("key %q redefined", k)
+				}
+				if config.TsigSecret == nil {
+					config.TsigSecret = make(map[string]string)
+				}
+				config.TsigSecret[k] = args[1]
+				if ca.purgeKeys == nil {
+					ca.purgeKeys = make(map[string]struct{})
+				}
+				ca.purgeKeys[k] = struct{}{}
 			default:
 				return nil, c.ArgErr()
 			}
 		}
 
+		if ca.warmupTop > 0 && ca.persist == nil {
+			return nil, errors.New("warmup_top requires persist")
+		}
+		if ca.decay && ca.prefetch <= 0 {
+			return nil, errors.New("popularity requires prefetch")
+		}
+		if ca.persist != nil || len(ca.warmup) > 0 {
+			ca.loading = 1
+		}
+
 		ca.Zones = origins
 		ca.zonesMetricLabel = strings.Join(origins, ",")
-		ca.pcache = cache.New(ca.pcap)
-		ca.ncache = cache.New(ca.ncap)
+		if ca.maxBytes > 0 {
+			// Split the budget in proportion to the capacities.
+			pbytes := ca.maxBytes / 2
+			if total := ca.pcap + ca.ncap; total > 0 {
+				pbytes = ca.maxBytes * int64(ca.pcap) / int64(total)
+			}
+			ca.pcache = cache.NewBounded(ca.pcap, pbytes, itemSize)
+			ca.ncache = cache.NewBounded(ca.ncap, ca.maxBytes-pbytes, itemSize)
+		} else {
+			ca.pcache = cache.New(ca.pcap)
+			ca.ncache = cache.New(ca.ncap)
+		}
+		ca.variants = cache.New(ca.pcap + ca.ncap)
 	}
 
 	return ca, nil
 }
+
+// minMaxBytes is the smallest memory budget, it leaves room for a few large replies in each shard.
+const minMaxBytes = 1 << 20
+
+// parseBytes parses a size in bytes, with an optional K, M or G suffix for powers of 1024.
+func parseBytes(s string) (int64, error) {
+	mult := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n*mult < minMaxBytes {
+		return 0, fmt.Errorf("max_memory should be at least %d bytes: %d", minMaxBytes, n*mult)
+	}
+	return n * mult, nil
+}
@@ -2,6 +2,8 @@ package cache
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -231,3 +233,228 @@ func TestDisable(t *testing.T) {
 		}
 	}
 }
+
+func TestECSVariants(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		variants  int
+	}{
+		{"ecs_variants 4", false, 4},
+		{"ecs_variants 0", false, 0},
+		// fails
+		{"ecs_variants", true, defaultECSVariants},
+		{"ecs_variants -1", true, defaultECSVariants},
+		{"ecs_variants many", true, defaultECSVariants},
+		{"ecs_variants 1 2", true, defaultECSVariants},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr && err != nil {
+			continue
+		}
+		if ca.ecsVariants != test.variants {
+			t.Errorf("Test %v: Expected ecs_variants %v but found: %v", i, test.variants, ca.ecsVariants)
+		}
+	}
+}
+
+func TestPersist(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		file      string
+		interval  time.Duration
+	}{
+		{"persist /tmp/cache.snapshot", false, "/tmp/cache.snapshot", defaultPersistInterval},
+		{"persist /tmp/cache.snapshot 30s", false, "/tmp/cache.snapshot", 30 * time.Second},
+		{"persist /tmp/cache.snapshot 0s", false, "/tmp/cache.snapshot", 0},
+		// fails
+		{"persist", true, "", 0},
+		{"persist /tmp/cache.snapshot -1s", true, "", 0},
+		{"persist /tmp/cache.snapshot often", true, "", 0},
+		{"persist /tmp/cache.snapshot 1m 2m", true, "", 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr && err != nil {
+			continue
+		}
+		if ca.persist.file != test.file || ca.persist.interval != test.interval {
+			t.Errorf("Test %v: Expected persist %s %v but found: %s %v", i, test.file, test.interval, ca.persist.file, ca.persist.interval)
+		}
+		if ca.Ready() {
+			t.Errorf("Test %v: Expected the cache not to be ready before the snapshot is loaded", i)
+		}
+	}
+}
+
+func TestAPISetup(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		api       string
+		token     string
+		keys      int
+	}{
+		{"api localhost:8053", false, "localhost:8053", "", 0},
+		{"api", false, "localhost:8054", "", 0},
+		{"api :8053", false, "localhost:8053", "", 0},
+		{"api 0.0.0.0:8053 s3cret", false, "0.0.0.0:8053", "s3cret", 0},
+		{"purge_key purge. c2VjcmV0", false, "", "", 1},
+		{"purge_key purge. c2VjcmV0\npurge_key other. b3RoZXI=", false, "", "", 2},
+		// fails
+		{"api localhost", true, "", "", 0},
+		{"api localhost:8053 s3cret more", true, "", "", 0},
+		{"purge_key purge.", true, "", "", 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr && err != nil {
+			continue
+		}
+		if ca.api != test.api || ca.apiToken != test.token || len(ca.purgeKeys) != test.keys {
+			t.Errorf("Test %v: Expected api %q, token %q and %d keys but found: %q, %q and %d", i, test.api, test.token, test.keys, ca.api, ca.apiToken, len(ca.purgeKeys))
+		}
+	}
+}
+
+func TestMaxMemory(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		bytes     int64
+	}{
+		{"max_memory 1048576", false, 1 << 20},
+		{"max_memory 64M", false, 64 << 20},
+		{"max_memory 2048k", false, 2 << 20},
+		{"max_memory 1G", false, 1 << 30},
+		// fails
+		{"max_memory", true, 0},
+		{"max_memory 512K", true, 0},
+		{"max_memory -1M", true, 0},
+		{"max_memory lots", true, 0},
+		{"max_memory 1M 2M", true, 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr && err != nil {
+			continue
+		}
+		if ca.maxBytes != test.bytes {
+			t.Errorf("Test %v: Expected max_memory %d but found: %d", i, test.bytes, ca.maxBytes)
+		}
+	}
+}
+
+func TestServeStaleClientTimeout(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		timeout   time.Duration
+	}{
+		{"serve_stale 1h verify", false, 0},
+		{"serve_stale 1h verify 1800ms", false, 1800 * time.Millisecond},
+		// fails
+		{"serve_stale 1h immediate 1s", true, 0},
+		{"serve_stale 1h verify 0s", true, 0},
+		{"serve_stale 1h verify soon", true, 0},
+		{"serve_stale 1h verify 1s 2s", true, 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr && err != nil {
+			continue
+		}
+		if ca.staleTimeout != test.timeout {
+			t.Errorf("Test %v: Expected timeout %v but found: %v", i, test.timeout, ca.staleTimeout)
+		}
+	}
+}
+
+func TestWarmupSetup(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "warmup.txt")
+	if err := os.WriteFile(file, []byte("example.org.\nexample.net. AAAA\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input     string
+		shouldErr bool
+		warmup    int
+		warmupTop int
+		halfLife  time.Duration
+	}{
+		{"warmup " + file, false, 2, 0, defaultHalfLife},
+		{"persist /tmp/cache.snapshot\nwarmup_top 100", false, 0, 100, defaultHalfLife},
+		{"prefetch 10\npopularity 5m", false, 0, 0, 5 * time.Minute},
+		// fails
+		{"warmup", true, 0, 0, 0},
+		{"warmup /does/not/exist", true, 0, 0, 0},
+		{"warmup_top 100", true, 0, 0, 0},
+		{"persist /tmp/cache.snapshot\nwarmup_top -1", true, 0, 0, 0},
+		{"popularity 5m", true, 0, 0, 0},
+		{"prefetch 10\npopularity 0s", true, 0, 0, 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr {
+			continue
+		}
+		if len(ca.warmup) != test.warmup || ca.warmupTop != test.warmupTop || ca.halfLife != test.halfLife {
+			t.Errorf("Test %v: Expected warmup %d %d %v but found: %d %d %v", i, test.warmup, test.warmupTop, test.halfLife,
+				len(ca.warmup), ca.warmupTop, ca.halfLife)
+		}
+		if test.warmup > 0 && ca.Ready() {
+			t.Errorf("Test %v: Expected the cache not to be ready before it is warmed up", i)
+		}
+	}
+}
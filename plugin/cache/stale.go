@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+// Reasons for serving a stale entry.
+const (
+	staleImmediate = "immediate" // the entry is refreshed after replying
+	staleFailed    = "failed"    // the refresh failed
+	staleTimeout   = "timeout"   // the refresh took longer than the client response timeout
+)
+
+// staleTTL is the TTL of stale records in replies, as recommended in RFC 8767, section 4.
+const staleTTL = 30
+
+// refreshStale refreshes a stale entry before replying. It returns true if the client got the refreshed reply,
+// otherwise it returns the reason the stale entry must be served. If staleTimeout is set and the refresh takes
+// longer, the refresh continues in the background and only updates the cache.
+func (c *Cache) refreshStale(ctx context.Context, state request.Request, cw *verifyStaleResponseWriter) (bool, string, int, error) {
+	if c.staleTimeout == 0 {
+		ret, err := c.doRefresh(ctx, state, cw)
+		return cw.refreshed, staleFailed, ret, err
+	}
+
+	type result struct {
+		rcode int
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ret, err := c.doRefresh(ctx, state, cw)
+		done <- result{ret, err}
+	}()
+
+	timer := time.NewTimer(c.staleTimeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		return cw.refreshed, staleFailed, res.rcode, res.err
+	case <-timer.C:
+		if cw.detach() {
+			return false, staleTimeout, 0, nil
+		}
+		// The refreshed reply was written just now.
+		res := <-done
+		return true, "", res.rcode, res.err
+	}
+}
+
+// setStaleEDE adds the Extended DNS Error for a stale answer to m, the reply to r, if r uses EDNS. See RFC 8914,
+// section 4.4 and 4.20.
+func setStaleEDE(m, r *dns.Msg, rcode int) {
+	ro := r.IsEdns0()
+	if ro == nil {
+		return
+	}
+	o := m.IsEdns0()
+	if o == nil {
+		m.SetEdns0(ro.UDPSize(), ro.Do())
+		o = m.IsEdns0()
+	}
+	code := dns.ExtendedErrorCodeStaleAnswer
+	if rcode == dns.RcodeNameError {
+		code = dns.ExtendedErrorCodeStaleNXDOMAINAnswer
+	}
+	o.Option = append(o.Option, &dns.EDNS0_EDE{InfoCode: code})
+}
@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/horahoradev/dns"
+)
+
+// staleEDE returns the info code of the EDE option in m, or -1 if there is none.
+func staleEDE(m *dns.Msg) int {
+	if o := m.IsEdns0(); o != nil {
+		for _, s := range o.Option {
+			if e, ok := s.(*dns.EDNS0_EDE); ok {
+				return int(e.InfoCode)
+			}
+		}
+	}
+	return -1
+}
+
+func TestServeStaleEDE(t *testing.T) {
+	tests := []struct {
+		rcode    int
+		edns     bool
+		expected int
+	}{
+		{dns.RcodeSuccess, true, int(dns.ExtendedErrorCodeStaleAnswer)},
+		{dns.RcodeNameError, true, int(dns.ExtendedErrorCodeStaleNXDOMAINAnswer)},
+		{dns.RcodeSuccess, false, -1},
+	}
+
+	for i, tc := range tests {
+		rcode := tc.rcode // the stale entry is refreshed in the background
+		c := New()
+		c.staleUpTo = time.Hour
+		c.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+			m := new(dns.Msg)
+			m.SetRcode(r, rcode)
+			if rcode == dns.RcodeSuccess {
+				m.Answer = []dns.RR{test.A("example.org. 60 IN A 127.0.0.1")}
+			} else {
+				m.Ns = []dns.RR{test.SOA("example.org. 60 IN SOA ns.example.org. admin.example.org. 1 3600 600 86400 60")}
+			}
+			w.WriteMsg(m)
+			return rcode, nil
+		})
+
+		req := new(dns.Msg)
+		req.SetQuestion("example.org.", dns.TypeA)
+		if tc.edns {
+			req.SetEdns0(4096, false)
+		}
+		c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+
+		c.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		c.ServeDNS(context.TODO(), rec, req)
+		if ede := staleEDE(rec.Msg); ede != tc.expected {
+			t.Errorf("Test %d: expected EDE %d, got %d", i, tc.expected, ede)
+		}
+		rrs := append(rec.Msg.Answer, rec.Msg.Ns...)
+		if len(rrs) != 1 || rrs[0].Header().Ttl != staleTTL {
+			t.Errorf("Test %d: expected one record with TTL %d, got %v", i, staleTTL, rrs)
+		}
+	}
+}
+
+func TestServeStaleTimeout(t *testing.T) {
+	c := New()
+	c.staleUpTo = time.Hour
+	c.verifyStale = true
+	c.staleTimeout = 10 * time.Millisecond
+	c.Next = ttlBackend(60)
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+
+	// A slow backend gets the stale entry served after the timeout, the refresh continues.
+	release := make(chan struct{})
+	refreshed := make(chan struct{})
+	c.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		<-release
+		defer close(refreshed)
+		return ttlBackend(300).ServeDNS(ctx, w, r)
+	})
+	later := time.Now().Add(2 * time.Minute)
+	c.now = func() time.Time { return later }
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	c.ServeDNS(context.TODO(), rec, req)
+	if a := rec.Msg.Answer[0]; a.Header().Ttl != staleTTL {
+		t.Errorf("Expected the stale entry with TTL %d, got %s", staleTTL, a)
+	}
+
+	close(release)
+	<-refreshed
+	rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	c.ServeDNS(context.TODO(), rec, req)
+	if a := rec.Msg.Answer[0]; a.Header().Ttl != 300 {
+		t.Errorf("Expected the refreshed entry with TTL 300, got %s", a)
+	}
+}
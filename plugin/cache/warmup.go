@@ -0,0 +1,240 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+// question is a question the cache resolves by itself, to warm it up or to keep a popular entry fresh.
+type question struct {
+	Name string
+	Type uint16
+	DO   bool
+}
+
+// parseWarmup parses a warm-up file: a name and an optional type per line, the type defaults to A. Empty lines
+// and lines starting with # are ignored.
+func parseWarmup(r io.Reader) ([]question, error) {
+	var qs []question
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: expected a name and a type, got %q", n, line)
+		}
+		q := question{Name: plugin.Name(fields[0]).Normalize(), Type: dns.TypeA}
+		if _, ok := dns.IsDomainName(q.Name); !ok {
+			return nil, fmt.Errorf("line %d: invalid name %q", n, fields[0])
+		}
+		if len(fields) > 1 {
+			t, ok := dns.StringToType[strings.ToUpper(fields[1])]
+			if !ok {
+				return nil, fmt.Errorf("line %d: unknown type %q", n, fields[1])
+			}
+			q.Type = t
+		}
+		qs = append(qs, q)
+	}
+	return qs, scanner.Err()
+}
+
+// resolve sends q to the next plugin and caches the reply.
+func (c *Cache) resolve(ctx context.Context, q question) {
+	m := new(dns.Msg)
+	m.SetQuestion(q.Name, q.Type)
+	if q.DO {
+		m.SetEdns0(4096, true)
+	}
+	state := request.Request{W: &warmupWriter{}, Req: m}
+	cw := newPrefetchResponseWriter("", state, c)
+	cw.do = q.DO
+	c.doRefresh(ctx, state, cw)
+}
+
+// resolveAll resolves the questions, a few at a time. It stops early when stop is closed.
+func (c *Cache) resolveAll(qs []question, stop <-chan struct{}) {
+	work := make(chan question)
+	var wg sync.WaitGroup
+	for i := 0; i < warmupWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for q := range work {
+				c.resolve(context.Background(), q)
+			}
+		}()
+	}
+	defer func() {
+		close(work)
+		wg.Wait()
+	}()
+	for _, q := range qs {
+		select {
+		case work <- q:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// popular returns up to n of the most popular questions in the success cache, most popular first. Replies that
+// only apply to a client subnet are skipped.
+func (c *Cache) popular(n int, now time.Time) []question {
+	type scored struct {
+		q     question
+		score float64
+	}
+	var all []scored
+	c.pcache.Walk(func(items map[uint64]interface{}, key uint64) bool {
+		if i, ok := items[key].(*item); ok && i.scope == 0 {
+			all = append(all, scored{question{i.Name, i.QType, i.do}, i.popularity.Score(c.halfLife, now)})
+		}
+		return true
+	})
+	sort.Slice(all, func(i, j int) bool { return all[i].score > all[j].score })
+	if len(all) > n {
+		all = all[:n]
+	}
+	qs := make([]question, len(all))
+	for i := range all {
+		qs[i] = all[i].q
+	}
+	return qs
+}
+
+// refreshPopular resolves the entries in the success cache that are popular, as in their score is at least the
+// prefetch amount, and that expire before the next call, or are within the prefetch percentage of their TTL.
+func (c *Cache) refreshPopular(now time.Time, next time.Duration, stop <-chan struct{}) {
+	var qs []question
+	c.pcache.Walk(func(items map[uint64]interface{}, key uint64) bool {
+		i, ok := items[key].(*item)
+		if !ok || i.scope != 0 || i.popularity.Score(c.halfLife, now) < float64(c.prefetch) {
+			return true
+		}
+		threshold := time.Duration(c.percentage) * time.Duration(i.origTTL) * time.Second / 100
+		if next > threshold {
+			threshold = next
+		}
+		if ttl := time.Duration(i.ttl(now)) * time.Second; ttl <= threshold {
+			qs = append(qs, question{i.Name, i.QType, i.do})
+		}
+		return true
+	})
+	if len(qs) == 0 {
+		return
+	}
+	cachePopularRefreshes.WithLabelValues(c.zonesMetricLabel, c.viewMetricLabel).Add(float64(len(qs)))
+
+	// Keep the popularity gathered so far.
+	scores := make(map[question]float64, len(qs))
+	for _, q := range qs {
+		if i := c.lookup(q); i != nil {
+			scores[q] = i.popularity.Score(c.halfLife, now)
+		}
+	}
+	c.resolveAll(qs, stop)
+	for q, score := range scores {
+		if i := c.lookup(q); i != nil {
+			i.popularity.Reset(now, score)
+		}
+	}
+}
+
+// lookup returns the item in the success cache for q, or nil if there is none.
+func (c *Cache) lookup(q question) *item {
+	if i, ok := c.pcache.Get(hash(strings.ToLower(q.Name), q.Type, q.DO)); ok {
+		return i.(*item)
+	}
+	return nil
+}
+
+// warmupWriter is the dns.ResponseWriter for questions the cache resolves by itself. There is no client, the
+// replies are only cached.
+type warmupWriter struct{}
+
+func (w *warmupWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+func (w *warmupWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+func (w *warmupWriter) WriteMsg(*dns.Msg) error     { return nil }
+func (w *warmupWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *warmupWriter) Close() error                { return nil }
+func (w *warmupWriter) TsigStatus() error           { return nil }
+func (w *warmupWriter) TsigTimersOnly(bool)         {}
+func (w *warmupWriter) Hijack()                     {}
+
+const (
+	warmupWorkers = 8
+
+	defaultHalfLife = 10 * time.Minute
+	// refreshInterval is how often popular entries are checked for expiry.
+	refreshInterval = 5 * time.Second
+)
+
+// run loads the snapshot and warms up the cache, after which the cache is ready. It then saves the snapshot and
+// refreshes popular entries periodically, until stop is closed.
+func (c *Cache) run(stop <-chan struct{}) {
+	if p := c.persist; p != nil {
+		n, err := c.load(p.file)
+		if err != nil {
+			log.Warningf("Failed to load cache snapshot %s: %s", p.file, err)
+		} else {
+			log.Infof("Loaded %d entries from cache snapshot %s", n, p.file)
+		}
+		if len(p.popular) > 0 {
+			cacheWarmups.WithLabelValues("snapshot", c.zonesMetricLabel, c.viewMetricLabel).Add(float64(len(p.popular)))
+			c.resolveAll(p.popular, stop)
+		}
+	}
+	if len(c.warmup) > 0 {
+		cacheWarmups.WithLabelValues("file", c.zonesMetricLabel, c.viewMetricLabel).Add(float64(len(c.warmup)))
+		c.resolveAll(c.warmup, stop)
+	}
+	atomic.StoreUint32(&c.loading, 0)
+
+	var save, refresh <-chan time.Time
+	if c.persist != nil && c.persist.interval > 0 {
+		tick := time.NewTicker(c.persist.interval)
+		defer tick.Stop()
+		save = tick.C
+	}
+	if c.decay {
+		tick := time.NewTicker(refreshInterval)
+		defer tick.Stop()
+		refresh = tick.C
+	}
+	if save == nil && refresh == nil {
+		return
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-save:
+			if _, err := c.save(c.persist.file); err != nil {
+				log.Warningf("Failed to save cache snapshot %s: %s", c.persist.file, err)
+			}
+		case <-refresh:
+			c.refreshPopular(c.now().UTC(), refreshInterval, stop)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/horahoradev/dns"
+)
+
+func TestParseWarmup(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		expected  []question
+	}{
+		{"example.org", false, []question{{"example.org.", dns.TypeA, false}}},
+		{"# comment\n\nexample.org. AAAA\nExample.NET mx\n", false,
+			[]question{{"example.org.", dns.TypeAAAA, false}, {"example.net.", dns.TypeMX, false}}},
+		{"", false, nil},
+		// fails
+		{"example.org. A IN", true, nil},
+		{"example.org. BOGUS", true, nil},
+		{"example..org.", true, nil},
+	}
+	for i, tc := range tests {
+		qs, err := parseWarmup(strings.NewReader(tc.input))
+		if tc.shouldErr && err == nil {
+			t.Errorf("Test %d: Expected error but found nil", i)
+			continue
+		} else if !tc.shouldErr && err != nil {
+			t.Errorf("Test %d: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if tc.shouldErr {
+			continue
+		}
+		if len(qs) != len(tc.expected) {
+			t.Errorf("Test %d: Expected %d questions, got %d", i, len(tc.expected), len(qs))
+			continue
+		}
+		for j := range qs {
+			if qs[j] != tc.expected[j] {
+				t.Errorf("Test %d: Expected question %v, got %v", i, tc.expected[j], qs[j])
+			}
+		}
+	}
+}
+
+// warmupBackend answers every question with an A record with a TTL of 100, and counts the queries.
+func warmupBackend(queries *int32) plugin.Handler {
+	return plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		atomic.AddInt32(queries, 1)
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{test.A(r.Question[0].Name + " 100 IN A 127.0.0.1")}
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+}
+
+func TestWarmup(t *testing.T) {
+	var queries int32
+	c := New()
+	c.Next = warmupBackend(&queries)
+
+	qs := []question{{"a.example.org.", dns.TypeA, false}, {"b.example.org.", dns.TypeA, true}}
+	for i := 0; i < 20; i++ {
+		qs = append(qs, question{string(rune('c'+i)) + ".example.org.", dns.TypeA, false})
+	}
+	c.resolveAll(qs, make(chan struct{}))
+
+	if q := atomic.LoadInt32(&queries); q != int32(len(qs)) {
+		t.Errorf("Expected %d queries to the backend, got %d", len(qs), q)
+	}
+	for _, q := range qs {
+		if c.lookup(q) == nil {
+			t.Errorf("Expected %v to be cached", q)
+		}
+	}
+
+	// Clients are served from the cache.
+	m := new(dns.Msg)
+	m.SetQuestion("b.example.org.", dns.TypeA)
+	m.SetEdns0(4096, true)
+	c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), m)
+	if q := atomic.LoadInt32(&queries); q != int32(len(qs)) {
+		t.Errorf("Expected the warmed up reply to be served from the cache")
+	}
+}
+
+func TestRefreshPopular(t *testing.T) {
+	now := time.Now().UTC()
+	var queries int32
+	c := New()
+	c.Next = warmupBackend(&queries)
+	c.prefetch = 5
+	c.decay = true
+	c.halfLife = 10 * time.Minute
+	c.now = func() time.Time { return now }
+
+	hot := question{"hot.example.org.", dns.TypeA, false}
+	cold := question{"cold.example.org.", dns.TypeA, false}
+	c.resolveAll([]question{hot, cold}, make(chan struct{}))
+	for i := 0; i < 10; i++ {
+		m := new(dns.Msg)
+		m.SetQuestion(hot.Name, hot.Type)
+		c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), m)
+	}
+	atomic.StoreInt32(&queries, 0)
+
+	// Nothing is about to expire.
+	c.refreshPopular(now, refreshInterval, make(chan struct{}))
+	if q := atomic.LoadInt32(&queries); q != 0 {
+		t.Errorf("Expected no refreshes, got %d", q)
+	}
+
+	// 97 seconds later, only the popular entry is refreshed. Its score has decayed, but not below the threshold.
+	later := now.Add(97 * time.Second)
+	c.now = func() time.Time { return later }
+	score := c.lookup(hot).popularity.Score(c.halfLife, later)
+	c.refreshPopular(later, refreshInterval, make(chan struct{}))
+	if q := atomic.LoadInt32(&queries); q != 1 {
+		t.Errorf("Expected 1 refresh, got %d", q)
+	}
+	i := c.lookup(hot)
+	if ttl := i.ttl(later); ttl != 100 {
+		t.Errorf("Expected the refreshed entry to have a TTL of 100, got %d", ttl)
+	}
+	if s := i.popularity.Score(c.halfLife, later); s != score {
+		t.Errorf("Expected the refreshed entry to keep its score %f, got %f", score, s)
+	}
+}
+
+func TestPersistPopular(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache.snapshot")
+	now := time.Now().UTC()
+
+	var queries int32
+	c := New()
+	c.Next = warmupBackend(&queries)
+	c.now = func() time.Time { return now }
+	c.warmupTop = 2
+
+	for i, name := range []string{"a.example.org.", "b.example.org.", "c.example.org."} {
+		for j := 0; j <= i; j++ {
+			m := new(dns.Msg)
+			m.SetQuestion(name, dns.TypeA)
+			c.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), m)
+		}
+	}
+	if _, err := c.save(file); err != nil {
+		t.Fatalf("Expected no error saving the snapshot, got %s", err)
+	}
+
+	c1 := New()
+	c1.persist = &persist{file: file}
+	c1.now = func() time.Time { return now }
+	if _, err := c1.load(file); err != nil {
+		t.Fatalf("Expected no error loading the snapshot, got %s", err)
+	}
+	expected := []question{{"c.example.org.", dns.TypeA, false}, {"b.example.org.", dns.TypeA, false}}
+	if len(c1.persist.popular) != len(expected) {
+		t.Fatalf("Expected %d popular questions, got %v", len(expected), c1.persist.popular)
+	}
+	for i := range expected {
+		if c1.persist.popular[i] != expected[i] {
+			t.Errorf("Expected popular question %v, got %v", expected[i], c1.persist.popular[i])
+		}
+	}
+	if s := c1.lookup(expected[0]).popularity.Score(c1.halfLife, now); s < 2 {
+		t.Errorf("Expected the loaded entry to keep its score, got %f", s)
+	}
+}
@@ -0,0 +1,236 @@
+// Package clients implements a plugin that sorts clients into named groups by their address.
+package clients
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/metrics"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+	"github.com/infobloxopen/go-trees/iptree"
+)
+
+var log = clog.NewWithPlugin(pluginName)
+
+// Clients is a plugin that publishes the group of the client of a request as metadata.
+type Clients struct {
+	Next plugin.Handler
+
+	groups []*group
+	files  []*file // all files of the groups
+	ecs    bool    // use the address in the EDNS0 subnet option, if present
+	reload time.Duration
+
+	sync.RWMutex
+	tree *iptree.Tree // networks to the names of their groups
+}
+
+// group is a named group of networks.
+type group struct {
+	name  string
+	nets  []*net.IPNet
+	files []*file
+}
+
+// file is a file with networks, one per line.
+type file struct {
+	path string
+	nets []*net.IPNet
+
+	// mtime and size are only read and modified by a single goroutine
+	mtime time.Time
+	size  int64
+}
+
+// ServeDNS implements the plugin.Handler interface.
+func (c *Clients) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+	group, _ := c.match(state)
+	RequestCount.WithLabelValues(metrics.WithServer(ctx), group, metrics.WithView(ctx)).Inc()
+	return plugin.NextOrFailure(c.Name(), c.Next, ctx, w, r)
+}
+
+// Metadata implements the metadata.Provider interface.
+func (c *Clients) Metadata(ctx context.Context, state request.Request) context.Context {
+	group, ip := c.match(state)
+	if ip != nil {
+		metadata.SetValueFunc(ctx, pluginName+"/address", ip.String)
+	}
+	if group != "" {
+		metadata.SetValueFunc(ctx, pluginName+"/group", func() string { return group })
+	}
+	return ctx
+}
+
+// match returns the group of the client of the request, along with the address it is matched on. The group is
+// empty if the address isn't in any group.
+func (c *Clients) match(state request.Request) (string, net.IP) {
+	ip := c.address(state)
+	if ip == nil {
+		return "", nil
+	}
+	c.RLock()
+	v, ok := c.tree.GetByIP(ip)
+	c.RUnlock()
+	if !ok {
+		return "", ip
+	}
+	return v.(string), ip
+}
+
+// address returns the address of the client, which is the one in the EDNS0 subnet option if that is enabled and
+// present.
+func (c *Clients) address(state request.Request) net.IP {
+	if c.ecs {
+		if o := state.Req.IsEdns0(); o != nil {
+			for _, s := range o.Option {
+				if e, ok := s.(*dns.EDNS0_SUBNET); ok {
+					return e.Address
+				}
+			}
+		}
+	}
+	ip := state.IP()
+	if i := strings.IndexByte(ip, '%'); i >= 0 {
+		ip = ip[:i]
+	}
+	return net.ParseIP(ip)
+}
+
+// readFiles reads the files that have changed since the last read, and rebuilds the tree if there are any. If a
+// file can't be read or parsed, the tree is left unchanged.
+func (c *Clients) readFiles() error {
+	type update struct {
+		f     *file
+		nets  []*net.IPNet
+		mtime time.Time
+		size  int64
+	}
+	var updates []update
+	for _, f := range c.files {
+		fh, err := os.Open(f.path)
+		if err != nil {
+			return err
+		}
+		stat, err := fh.Stat()
+		if err != nil {
+			fh.Close()
+			return err
+		}
+		if f.mtime.Equal(stat.ModTime()) && f.size == stat.Size() {
+			fh.Close()
+			continue
+		}
+		nets, err := parseNets(fh)
+		fh.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", f.path, err)
+		}
+		updates = append(updates, update{f, nets, stat.ModTime(), stat.Size()})
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	for _, u := range updates {
+		u.f.nets, u.f.mtime, u.f.size = u.nets, u.mtime, u.size
+		FileEntries.WithLabelValues(u.f.path).Set(float64(len(u.nets)))
+		FileReloadTime.WithLabelValues(u.f.path).Set(float64(time.Now().UnixNano()) / 1e9)
+	}
+	c.build()
+	return nil
+}
+
+// build builds the tree from the networks of the groups and replaces the current one. When a network is in more
+// than one group, the first group wins.
+func (c *Clients) build() {
+	tree := iptree.NewTree()
+	for i := len(c.groups) - 1; i >= 0; i-- {
+		g := c.groups[i]
+		for _, f := range g.files {
+			for _, n := range f.nets {
+				tree.InplaceInsertNet(n, g.name)
+			}
+		}
+		for _, n := range g.nets {
+			tree.InplaceInsertNet(n, g.name)
+		}
+	}
+	c.Lock()
+	c.tree = tree
+	c.Unlock()
+}
+
+// periodicUpdate checks the files for changes until stop is closed.
+func (c *Clients) periodicUpdate(stop chan struct{}) {
+	ticker := time.NewTicker(c.reload)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := c.readFiles(); err != nil {
+				log.Errorf("Failed to reload the groups, keeping the previous ones: %s", err)
+			}
+		}
+	}
+}
+
+// parseNets parses networks and addresses, one per line. Empty lines and comments that start with '#' are skipped.
+func parseNets(r io.Reader) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	scanner := bufio.NewScanner(r)
+	i := 0
+	for scanner.Scan() {
+		i++
+		line := scanner.Text()
+		if j := strings.IndexByte(line, '#'); j >= 0 {
+			line = line[:j]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		n, err := parseNet(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, scanner.Err()
+}
+
+// parseNet parses a network in CIDR notation, or a single address.
+func parseNet(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("illegal address %q", s)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("illegal CIDR notation %q", s)
+	}
+	return n, nil
+}
+
+// Name implements the plugin.Handler interface.
+func (c *Clients) Name() string { return pluginName }
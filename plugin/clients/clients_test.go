@@ -0,0 +1,122 @@
+package clients
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+func TestMetadata(t *testing.T) {
+	cl, err := parse(caddy.NewTestController("dns", `clients {
+		group office 10.240.0.0/16 192.0.2.0/24
+		group servers 10.240.0.1
+		group wide 10.0.0.0/8
+		group first 198.51.100.0/24
+		group second 198.51.100.0/24
+		ecs
+	}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	// test.ResponseWriter has a remote address of 10.240.0.1, and test.ResponseWriter6 one of fe80::42:ff:feca:4c65.
+	tests := []struct {
+		w       dns.ResponseWriter
+		subnet  string
+		group   string
+		address string
+	}{
+		// the most specific network wins
+		{&test.ResponseWriter{}, "", "servers", "10.240.0.1"},
+		{&test.ResponseWriter{}, "192.0.2.1", "office", "192.0.2.1"},
+		{&test.ResponseWriter{}, "10.1.0.1", "wide", "10.1.0.1"},
+		// of identical networks, the first group wins
+		{&test.ResponseWriter{}, "198.51.100.1", "first", "198.51.100.1"},
+		{&test.ResponseWriter{}, "203.0.113.1", "", "203.0.113.1"},
+		{&test.ResponseWriter6{}, "", "", "fe80::42:ff:feca:4c65"},
+	}
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeA)
+		if tc.subnet != "" {
+			m.SetEdns0(4096, false)
+			m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_SUBNET{
+				Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 32, Address: net.ParseIP(tc.subnet).To4(),
+			})
+		}
+		ctx := metadata.ContextWithMetadata(context.Background())
+		cl.Metadata(ctx, request.Request{W: tc.w, Req: m})
+
+		group := ""
+		if f := metadata.ValueFunc(ctx, "clients/group"); f != nil {
+			group = f()
+		}
+		if group != tc.group {
+			t.Errorf("Test %d: Expected group %q, got %q", i, tc.group, group)
+		}
+		address := ""
+		if f := metadata.ValueFunc(ctx, "clients/address"); f != nil {
+			address = f()
+		}
+		if address != tc.address {
+			t.Errorf("Test %d: Expected address %q, got %q", i, tc.address, address)
+		}
+	}
+}
+
+func TestReadFiles(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "lab.txt")
+	if err := os.WriteFile(file, []byte("# lab\n10.240.0.0/16\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cl, err := parse(caddy.NewTestController("dns", `clients {
+		group lab file `+file+`
+		group other 10.0.0.0/8
+	}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	group := func() string {
+		g, _ := cl.match(request.Request{W: &test.ResponseWriter{}, Req: new(dns.Msg)})
+		return g
+	}
+	if g := group(); g != "lab" {
+		t.Errorf("Expected group lab, got %q", g)
+	}
+
+	// A file that fails to parse leaves the groups unchanged.
+	mtime := time.Now().Add(time.Minute)
+	if err := os.WriteFile(file, []byte("10.241.0.0/16\nbogus\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(file, mtime, mtime)
+	if err := cl.readFiles(); err == nil {
+		t.Errorf("Expected an error reading an invalid file")
+	}
+	if g := group(); g != "lab" {
+		t.Errorf("Expected group lab to be kept, got %q", g)
+	}
+
+	mtime = mtime.Add(time.Minute)
+	if err := os.WriteFile(file, []byte("10.241.0.0/16\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(file, mtime, mtime)
+	if err := cl.readFiles(); err != nil {
+		t.Errorf("Expected no error, got %s", err)
+	}
+	if g := group(); g != "other" {
+		t.Errorf("Expected group other after the reload, got %q", g)
+	}
+}
@@ -0,0 +1,32 @@
+package clients
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// RequestCount is the number of requests per group of clients.
+	RequestCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "requests_total",
+		Help:      "Counter of requests per group of clients.",
+	}, []string{"server", "group", "view"})
+	// FileEntries is the number of networks in a file.
+	FileEntries = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "file_entries",
+		Help:      "The number of networks in a file.",
+	}, []string{"file"})
+	// FileReloadTime is the timestamp of the last reload of a file.
+	FileReloadTime = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "file_reload_timestamp_seconds",
+		Help:      "The timestamp of the last reload of a file.",
+	}, []string{"file"})
+)
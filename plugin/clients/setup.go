@@ -0,0 +1,131 @@
+package clients
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+)
+
+const pluginName = "clients"
+
+// defaultReload is the default interval to check the files for changes.
+const defaultReload = 5 * time.Second
+
+func init() { plugin.Register(pluginName, setup) }
+
+func setup(c *caddy.Controller) error {
+	cl, err := parse(c)
+	if err != nil {
+		return plugin.Error(pluginName, err)
+	}
+
+	if len(cl.files) > 0 && cl.reload > 0 {
+		stop := make(chan struct{})
+		c.OnStartup(func() error {
+			go cl.periodicUpdate(stop)
+			return nil
+		})
+		c.OnShutdown(func() error {
+			close(stop)
+			return nil
+		})
+	}
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		cl.Next = next
+		return cl
+	})
+
+	return nil
+}
+
+func parse(c *caddy.Controller) (*Clients, error) {
+	cl := &Clients{reload: defaultReload}
+	root := dnsserver.GetConfig(c).Root
+	files := make(map[string]*file)
+	names := make(map[string]bool)
+
+	i := 0
+	for c.Next() {
+		i++
+		if i > 1 {
+			return nil, plugin.ErrOnce
+		}
+		if len(c.RemainingArgs()) != 0 {
+			return nil, c.ArgErr()
+		}
+
+		for c.NextBlock() {
+			switch c.Val() {
+			case "group":
+				args := c.RemainingArgs()
+				if len(args) < 2 {
+					return nil, c.ArgErr()
+				}
+				if names[args[0]] {
+					return nil, c.Errf("group %q is defined more than once", args[0])
+				}
+				names[args[0]] = true
+				g := &group{name: args[0]}
+				for j := 1; j < len(args); j++ {
+					if strings.ToLower(args[j]) == "file" {
+						if j++; j == len(args) {
+							return nil, c.Err("file requires a path")
+						}
+						path := args[j]
+						if !filepath.IsAbs(path) && root != "" {
+							path = filepath.Join(root, path)
+						}
+						f, ok := files[path]
+						if !ok {
+							f = &file{path: path}
+							files[path] = f
+							cl.files = append(cl.files, f)
+						}
+						g.files = append(g.files, f)
+						continue
+					}
+					n, err := parseNet(args[j])
+					if err != nil {
+						return nil, c.Err(err.Error())
+					}
+					g.nets = append(g.nets, n)
+				}
+				cl.groups = append(cl.groups, g)
+			case "ecs":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+				cl.ecs = true
+			case "reload":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				reload, err := time.ParseDuration(args[0])
+				if err != nil || reload < 0 {
+					return nil, c.Errf("invalid duration for reload %q", args[0])
+				}
+				cl.reload = reload
+			default:
+				return nil, c.Errf("unknown property %q", c.Val())
+			}
+		}
+	}
+	if len(cl.groups) == 0 {
+		return nil, c.Err("at least one group is required")
+	}
+
+	// Files that can't be read at startup are an error, later they are only logged.
+	if err := cl.readFiles(); err != nil {
+		return nil, c.Err(err.Error())
+	}
+	if cl.tree == nil {
+		cl.build()
+	}
+	return cl, nil
+}
@@ -0,0 +1,94 @@
+package clients
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coredns/caddy"
+)
+
+func TestSetup(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "lab.txt")
+	if err := os.WriteFile(file, []byte("10.2.0.0/16\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		config    string
+		shouldErr bool
+		groups    int
+		files     int
+		ecs       bool
+	}{
+		{`clients {
+			group office 10.1.0.0/16 2001:db8:1::/48 192.0.2.1
+		}`, false, 1, 0, false},
+		{`clients {
+			group office 10.1.0.0/16
+			group lab file ` + file + ` 10.3.0.0/16
+			group all file ` + file + `
+			ecs
+			reload 1m
+		}`, false, 3, 1, true},
+		// fails
+		{`clients`, true, 0, 0, false},
+		{`clients office {
+			group office 10.1.0.0/16
+		}`, true, 0, 0, false},
+		{`clients {
+			group office
+		}`, true, 0, 0, false},
+		{`clients {
+			group office 10.1.0/16
+		}`, true, 0, 0, false},
+		{`clients {
+			group office 10.1.0.0/16
+			group office 10.2.0.0/16
+		}`, true, 0, 0, false},
+		{`clients {
+			group office file
+		}`, true, 0, 0, false},
+		{`clients {
+			group office file /does/not/exist
+		}`, true, 0, 0, false},
+		{`clients {
+			group office 10.1.0.0/16
+			reload -1s
+		}`, true, 0, 0, false},
+		{`clients {
+			group office 10.1.0.0/16
+			bogus
+		}`, true, 0, 0, false},
+		{`clients {
+			group office 10.1.0.0/16
+		}
+		clients {
+			group lab 10.2.0.0/16
+		}`, true, 0, 0, false},
+	}
+
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.config)
+		cl, err := parse(c)
+		if tc.shouldErr && err == nil {
+			t.Errorf("Test %d: Expected error but found nil", i)
+			continue
+		} else if !tc.shouldErr && err != nil {
+			t.Errorf("Test %d: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if tc.shouldErr {
+			continue
+		}
+		if len(cl.groups) != tc.groups {
+			t.Errorf("Test %d: Expected %d groups, got %d", i, tc.groups, len(cl.groups))
+		}
+		if len(cl.files) != tc.files {
+			t.Errorf("Test %d: Expected %d files, got %d", i, tc.files, len(cl.files))
+		}
+		if cl.ecs != tc.ecs {
+			t.Errorf("Test %d: Expected ecs %t, got %t", i, tc.ecs, cl.ecs)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+// Package cookie implements DNS Cookies (RFC 7873) with interoperable server cookies (RFC 9018).
+package cookie
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+var log = clog.NewWithPlugin("cookie")
+
+// Cookie is a plugin that validates and generates DNS server cookies.
+type Cookie struct {
+	Next plugin.Handler
+
+	// enforce returns BADCOOKIE for UDP queries with an invalid server cookie.
+	enforce bool
+	// require is the maximum size of a UDP response to a query without a valid server cookie, 0 disables the check.
+	require int
+
+	// secretFile holds the secrets, one per line, when they are not configured inline.
+	secretFile string
+	reload     time.Duration
+
+	sync.RWMutex
+	secrets [][]byte // the first secret is used to generate cookies, all are used to validate them
+
+	// mtime and size are only read and modified by a single goroutine
+	mtime time.Time
+	size  int64
+
+	now func() time.Time
+}
+
+// New returns a new Cookie.
+func New() *Cookie {
+	return &Cookie{reload: defaultReload, now: time.Now}
+}
+
+// ServeDNS implements the plugin.Handler interface.
+func (c *Cookie) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+	server := metrics.WithServer(ctx)
+	udp := state.Proto() == "udp"
+
+	opt := cookieOption(r)
+	if opt == nil {
+		RequestCount.WithLabelValues(server, "none").Inc()
+		if c.require > 0 && udp {
+			w = &ResponseWriter{ResponseWriter: w, server: server, require: c.require}
+		}
+		return plugin.NextOrFailure(c.Name(), c.Next, ctx, w, r)
+	}
+
+	cc, sc, err := parseCookie(opt.Cookie)
+	if err != nil {
+		RequestCount.WithLabelValues(server, "malformed").Inc()
+		return dns.RcodeFormatError, err
+	}
+
+	ip := net.ParseIP(state.IP())
+	now := c.now()
+	valid := false
+	status := "client"
+	if sc != nil {
+		valid = c.valid(cc, sc, ip, now)
+		status = "invalid"
+		if valid {
+			status = "valid"
+		}
+	}
+	RequestCount.WithLabelValues(server, status).Inc()
+
+	cookie := hex.EncodeToString(cc) + hex.EncodeToString(c.generate(cc, ip, now))
+
+	// An invalid server cookie over UDP may be spoofed; tell the client to retry with the new cookie.
+	if sc != nil && !valid && c.enforce && udp {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeBadCookie)
+		o := r.IsEdns0()
+		m.SetEdns0(o.UDPSize(), o.Do())
+		setCookie(m.IsEdns0(), cookie)
+		w.WriteMsg(m)
+		return dns.RcodeBadCookie, nil
+	}
+
+	cw := &ResponseWriter{ResponseWriter: w, server: server, cookie: cookie, request: r}
+	if !valid && udp {
+		cw.require = c.require
+	}
+	return plugin.NextOrFailure(c.Name(), c.Next, ctx, cw, r)
+}
+
+// Name implements the plugin.Handler interface.
+func (c *Cookie) Name() string { return "cookie" }
+
+// generate returns a server cookie for the client cookie cc and client address ip as specified in RFC 9018,
+// section 4: Version | Reserved | Timestamp | Hash, where Hash is the SipHash-2-4 of the client cookie, the
+// first 8 bytes of the server cookie and the client address.
+func (c *Cookie) generate(cc []byte, ip net.IP, now time.Time) []byte {
+	c.RLock()
+	secret := c.secrets[0]
+	c.RUnlock()
+	return serverCookie(secret, cc, ip, uint32(now.Unix()))
+}
+
+// valid returns true if sc is a server cookie generated with one of the secrets for cc and ip, that isn't expired.
+func (c *Cookie) valid(cc, sc []byte, ip net.IP, now time.Time) bool {
+	if len(sc) != serverCookieLen || sc[0] != version {
+		return false
+	}
+	// Serial number arithmetic, the timestamp wraps around in 2106.
+	ts := binary.BigEndian.Uint32(sc[4:8])
+	age := int32(uint32(now.Unix()) - ts)
+	if age > int32(maxAge/time.Second) || age < -int32(maxSkew/time.Second) {
+		return false
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+	for _, secret := range c.secrets {
+		if subtle.ConstantTimeCompare(serverCookie(secret, cc, ip, ts), sc) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// setSecrets sets the secrets used for generating and validating cookies.
+func (c *Cookie) setSecrets(secrets [][]byte) {
+	c.Lock()
+	c.secrets = secrets
+	c.Unlock()
+}
+
+// readSecrets reads the secrets from secretFile if its size or modification time changed.
+func (c *Cookie) readSecrets() error {
+	file, err := os.Open(c.secretFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if c.mtime.Equal(stat.ModTime()) && c.size == stat.Size() {
+		return nil
+	}
+
+	secrets, err := parseSecretFile(file)
+	if err != nil {
+		return err
+	}
+	c.setSecrets(secrets)
+	c.mtime = stat.ModTime()
+	c.size = stat.Size()
+	SecretReloadTime.Set(float64(stat.ModTime().UnixNano()) / 1e9)
+	return nil
+}
+
+func serverCookie(secret, cc []byte, ip net.IP, ts uint32) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	sc := make([]byte, serverCookieLen)
+	sc[0] = version
+	binary.BigEndian.PutUint32(sc[4:8], ts)
+
+	msg := make([]byte, 0, len(cc)+8+len(ip))
+	msg = append(msg, cc...)
+	msg = append(msg, sc[:8]...)
+	msg = append(msg, ip...)
+	binary.LittleEndian.PutUint64(sc[8:], siphash(secret, msg))
+	return sc
+}
+
+// parseCookie returns the client and server cookie of the hex encoded cookie option s. The server cookie is nil
+// if the option only has a client cookie.
+func parseCookie(s string) ([]byte, []byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, nil, errMalformed
+	}
+	switch {
+	case len(b) == clientCookieLen:
+		return b, nil, nil
+	case len(b) >= clientCookieLen+8 && len(b) <= clientCookieLen+32:
+		return b[:clientCookieLen], b[clientCookieLen:], nil
+	}
+	return nil, nil, errMalformed
+}
+
+// cookieOption returns the cookie option of m, or nil if there is none.
+func cookieOption(m *dns.Msg) *dns.EDNS0_COOKIE {
+	o := m.IsEdns0()
+	if o == nil {
+		return nil
+	}
+	for _, s := range o.Option {
+		if e, ok := s.(*dns.EDNS0_COOKIE); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// setCookie sets the cookie option in o to cookie, replacing any existing cookie option.
+func setCookie(o *dns.OPT, cookie string) {
+	for _, s := range o.Option {
+		if e, ok := s.(*dns.EDNS0_COOKIE); ok {
+			e.Cookie = cookie
+			return
+		}
+	}
+	o.Option = append(o.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie})
+}
+
+// newSecret returns a random secret.
+func newSecret() ([]byte, error) {
+	secret := make([]byte, secretLen)
+	_, err := rand.Read(secret)
+	return secret, err
+}
+
+var errMalformed = errors.New("malformed cookie")
+
+const (
+	version         = 1
+	clientCookieLen = 8
+	serverCookieLen = 16
+	secretLen       = 16
+
+	// maxAge and maxSkew define how old, or how far in the future, the timestamp of a valid server cookie
+	// may be, see RFC 9018, section 4.3.
+	maxAge  = 1 * time.Hour
+	maxSkew = 5 * time.Minute
+
+	defaultReload = 1 * time.Minute
+)
@@ -0,0 +1,220 @@
+package cookie
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/horahoradev/dns"
+)
+
+func TestServerCookie(t *testing.T) {
+	// Test vector from RFC 9018, Appendix A.2.
+	cc, _ := hex.DecodeString("2464c4abcf10c957")
+	secret, _ := hex.DecodeString("e5e973e5a6b2a43f48e7dc849e37bfcf")
+
+	sc := serverCookie(secret, cc, net.ParseIP("198.51.100.100"), 1559731985)
+	if got := hex.EncodeToString(sc); got != "010000005cf79f111f8130c3eee29480" {
+		t.Errorf("Expected server cookie 010000005cf79f111f8130c3eee29480, got %s", got)
+	}
+}
+
+func TestValid(t *testing.T) {
+	old, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	secret, _ := hex.DecodeString("e5e973e5a6b2a43f48e7dc849e37bfcf")
+	cc, _ := hex.DecodeString("2464c4abcf10c957")
+	ip := net.ParseIP("198.51.100.100")
+	now := time.Unix(1559731985, 0)
+
+	c := New()
+	c.setSecrets([][]byte{old})
+	sc := c.generate(cc, ip, now)
+	c.setSecrets([][]byte{secret, old})
+
+	tests := []struct {
+		cc, sc   []byte
+		ip       net.IP
+		now      time.Time
+		expected bool
+	}{
+		{cc, sc, ip, now, true},
+		{cc, sc, ip, now.Add(maxAge), true},
+		{cc, sc, ip, now.Add(-maxSkew), true},
+		{cc, sc, ip, now.Add(maxAge + time.Second), false},
+		{cc, sc, ip, now.Add(-maxSkew - time.Second), false},
+		{cc, sc, net.ParseIP("198.51.100.101"), now, false},
+		{[]byte("otherccc"), sc, ip, now, false},
+		{cc, sc[:8], ip, now, false},
+	}
+
+	for i, tc := range tests {
+		if got := c.valid(tc.cc, tc.sc, tc.ip, tc.now); got != tc.expected {
+			t.Errorf("Test %d: expected valid to be %t, got %t", i, tc.expected, got)
+		}
+	}
+
+	c.setSecrets([][]byte{secret})
+	if c.valid(cc, sc, ip, now) {
+		t.Errorf("Expected cookie generated with a removed secret to be invalid")
+	}
+}
+
+func TestParseCookie(t *testing.T) {
+	tests := []struct {
+		cookie    string
+		cc, sc    string
+		shouldErr bool
+	}{
+		{"2464c4abcf10c957", "2464c4abcf10c957", "", false},
+		{"2464c4abcf10c957010000005cf79f111f8130c3eee29480", "2464c4abcf10c957", "010000005cf79f111f8130c3eee29480", false},
+		{"2464c4abcf10c9570102030405060708", "2464c4abcf10c957", "0102030405060708", false},
+		{"2464c4ab", "", "", true},
+		{"2464c4abcf10c95701", "", "", true},
+		{"2464c4abcf10c957" + strings.Repeat("00", 33), "", "", true},
+		{"not hex", "", "", true},
+	}
+
+	for i, tc := range tests {
+		cc, sc, err := parseCookie(tc.cookie)
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error, got none", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		if hex.EncodeToString(cc) != tc.cc || hex.EncodeToString(sc) != tc.sc {
+			t.Errorf("Test %d: expected %s %s, got %x %x", i, tc.cc, tc.sc, cc, sc)
+		}
+	}
+}
+
+func TestCookie(t *testing.T) {
+	secret, _ := hex.DecodeString("e5e973e5a6b2a43f48e7dc849e37bfcf")
+	clientCookie := "2464c4abcf10c957"
+	// test.ResponseWriter uses 10.240.0.1 as the client address.
+	now := time.Now()
+	cc, _ := hex.DecodeString(clientCookie)
+	sc := hex.EncodeToString(serverCookie(secret, cc, net.ParseIP("10.240.0.1"), uint32(now.Unix())))
+	bad := hex.EncodeToString(serverCookie(secret, cc, net.ParseIP("10.240.0.2"), uint32(now.Unix())))
+
+	// A reply that is larger than 512 bytes.
+	next := func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		for i := 0; i < 20; i++ {
+			m.Answer = append(m.Answer, test.TXT("example.org. 5 IN TXT "+strings.Repeat("a", 32)))
+		}
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	}
+
+	tests := []struct {
+		cookie    string // cookie option in the request, "-" for no EDNS
+		enforce   bool
+		require   int
+		tcp       bool
+		rcode     int
+		truncated bool
+		reply     bool // expect a cookie in the reply
+	}{
+		{"-", false, 0, false, dns.RcodeSuccess, false, false},
+		{"", false, 0, false, dns.RcodeSuccess, false, false},
+		{"", false, 512, false, dns.RcodeSuccess, true, false},
+		{"", false, 512, true, dns.RcodeSuccess, false, false},
+		{clientCookie, false, 0, false, dns.RcodeSuccess, false, true},
+		{clientCookie, false, 512, false, dns.RcodeSuccess, true, true},
+		{clientCookie + sc, false, 512, false, dns.RcodeSuccess, false, true},
+		{clientCookie + bad, false, 0, false, dns.RcodeSuccess, false, true},
+		{clientCookie + bad, true, 0, false, dns.RcodeBadCookie, false, true},
+		{clientCookie + bad, true, 0, true, dns.RcodeSuccess, false, true},
+		{"2464", false, 0, false, dns.RcodeFormatError, false, false},
+	}
+
+	for i, tc := range tests {
+		c := New()
+		c.setSecrets([][]byte{secret})
+		c.enforce = tc.enforce
+		c.require = tc.require
+		c.Next = test.HandlerFunc(next)
+
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeTXT)
+		if tc.cookie != "-" {
+			m.SetEdns0(4096, false)
+			if tc.cookie != "" {
+				setCookie(m.IsEdns0(), tc.cookie)
+			}
+		}
+
+		var w dns.ResponseWriter = &test.ResponseWriter{}
+		if tc.tcp {
+			w = &test.ResponseWriter{TCP: true}
+		}
+		rec := dnstest.NewRecorder(w)
+		rcode, _ := c.ServeDNS(context.TODO(), rec, m)
+		if rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %d, got %d", i, tc.rcode, rcode)
+		}
+		if tc.rcode == dns.RcodeFormatError {
+			continue
+		}
+		if rec.Msg.Truncated != tc.truncated {
+			t.Errorf("Test %d: expected truncated to be %t, got %t", i, tc.truncated, rec.Msg.Truncated)
+		}
+		if tc.truncated && len(rec.Msg.Answer) != 0 {
+			t.Errorf("Test %d: expected no answers in a truncated reply, got %d", i, len(rec.Msg.Answer))
+		}
+
+		opt := cookieOption(rec.Msg)
+		if !tc.reply {
+			if opt != nil {
+				t.Errorf("Test %d: expected no cookie in the reply, got %s", i, opt.Cookie)
+			}
+			continue
+		}
+		if opt == nil {
+			t.Fatalf("Test %d: expected a cookie in the reply, got none", i)
+		}
+		if opt.Cookie != clientCookie+sc {
+			t.Errorf("Test %d: expected cookie %s, got %s", i, clientCookie+sc, opt.Cookie)
+		}
+	}
+}
+
+func TestReadSecrets(t *testing.T) {
+	tests := []struct {
+		input     string
+		expected  int
+		shouldErr bool
+	}{
+		{"e5e973e5a6b2a43f48e7dc849e37bfcf\n", 1, false},
+		{"# comment\n e5e973e5a6b2a43f48e7dc849e37bfcf \n\n000102030405060708090a0b0c0d0e0f", 2, false},
+		{"# no secrets\n", 0, true},
+		{"e5e973e5a6b2a43f\n", 0, true},
+	}
+
+	for i, tc := range tests {
+		secrets, err := parseSecretFile(strings.NewReader(tc.input))
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error, got none", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		if len(secrets) != tc.expected {
+			t.Errorf("Test %d: expected %d secrets, got %d", i, tc.expected, len(secrets))
+		}
+	}
+}
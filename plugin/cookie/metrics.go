@@ -0,0 +1,32 @@
+package cookie
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// RequestCount is the number of requests per cookie status.
+	RequestCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cookie",
+		Name:      "requests_total",
+		Help:      "Counter of requests per cookie status.",
+	}, []string{"server", "status"})
+	// TruncatedCount is the number of UDP responses truncated because the request had no valid server cookie.
+	TruncatedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cookie",
+		Name:      "truncated_responses_total",
+		Help:      "Counter of UDP responses truncated because the request had no valid server cookie.",
+	}, []string{"server"})
+	// SecretReloadTime is the timestamp of the last reload of the secret file.
+	SecretReloadTime = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cookie",
+		Name:      "secret_reload_timestamp_seconds",
+		Help:      "The timestamp of the last reload of the secret file.",
+	})
+)
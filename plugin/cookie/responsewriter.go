@@ -0,0 +1,46 @@
+package cookie
+
+import (
+	"github.com/horahoradev/dns"
+)
+
+// ResponseWriter adds the cookie option to the response and truncates large UDP responses to queries without a
+// valid server cookie.
+type ResponseWriter struct {
+	dns.ResponseWriter
+	server  string // server label for metrics
+	cookie  string // hex encoded client and server cookie to add to the response, empty if none
+	require int    // maximum size of the response, 0 means no limit
+	request *dns.Msg
+}
+
+// WriteMsg implements the dns.ResponseWriter interface.
+func (w *ResponseWriter) WriteMsg(res *dns.Msg) error {
+	if w.cookie != "" {
+		if res.IsEdns0() == nil {
+			o := w.request.IsEdns0()
+			res.SetEdns0(o.UDPSize(), o.Do())
+		}
+		setCookie(res.IsEdns0(), w.cookie)
+	}
+
+	if w.require > 0 && res.Len() > w.require {
+		// Keep the OPT RR, so the client learns our server cookie and can retry with it (or use TCP).
+		extra := []dns.RR{}
+		if o := res.IsEdns0(); o != nil {
+			extra = append(extra, o)
+		}
+		res.Answer, res.Ns, res.Extra = nil, nil, extra
+		res.Truncated = true
+		TruncatedCount.WithLabelValues(w.server).Inc()
+	}
+
+	return w.ResponseWriter.WriteMsg(res)
+}
+
+// Write implements the dns.ResponseWriter interface.
+func (w *ResponseWriter) Write(buf []byte) (int, error) {
+	log.Warning("ResponseWriter called with Write: not adding a cookie")
+	n, err := w.ResponseWriter.Write(buf)
+	return n, err
+}
@@ -0,0 +1,185 @@
+package cookie
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/horahoradev/dns"
+)
+
+func init() { plugin.Register("cookie", setup) }
+
+func setup(c *caddy.Controller) error {
+	ck, err := parse(c)
+	if err != nil {
+		return plugin.Error("cookie", err)
+	}
+
+	done := make(chan struct{})
+	c.OnStartup(func() error {
+		if ck.secretFile == "" || ck.reload == 0 {
+			return nil
+		}
+		go func() {
+			ticker := time.NewTicker(ck.reload)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if err := ck.readSecrets(); err != nil {
+						log.Warningf("Failed to reload secrets from %q: %s", ck.secretFile, err)
+					}
+				}
+			}
+		}()
+		return nil
+	})
+	c.OnShutdown(func() error {
+		close(done)
+		return nil
+	})
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		ck.Next = next
+		return ck
+	})
+
+	return nil
+}
+
+func parse(c *caddy.Controller) (*Cookie, error) {
+	ck := New()
+
+	i := 0
+	for c.Next() {
+		if i > 0 {
+			return nil, plugin.ErrOnce
+		}
+		i++
+		if len(c.RemainingArgs()) > 0 {
+			return nil, c.ArgErr()
+		}
+
+		for c.NextBlock() {
+			switch c.Val() {
+			case "secret":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				secrets := make([][]byte, len(args))
+				for i := range args {
+					secret, err := parseSecret(args[i])
+					if err != nil {
+						return nil, err
+					}
+					secrets[i] = secret
+				}
+				ck.setSecrets(secrets)
+			case "secret_file":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				ck.secretFile = c.Val()
+				config := dnsserver.GetConfig(c)
+				if !filepath.IsAbs(ck.secretFile) && config.Root != "" {
+					ck.secretFile = filepath.Join(config.Root, ck.secretFile)
+				}
+			case "reload":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				reload, err := time.ParseDuration(c.Val())
+				if err != nil {
+					return nil, c.Errf("invalid duration for reload '%s'", c.Val())
+				}
+				if reload < 0 {
+					return nil, c.Errf("invalid negative duration for reload '%s'", c.Val())
+				}
+				ck.reload = reload
+			case "enforce":
+				if c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				ck.enforce = true
+			case "require":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				size, err := strconv.Atoi(c.Val())
+				if err != nil {
+					return nil, err
+				}
+				if size < dns.MinMsgSize {
+					return nil, c.Errf("require size must be at least %d: %d", dns.MinMsgSize, size)
+				}
+				ck.require = size
+			default:
+				return nil, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
+	}
+
+	if ck.secrets != nil && ck.secretFile != "" {
+		return nil, c.Err("secret and secret_file are mutually exclusive")
+	}
+	if ck.secretFile != "" {
+		if err := ck.readSecrets(); err != nil {
+			return nil, err
+		}
+	}
+	if ck.secrets == nil {
+		// Without a configured secret, cookies are only valid for this instance until it restarts.
+		secret, err := newSecret()
+		if err != nil {
+			return nil, err
+		}
+		ck.setSecrets([][]byte{secret})
+	}
+	return ck, nil
+}
+
+// parseSecret parses a hex encoded secret.
+func parseSecret(s string) ([]byte, error) {
+	secret, err := hex.DecodeString(s)
+	if err != nil || len(secret) != secretLen {
+		return nil, fmt.Errorf("secret must be %d hex encoded bytes: %q", secretLen, s)
+	}
+	return secret, nil
+}
+
+// parseSecretFile parses one hex encoded secret per line from r, lines starting with # are ignored.
+func parseSecretFile(r io.Reader) ([][]byte, error) {
+	secrets := [][]byte{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		secret, err := parseSecret(line)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(secrets) == 0 {
+		return nil, fmt.Errorf("no secrets found")
+	}
+	return secrets, nil
+}
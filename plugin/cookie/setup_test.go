@@ -0,0 +1,67 @@
+package cookie
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+)
+
+func TestSetup(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secrets")
+	if err := os.WriteFile(file, []byte("# current\ne5e973e5a6b2a43f48e7dc849e37bfcf\n\n000102030405060708090a0b0c0d0e0f\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input           string
+		shouldErr       bool
+		expectedSecrets int
+		expectedEnforce bool
+		expectedRequire int
+		expectedReload  time.Duration
+		expectedErr     string
+	}{
+		{`cookie`, false, 1, false, 0, defaultReload, ""},
+		{"cookie {\nsecret e5e973e5a6b2a43f48e7dc849e37bfcf 000102030405060708090a0b0c0d0e0f\n}", false, 2, false, 0, defaultReload, ""},
+		{"cookie {\nsecret_file " + file + "\nreload 0\n}", false, 2, false, 0, 0, ""},
+		{"cookie {\nenforce\nrequire 512\n}", false, 1, true, 512, defaultReload, ""},
+		// negative
+		{`cookie example.org`, true, 0, false, 0, 0, "Wrong argument count"},
+		{"cookie {\nsecret\n}", true, 0, false, 0, 0, "Wrong argument count"},
+		{"cookie {\nsecret e5e973e5\n}", true, 0, false, 0, 0, "secret must be 16 hex encoded bytes"},
+		{"cookie {\nsecret_file /does/not/exist\n}", true, 0, false, 0, 0, "no such file"},
+		{"cookie {\nsecret e5e973e5a6b2a43f48e7dc849e37bfcf\nsecret_file " + file + "\n}", true, 0, false, 0, 0, "mutually exclusive"},
+		{"cookie {\nreload -1s\n}", true, 0, false, 0, 0, "invalid negative duration"},
+		{"cookie {\nenforce yes\n}", true, 0, false, 0, 0, "Wrong argument count"},
+		{"cookie {\nrequire 100\n}", true, 0, false, 0, 0, "require size must be at least 512"},
+		{"cookie {\nnonce\n}", true, 0, false, 0, 0, "unknown property 'nonce'"},
+		{"cookie\ncookie", true, 0, false, 0, 0, "plugin"},
+	}
+
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		ck, err := parse(c)
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error, got none", i)
+			} else if !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("Test %d: expected error to contain %q, got %q", i, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		if len(ck.secrets) != tc.expectedSecrets {
+			t.Errorf("Test %d: expected %d secrets, got %d", i, tc.expectedSecrets, len(ck.secrets))
+		}
+		if ck.enforce != tc.expectedEnforce || ck.require != tc.expectedRequire || ck.reload != tc.expectedReload {
+			t.Errorf("Test %d: expected enforce %t, require %d and reload %s, got %t, %d and %s", i,
+				tc.expectedEnforce, tc.expectedRequire, tc.expectedReload, ck.enforce, ck.require, ck.reload)
+		}
+	}
+}
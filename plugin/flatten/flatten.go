@@ -0,0 +1,145 @@
+// Package flatten implements a plugin that collapses CNAME chains in responses.
+package flatten
+
+import (
+	"context"
+	"strings"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/plugin/pkg/nonwriter"
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+// UpstreamInt wraps the Upstream API for dependency injection during testing.
+type UpstreamInt interface {
+	Lookup(ctx context.Context, state request.Request, name string, typ uint16) (*dns.Msg, error)
+}
+
+// Flatten replaces the CNAME chain in the answer of A and AAAA responses by the records at the end of the chain,
+// renamed to the name in the question.
+type Flatten struct {
+	Next  plugin.Handler
+	Zones []string
+
+	// Resolve is set to resolve the end of the chain when the response doesn't contain its records.
+	Resolve  bool
+	Upstream UpstreamInt
+}
+
+// ServeDNS implements the plugin.Handler interface.
+func (f *Flatten) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+	if qt := state.QType(); qt != dns.TypeA && qt != dns.TypeAAAA {
+		return plugin.NextOrFailure(f.Name(), f.Next, ctx, w, r)
+	}
+	if plugin.Zones(f.Zones).Matches(state.Name()) == "" {
+		return plugin.NextOrFailure(f.Name(), f.Next, ctx, w, r)
+	}
+
+	nw := nonwriter.New(w)
+	rcode, err := plugin.NextOrFailure(f.Name(), f.Next, ctx, nw, r)
+	if nw.Msg == nil {
+		return rcode, err
+	}
+
+	m, source := f.flatten(ctx, state, nw.Msg)
+	if source != "" {
+		FlattenedCount.WithLabelValues(metrics.WithServer(ctx), source).Inc()
+	}
+	w.WriteMsg(m)
+	return rcode, err
+}
+
+// flatten returns res with its CNAME chain flattened, and where the records at the end of the chain came from:
+// "response" or "upstream". If res isn't flattened, it is returned as is, with an empty source.
+func (f *Flatten) flatten(ctx context.Context, state request.Request, res *dns.Msg) (*dns.Msg, string) {
+	if res.Rcode != dns.RcodeSuccess || len(res.Question) == 0 {
+		return res, ""
+	}
+	qname, qtype := res.Question[0].Name, res.Question[0].Qtype
+
+	target, ttl, rrs, ok := follow(res.Answer, qname, qtype)
+	if !ok || strings.EqualFold(target, qname) {
+		return res, ""
+	}
+	source := "response"
+	if len(rrs) == 0 {
+		// Lookups by the upstream come back through here, don't resolve again for those.
+		if !f.Resolve || ctx.Value(resolvingKey{}) != nil {
+			return res, ""
+		}
+		up, err := f.Upstream.Lookup(context.WithValue(ctx, resolvingKey{}, true), state, target, qtype)
+		if err != nil || up == nil || up.Rcode != dns.RcodeSuccess {
+			return res, ""
+		}
+		var upTTL uint32
+		if _, upTTL, rrs, ok = follow(up.Answer, target, qtype); !ok || len(rrs) == 0 {
+			return res, ""
+		}
+		if upTTL < ttl {
+			ttl = upTTL
+		}
+		source = "upstream"
+	}
+
+	m := res.Copy()
+	m.Answer = make([]dns.RR, len(rrs))
+	for i, rr := range rrs {
+		rr = dns.Copy(rr)
+		rr.Header().Name = qname
+		if rr.Header().Ttl > ttl {
+			rr.Header().Ttl = ttl
+		}
+		m.Answer[i] = rr
+	}
+	// The signatures of the chain don't cover the flattened records.
+	m.AuthenticatedData = false
+	return m, source
+}
+
+// follow follows the CNAME chain in rrs that starts at name. It returns the end of the chain, which is name if there
+// is no chain, the minimum TTL of the CNAME records in it, and the records of type qtype at the end. It returns false
+// if the chain loops.
+func follow(rrs []dns.RR, name string, qtype uint16) (string, uint32, []dns.RR, bool) {
+	target := name
+	ttl := ^uint32(0)
+	seen := map[string]struct{}{}
+	for {
+		key := strings.ToLower(target)
+		if _, ok := seen[key]; ok {
+			return "", 0, nil, false
+		}
+		seen[key] = struct{}{}
+
+		var next *dns.CNAME
+		for _, rr := range rrs {
+			if c, ok := rr.(*dns.CNAME); ok && strings.EqualFold(c.Hdr.Name, target) {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		target = next.Target
+		if next.Hdr.Ttl < ttl {
+			ttl = next.Hdr.Ttl
+		}
+	}
+
+	var end []dns.RR
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == qtype && strings.EqualFold(rr.Header().Name, target) {
+			end = append(end, rr)
+		}
+	}
+	return target, ttl, end, true
+}
+
+// Name implements the Handler interface.
+func (f *Flatten) Name() string { return "flatten" }
+
+type resolvingKey struct{}
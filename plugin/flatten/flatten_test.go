@@ -0,0 +1,178 @@
+package flatten
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+func TestFlatten(t *testing.T) {
+	tests := []struct {
+		qname    string
+		qtype    uint16
+		answer   []dns.RR
+		rcode    int
+		expected []dns.RR
+	}{
+		// a chain of two CNAMEs, the minimum TTL of the chain applies
+		{
+			"www.example.org.", dns.TypeA,
+			[]dns.RR{
+				test.CNAME("www.example.org. 300 IN CNAME cdn.example.net."),
+				test.CNAME("cdn.example.net. 60 IN CNAME edge.example.com."),
+				test.A("edge.example.com. 120 IN A 192.0.2.1"),
+				test.A("edge.example.com. 120 IN A 192.0.2.2"),
+			},
+			dns.RcodeSuccess,
+			[]dns.RR{test.A("www.example.org. 60 IN A 192.0.2.1"), test.A("www.example.org. 60 IN A 192.0.2.2")},
+		},
+		// the records have the lowest TTL
+		{
+			"www.example.org.", dns.TypeAAAA,
+			[]dns.RR{
+				test.CNAME("www.example.org. 300 IN CNAME edge.example.com."),
+				test.AAAA("edge.example.com. 30 IN AAAA 2001:db8::1"),
+			},
+			dns.RcodeSuccess,
+			[]dns.RR{test.AAAA("www.example.org. 30 IN AAAA 2001:db8::1")},
+		},
+		// no chain
+		{
+			"www.example.org.", dns.TypeA,
+			[]dns.RR{test.A("www.example.org. 300 IN A 192.0.2.1")},
+			dns.RcodeSuccess,
+			[]dns.RR{test.A("www.example.org. 300 IN A 192.0.2.1")},
+		},
+		// a loop is left alone
+		{
+			"a.example.org.", dns.TypeA,
+			[]dns.RR{
+				test.CNAME("a.example.org. 300 IN CNAME b.example.org."),
+				test.CNAME("b.example.org. 300 IN CNAME a.example.org."),
+			},
+			dns.RcodeSuccess,
+			[]dns.RR{
+				test.CNAME("a.example.org. 300 IN CNAME b.example.org."),
+				test.CNAME("b.example.org. 300 IN CNAME a.example.org."),
+			},
+		},
+		// a denial is left alone
+		{
+			"www.example.org.", dns.TypeA,
+			[]dns.RR{test.CNAME("www.example.org. 300 IN CNAME gone.example.com.")},
+			dns.RcodeNameError,
+			[]dns.RR{test.CNAME("www.example.org. 300 IN CNAME gone.example.com.")},
+		},
+		// only the CNAME, without resolving it is left alone
+		{
+			"www.example.org.", dns.TypeA,
+			[]dns.RR{test.CNAME("www.example.org. 300 IN CNAME edge.example.com.")},
+			dns.RcodeSuccess,
+			[]dns.RR{test.CNAME("www.example.org. 300 IN CNAME edge.example.com.")},
+		},
+		// other types are left alone
+		{
+			"www.example.org.", dns.TypeMX,
+			[]dns.RR{
+				test.CNAME("www.example.org. 300 IN CNAME edge.example.com."),
+				test.MX("edge.example.com. 300 IN MX 10 mx.example.com."),
+			},
+			dns.RcodeSuccess,
+			[]dns.RR{
+				test.CNAME("www.example.org. 300 IN CNAME edge.example.com."),
+				test.MX("edge.example.com. 300 IN MX 10 mx.example.com."),
+			},
+		},
+	}
+
+	for i, tc := range tests {
+		f := &Flatten{
+			Zones: []string{"."},
+			Next: plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+				m := new(dns.Msg)
+				m.SetRcode(r, tc.rcode)
+				m.Answer = tc.answer
+				w.WriteMsg(m)
+				return tc.rcode, nil
+			}),
+		}
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, tc.qtype)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		f.ServeDNS(context.TODO(), rec, m)
+
+		if err := test.Section(test.Case{Answer: tc.expected}, test.Answer, rec.Msg.Answer); err != nil {
+			t.Errorf("Test %d: %s", i, err)
+		}
+	}
+}
+
+type fakeUpstream struct {
+	answer []dns.RR
+	rcode  int
+	name   string // the name that was looked up
+}
+
+func (u *fakeUpstream) Lookup(_ context.Context, state request.Request, name string, typ uint16) (*dns.Msg, error) {
+	u.name = name
+	m := new(dns.Msg)
+	m.SetQuestion(name, typ)
+	m.Rcode = u.rcode
+	m.Answer = u.answer
+	return m, nil
+}
+
+func TestFlattenResolve(t *testing.T) {
+	tests := []struct {
+		upstream *fakeUpstream
+		expected []dns.RR
+	}{
+		{
+			&fakeUpstream{answer: []dns.RR{
+				test.CNAME("cdn.example.net. 30 IN CNAME edge.example.com."),
+				test.A("edge.example.com. 120 IN A 192.0.2.1"),
+			}},
+			[]dns.RR{test.A("www.example.org. 30 IN A 192.0.2.1")},
+		},
+		{
+			&fakeUpstream{answer: []dns.RR{test.A("cdn.example.net. 600 IN A 192.0.2.1")}},
+			[]dns.RR{test.A("www.example.org. 300 IN A 192.0.2.1")},
+		},
+		{
+			&fakeUpstream{rcode: dns.RcodeServerFailure},
+			[]dns.RR{test.CNAME("www.example.org. 300 IN CNAME cdn.example.net.")},
+		},
+	}
+
+	for i, tc := range tests {
+		f := &Flatten{
+			Zones:    []string{"example.org."},
+			Resolve:  true,
+			Upstream: tc.upstream,
+			Next: plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+				m := new(dns.Msg)
+				m.SetReply(r)
+				m.Answer = []dns.RR{test.CNAME("www.example.org. 300 IN CNAME cdn.example.net.")}
+				w.WriteMsg(m)
+				return dns.RcodeSuccess, nil
+			}),
+		}
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.org.", dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		f.ServeDNS(context.TODO(), rec, m)
+
+		if tc.upstream.name != "cdn.example.net." {
+			t.Errorf("Test %d: expected a lookup of cdn.example.net., got %q", i, tc.upstream.name)
+		}
+		if err := test.Section(test.Case{Answer: tc.expected}, test.Answer, rec.Msg.Answer); err != nil {
+			t.Errorf("Test %d: %s", i, err)
+		}
+	}
+}
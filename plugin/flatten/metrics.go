@@ -0,0 +1,19 @@
+package flatten
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// FlattenedCount is the number of responses with a flattened CNAME chain, by where the records at the end of
+	// the chain came from.
+	FlattenedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "flatten",
+		Name:      "responses_total",
+		Help:      "Counter of responses with a flattened CNAME chain.",
+	}, []string{"server", "source"})
+)
@@ -0,0 +1,45 @@
+package flatten
+
+import (
+	"testing"
+
+	"github.com/coredns/caddy"
+)
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		zones     []string
+		resolve   bool
+	}{
+		{"flatten", false, []string{"example.org."}, false},
+		{"flatten example.com", false, []string{"example.com."}, false},
+		{"flatten {\nresolve\n}", false, []string{"example.org."}, true},
+		// fails
+		{"flatten {\nresolve now\n}", true, nil, false},
+		{"flatten {\nbogus\n}", true, nil, false},
+		{"flatten\nflatten", true, nil, false},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		c.ServerBlockKeys = []string{"example.org"}
+		f, err := parse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %d: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %d: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr {
+			continue
+		}
+		if len(f.Zones) != len(test.zones) || f.Zones[0] != test.zones[0] {
+			t.Errorf("Test %d: Expected zones %v, got %v", i, test.zones, f.Zones)
+		}
+		if f.Resolve != test.resolve {
+			t.Errorf("Test %d: Expected resolve %t, got %t", i, test.resolve, f.Resolve)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package forward
+
+import (
+	"crypto/rand"
+	"strings"
+
+	"github.com/horahoradev/dns"
+)
+
+// randomizeCase returns a copy of r with the case of the letters in the query name randomized (DNS 0x20,
+// draft-vixie-dnsext-dns0x20). The random bits come from crypto/rand, as they must not be predictable to
+// be of any use against spoofing. The second return value is the randomized name, which is empty when the
+// name has no letters and r is returned unchanged.
+func randomizeCase(r *dns.Msg) (*dns.Msg, string) {
+	if len(r.Question) != 1 {
+		return r, ""
+	}
+	name := []byte(r.Question[0].Name)
+
+	bits := make([]byte, (len(name)+7)/8)
+	if _, err := rand.Read(bits); err != nil {
+		return r, ""
+	}
+	letters := false
+	for i, c := range name {
+		if !isLetter(c) {
+			continue
+		}
+		letters = true
+		if bits[i/8]&(1<<(i%8)) != 0 {
+			name[i] = c ^ 0x20
+		}
+	}
+	if !letters {
+		return r, ""
+	}
+
+	// A shallow copy is enough, Connect only changes the ID.
+	m := new(dns.Msg)
+	*m = *r
+	m.Question = []dns.Question{r.Question[0]}
+	m.Question[0].Name = string(name)
+	return m, m.Question[0].Name
+}
+
+// restoreCase sets the query name and the owner names that are equal to it in ret to qname, so the client gets
+// back the case it used.
+func restoreCase(ret *dns.Msg, qname string) {
+	for i := range ret.Question {
+		if strings.EqualFold(ret.Question[i].Name, qname) {
+			ret.Question[i].Name = qname
+		}
+	}
+	for _, section := range [][]dns.RR{ret.Answer, ret.Ns, ret.Extra} {
+		for _, rr := range section {
+			if strings.EqualFold(rr.Header().Name, qname) {
+				rr.Header().Name = qname
+			}
+		}
+	}
+}
+
+// caseMatch returns true if ret echoed the (randomized) qname exactly.
+func caseMatch(ret *dns.Msg, qname string) bool {
+	return len(ret.Question) == 1 && ret.Question[0].Name == qname
+}
+
+func isLetter(c byte) bool { return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') }
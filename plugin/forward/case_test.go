@@ -0,0 +1,154 @@
+package forward
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/horahoradev/dns"
+)
+
+func TestRandomizeCase(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("www.Example.org.", dns.TypeA)
+
+	randomized := false
+	for i := 0; i < 10; i++ {
+		r, qname := randomizeCase(m)
+		if m.Question[0].Name != "www.Example.org." {
+			t.Fatalf("Expected the original message to be unchanged, got %s", m.Question[0].Name)
+		}
+		if !strings.EqualFold(qname, "www.example.org.") || r.Question[0].Name != qname {
+			t.Fatalf("Expected a randomized www.example.org., got %s", qname)
+		}
+		if qname != "www.Example.org." {
+			randomized = true
+		}
+	}
+	if !randomized {
+		t.Errorf("Expected the case to be randomized at least once")
+	}
+
+	m.SetQuestion("1.2.3.4.", dns.TypeA)
+	if r, qname := randomizeCase(m); r != m || qname != "" {
+		t.Errorf("Expected a name without letters not to be randomized, got %s", qname)
+	}
+}
+
+func TestRestoreCase(t *testing.T) {
+	ret := new(dns.Msg)
+	ret.SetQuestion("wWw.eXamPle.ORG.", dns.TypeA)
+	ret.Answer = []dns.RR{
+		test.CNAME("wWw.eXamPle.ORG. 5 IN CNAME web.example.org."),
+		test.A("web.example.org. 5 IN A 127.0.0.1"),
+	}
+
+	restoreCase(ret, "www.Example.org.")
+	if ret.Question[0].Name != "www.Example.org." {
+		t.Errorf("Expected question to be restored, got %s", ret.Question[0].Name)
+	}
+	if ret.Answer[0].Header().Name != "www.Example.org." {
+		t.Errorf("Expected owner name to be restored, got %s", ret.Answer[0].Header().Name)
+	}
+	if ret.Answer[1].Header().Name != "web.example.org." {
+		t.Errorf("Expected other owner names to be unchanged, got %s", ret.Answer[1].Header().Name)
+	}
+}
+
+func TestSetupRandomizeCase(t *testing.T) {
+	tests := []struct {
+		input       string
+		shouldErr   bool
+		except      int
+		expectedErr string
+	}{
+		{"forward . 127.0.0.1 {\nrandomize_case\n}\n", false, 0, ""},
+		{"forward . 127.0.0.1 {\nrandomize_case except 10.0.0.1 192.168.0.0/16\n}\n", false, 2, ""},
+		// negative
+		{"forward . 127.0.0.1 {\nrandomize_case except\n}\n", true, 0, "Wrong argument count"},
+		{"forward . 127.0.0.1 {\nrandomize_case 10.0.0.1\n}\n", true, 0, "Wrong argument count"},
+		{"forward . 127.0.0.1 {\nrandomize_case except example.org\n}\n", true, 0, "not an IP address or CIDR"},
+	}
+
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		fs, err := parseForward(c)
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error, got none", i)
+			} else if !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("Test %d: expected error to contain %q, got %q", i, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		if !fs[0].randomizeCase || len(fs[0].caseExcept) != tc.except {
+			t.Errorf("Test %d: expected randomize_case with %d exceptions, got %t with %d", i, tc.except, fs[0].randomizeCase, len(fs[0].caseExcept))
+		}
+	}
+}
+
+func TestRandomizeCaseMismatch(t *testing.T) {
+	var udp, tcp uint32
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		// Over UDP reply like an upstream that doesn't preserve case (or a spoofer that doesn't know it).
+		if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+			atomic.AddUint32(&udp, 1)
+			ret.Question[0].Name = strings.ToLower(ret.Question[0].Name)
+		} else {
+			atomic.AddUint32(&tcp, 1)
+		}
+		ret.Answer = append(ret.Answer, test.A(ret.Question[0].Name+" 5 IN A 127.0.0.1"))
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	tests := []struct {
+		input string
+		udp   uint32
+		tcp   uint32
+		qname string
+	}{
+		{"forward . " + s.Addr + " {\nrandomize_case\n}\n", 1, 1, "www.Example.org."},
+		// excluded upstreams get the client's query as-is
+		{"forward . " + s.Addr + " {\nrandomize_case except 0.0.0.0/0 ::/0\n}\n", 1, 0, "www.example.org."},
+	}
+
+	for i, tc := range tests {
+		atomic.StoreUint32(&udp, 0)
+		atomic.StoreUint32(&tcp, 0)
+
+		c := caddy.NewTestController("dns", tc.input)
+		fs, err := parseForward(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := fs[0]
+		f.OnStartup()
+
+		m := new(dns.Msg)
+		m.SetQuestion("www.Example.org.", dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		f.OnShutdown()
+
+		if u, c := atomic.LoadUint32(&udp), atomic.LoadUint32(&tcp); u != tc.udp || c != tc.tcp {
+			t.Errorf("Test %d: expected %d UDP and %d TCP queries, got %d and %d", i, tc.udp, tc.tcp, u, c)
+		}
+		if rec.Msg.Question[0].Name != tc.qname || rec.Msg.Answer[0].Header().Name != tc.qname {
+			t.Errorf("Test %d: expected query name %s, got %s", i, tc.qname, rec.Msg.Question[0].Name)
+		}
+	}
+}
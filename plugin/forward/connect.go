@@ -85,6 +85,15 @@ func (p *Proxy) Connect(ctx context.Context, state request.Request, opts options
 		proto = state.Proto()
 	}
 
+	if p.transport.pipelined(proto) {
+		ret, err := p.transport.exchange(proto, state.Req)
+		if err != nil {
+			return nil, err
+		}
+		p.observe(ret, start)
+		return ret, nil
+	}
+
 	pc, cached, err := p.transport.Dial(proto)
 	if err != nil {
 		return nil, err
@@ -137,6 +146,12 @@ func (p *Proxy) Connect(ctx context.Context, state request.Request, opts options
 
 	p.transport.Yield(pc)
 
+	p.observe(ret, start)
+	return ret, nil
+}
+
+// observe updates the metrics for the reply ret to a query that was sent at start.
+func (p *Proxy) observe(ret *dns.Msg, start time.Time) {
 	rc, ok := dns.RcodeToString[ret.Rcode]
 	if !ok {
 		rc = strconv.Itoa(ret.Rcode)
@@ -145,8 +160,6 @@ func (p *Proxy) Connect(ctx context.Context, state request.Request, opts options
 	RequestCount.WithLabelValues(p.addr).Add(1)
 	RcodeCount.WithLabelValues(rc, p.addr).Add(1)
 	RequestDuration.WithLabelValues(p.addr, rc).Observe(time.Since(start).Seconds())
-
-	return ret, nil
 }
 
 const cumulativeAvgWeight = 4
@@ -0,0 +1,112 @@
+package forward
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+
+	"github.com/horahoradev/dns"
+)
+
+// clientCookie is the DNS Cookie (RFC 7873) state of a proxy. Each proxy uses its own random client cookie,
+// so an upstream can't correlate queries sent to other upstreams.
+type clientCookie struct {
+	client string // hex encoded client cookie
+
+	sync.RWMutex
+	server string // hex encoded server cookie learned from the upstream, empty if none
+}
+
+func newClientCookie() *clientCookie {
+	b := make([]byte, clientCookieLen)
+	if _, err := rand.Read(b); err != nil {
+		return nil
+	}
+	return &clientCookie{client: hex.EncodeToString(b)}
+}
+
+// request returns a copy of r with our cookie, replacing any cookie the client sent.
+func (c *clientCookie) request(r *dns.Msg, size int) *dns.Msg {
+	c.RLock()
+	cookie := c.client + c.server
+	c.RUnlock()
+
+	m := r.Copy()
+	removeCookieOption(m)
+	o := m.IsEdns0()
+	if o == nil {
+		m.SetEdns0(uint16(size), false)
+		o = m.IsEdns0()
+	}
+	o.Option = append(o.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie})
+	return m
+}
+
+// reply checks the cookie in the reply ret and learns the server cookie from it. It returns false if ret has a
+// cookie that doesn't echo our client cookie, such a reply may be spoofed.
+func (c *clientCookie) reply(ret *dns.Msg) bool {
+	opt := cookieOption(ret)
+	if opt == nil {
+		return true
+	}
+	// Client cookie and server cookie (8 to 32 bytes), hex encoded.
+	if len(opt.Cookie) < 2*(clientCookieLen+8) || len(opt.Cookie) > 2*(clientCookieLen+32) {
+		return false
+	}
+	if opt.Cookie[:2*clientCookieLen] != c.client {
+		return false
+	}
+	if _, err := hex.DecodeString(opt.Cookie); err != nil {
+		return false
+	}
+
+	c.Lock()
+	c.server = opt.Cookie[2*clientCookieLen:]
+	c.Unlock()
+	return true
+}
+
+// stripCookie removes our cookie from the reply ret to the client's request, and the OPT RR when the client
+// didn't use EDNS.
+func stripCookie(req, ret *dns.Msg) {
+	if req.IsEdns0() != nil {
+		removeCookieOption(ret)
+		return
+	}
+	for i := len(ret.Extra) - 1; i >= 0; i-- {
+		if ret.Extra[i].Header().Rrtype == dns.TypeOPT {
+			ret.Extra = append(ret.Extra[:i], ret.Extra[i+1:]...)
+		}
+	}
+}
+
+// cookieOption returns the cookie option in m, or nil if there is none.
+func cookieOption(m *dns.Msg) *dns.EDNS0_COOKIE {
+	o := m.IsEdns0()
+	if o == nil {
+		return nil
+	}
+	for _, s := range o.Option {
+		if e, ok := s.(*dns.EDNS0_COOKIE); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// removeCookieOption removes all cookie options from m.
+func removeCookieOption(m *dns.Msg) {
+	o := m.IsEdns0()
+	if o == nil {
+		return
+	}
+	options := o.Option[:0]
+	for _, s := range o.Option {
+		if _, ok := s.(*dns.EDNS0_COOKIE); !ok {
+			options = append(options, s)
+		}
+	}
+	o.Option = options
+}
+
+const clientCookieLen = 8
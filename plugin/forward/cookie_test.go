@@ -0,0 +1,183 @@
+package forward
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/horahoradev/dns"
+)
+
+func TestSetupCookie(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		expected  bool
+	}{
+		{"forward . 127.0.0.1\n", false, false},
+		{"forward . 127.0.0.1 {\ncookie\n}\n", false, true},
+		// negative
+		{"forward . 127.0.0.1 {\ncookie yes\n}\n", true, false},
+	}
+
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		fs, err := parseForward(c)
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error, got none", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		f := fs[0]
+		if f.cookie != tc.expected || (f.proxies[0].cookie != nil) != tc.expected {
+			t.Errorf("Test %d: expected cookie to be %t", i, tc.expected)
+		}
+	}
+}
+
+func TestClientCookie(t *testing.T) {
+	c := newClientCookie()
+
+	r := new(dns.Msg)
+	r.SetQuestion("example.org.", dns.TypeA)
+	m := c.request(r, 1232)
+	if r.IsEdns0() != nil {
+		t.Errorf("Expected the client's request to be unchanged")
+	}
+	if opt := cookieOption(m); opt == nil || opt.Cookie != c.client {
+		t.Fatalf("Expected client cookie %s, got %v", c.client, opt)
+	}
+
+	tests := []struct {
+		cookie string // cookie in the reply, empty if none
+		valid  bool
+		server string // server cookie after the reply
+	}{
+		{"", true, ""},
+		{c.client + "0102030405060708", true, "0102030405060708"},
+		{"0001020304050607" + "0102030405060708", false, "0102030405060708"},
+		{c.client, false, "0102030405060708"},
+		{c.client + "zz02030405060708", false, "0102030405060708"},
+		{c.client + "01000000aabbccdd1122334455667788", true, "01000000aabbccdd1122334455667788"},
+	}
+
+	for i, tc := range tests {
+		ret := new(dns.Msg)
+		ret.SetReply(m)
+		if tc.cookie != "" {
+			ret.SetEdns0(1232, false)
+			ret.IsEdns0().Option = append(ret.IsEdns0().Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: tc.cookie})
+		}
+		if valid := c.reply(ret); valid != tc.valid {
+			t.Errorf("Test %d: expected valid to be %t, got %t", i, tc.valid, valid)
+		}
+		if c.server != tc.server {
+			t.Errorf("Test %d: expected server cookie %q, got %q", i, tc.server, c.server)
+		}
+	}
+
+	// The client's cookie is replaced by ours.
+	r.SetEdns0(4096, false)
+	r.IsEdns0().Option = append(r.IsEdns0().Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0001020304050607"})
+	m = c.request(r, 1232)
+	if opt := cookieOption(m); opt == nil || opt.Cookie != c.client+c.server {
+		t.Errorf("Expected cookie %s, got %v", c.client+c.server, opt)
+	}
+	if opt := cookieOption(r); opt.Cookie != "0001020304050607" {
+		t.Errorf("Expected the client's cookie to be unchanged, got %s", opt.Cookie)
+	}
+}
+
+func TestCookieUpstream(t *testing.T) {
+	const serverCookie = "0102030405060708"
+	var mode atomic.Value
+	var udp, tcp uint32
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+			atomic.AddUint32(&udp, 1)
+		} else {
+			atomic.AddUint32(&tcp, 1)
+		}
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		ret.SetEdns0(1232, false)
+		cookie := cookieOption(r).Cookie
+		cc, sc := cookie[:2*clientCookieLen], cookie[2*clientCookieLen:]
+
+		switch mode.Load().(string) {
+		case "badcookie":
+			if sc != serverCookie {
+				ret.Rcode = dns.RcodeBadCookie
+			}
+		case "spoof":
+			if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+				cc = "0001020304050607"
+			}
+		}
+		ret.IsEdns0().Option = append(ret.IsEdns0().Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cc + serverCookie})
+		if ret.Rcode == dns.RcodeSuccess {
+			ret.Answer = append(ret.Answer, test.A("example.org. 5 IN A 127.0.0.1"))
+		}
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	tests := []struct {
+		mode     string
+		edns     bool
+		udp, tcp uint32
+		rcode    int
+	}{
+		{"echo", false, 1, 0, dns.RcodeSuccess},
+		{"echo", true, 1, 0, dns.RcodeSuccess},
+		{"badcookie", true, 2, 0, dns.RcodeSuccess},
+		{"spoof", true, 1, 1, dns.RcodeSuccess},
+	}
+
+	for i, tc := range tests {
+		mode.Store(tc.mode)
+		atomic.StoreUint32(&udp, 0)
+		atomic.StoreUint32(&tcp, 0)
+
+		c := caddy.NewTestController("dns", "forward . "+s.Addr+" {\ncookie\n}\n")
+		fs, err := parseForward(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := fs[0]
+		f.OnStartup()
+
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeA)
+		if tc.edns {
+			m.SetEdns0(4096, false)
+		}
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		f.OnShutdown()
+
+		if u, c := atomic.LoadUint32(&udp), atomic.LoadUint32(&tcp); u != tc.udp || c != tc.tcp {
+			t.Errorf("Test %d: expected %d UDP and %d TCP queries, got %d and %d", i, tc.udp, tc.tcp, u, c)
+		}
+		if rec.Msg.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %d, got %d", i, tc.rcode, rec.Msg.Rcode)
+		}
+		if opt := cookieOption(rec.Msg); opt != nil {
+			t.Errorf("Test %d: expected our cookie to be stripped, got %s", i, opt.Cookie)
+		}
+		if !tc.edns && rec.Msg.IsEdns0() != nil {
+			t.Errorf("Test %d: expected no OPT RR in the reply to a non-EDNS request", i)
+		}
+	}
+}
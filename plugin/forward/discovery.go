@@ -0,0 +1,339 @@
+package forward
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/parse"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+
+	"github.com/horahoradev/dns"
+)
+
+// dynamicName is an upstream that is specified by name. It is either a host name that resolves to
+// A and AAAA records or, when the first label starts with an underscore, an SRV name.
+type dynamicName struct {
+	name  string // fully qualified name to resolve
+	trans string
+	port  string // port to use for host names, SRV names carry their own port
+	srv   bool
+}
+
+// parseDynamicName parses s as an upstream specified by name. Only fully qualified names, i.e. names with
+// a trailing dot, are considered, so they can't be confused with resolv.conf like files.
+func parseDynamicName(s string) (dynamicName, bool) {
+	trans, host := parse.Transport(s)
+
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		name = host
+		port = ""
+	}
+	if name == "." || !dns.IsFqdn(name) || net.ParseIP(name) != nil {
+		return dynamicName{}, false
+	}
+	if _, ok := dns.IsDomainName(name); !ok {
+		return dynamicName{}, false
+	}
+
+	if port == "" {
+		port = transport.Port
+		if trans == transport.TLS {
+			port = transport.TLSPort
+		}
+	}
+
+	return dynamicName{name: strings.ToLower(name), trans: trans, port: port, srv: strings.HasPrefix(name, "_")}, true
+}
+
+// upstreamAddr is a resolved upstream.
+type upstreamAddr struct {
+	addr  string
+	trans string
+}
+
+func (u upstreamAddr) key() string { return u.trans + "://" + u.addr }
+
+// discovery periodically resolves the upstreams specified by name and updates the proxy list of the forwarder
+// in place. Proxies of addresses that disappear are removed from the list and stopped after they had the time
+// to finish their in-flight queries.
+type discovery struct {
+	names      []dynamicName
+	resolvers  []string
+	minRefresh time.Duration
+	maxRefresh time.Duration
+
+	c       *dns.Client
+	static  []*Proxy                  // proxies that are configured as an address, these are always kept
+	current map[string]*Proxy         // currently used dynamic proxies, keyed by upstreamAddr.key()
+	addrs   map[string][]upstreamAddr // addresses of the last successful resolution of each name
+
+	stopOnce sync.Once
+	done     chan struct{}
+	wg       sync.WaitGroup // tracks the refresh loop
+}
+
+func newDiscovery() *discovery {
+	c := new(dns.Client)
+	c.Net = "udp"
+	c.ReadTimeout = hcReadTimeout
+	c.WriteTimeout = hcWriteTimeout
+
+	return &discovery{
+		minRefresh: defaultMinRefresh,
+		maxRefresh: defaultMaxRefresh,
+		c:          c,
+		current:    make(map[string]*Proxy),
+		addrs:      make(map[string][]upstreamAddr),
+		done:       make(chan struct{}),
+	}
+}
+
+// start performs the initial resolution and starts the refresh loop.
+func (d *discovery) start(f *Forward) {
+	d.static = f.Proxies()
+	wait := d.refresh(f)
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.run(f, wait)
+	}()
+}
+
+// stop stops the refresh loop and waits for a refresh in progress to finish, so no proxies are started after
+// it returns. It is safe to call it more than once.
+func (d *discovery) stop() {
+	d.stopOnce.Do(func() { close(d.done) })
+	d.wg.Wait()
+}
+
+func (d *discovery) run(f *Forward, wait time.Duration) {
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-time.After(wait):
+			wait = d.refresh(f)
+		}
+	}
+}
+
+// refresh resolves all names and updates the proxies of f. It returns the duration to wait until the next refresh.
+func (d *discovery) refresh(f *Forward) time.Duration {
+	var (
+		addrs  []upstreamAddr
+		ttl    uint32
+		failed bool
+	)
+	for _, dn := range d.names {
+		as, t, err := d.resolve(dn)
+		if err != nil {
+			// Don't drop the upstreams of a name when we can't resolve it, keep what we have and retry soon.
+			log.Warningf("Failed to resolve upstream %q: %s", dn.name, err)
+			DiscoveryFailureCount.WithLabelValues(dn.name).Add(1)
+			failed = true
+			addrs = append(addrs, d.addrs[dn.name]...)
+			continue
+		}
+		DiscoveredUpstreams.WithLabelValues(dn.name).Set(float64(len(as)))
+		d.addrs[dn.name] = as
+		addrs = append(addrs, as...)
+		if ttl == 0 || (t > 0 && t < ttl) {
+			ttl = t
+		}
+	}
+
+	select {
+	case <-d.done:
+		return 0
+	default:
+	}
+	d.update(f, addrs)
+
+	if failed {
+		return d.minRefresh
+	}
+	wait := time.Duration(ttl) * time.Second
+	if wait < d.minRefresh {
+		wait = d.minRefresh
+	}
+	if wait > d.maxRefresh {
+		wait = d.maxRefresh
+	}
+	return wait
+}
+
+// update replaces the dynamic proxies of f with proxies for addrs, existing proxies for the same address are kept.
+func (d *discovery) update(f *Forward, addrs []upstreamAddr) {
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].key() < addrs[j].key() })
+
+	proxies := make([]*Proxy, len(d.static), len(d.static)+len(addrs))
+	copy(proxies, d.static)
+	current := make(map[string]*Proxy)
+	for _, a := range addrs {
+		k := a.key()
+		if _, ok := current[k]; ok {
+			continue
+		}
+		if len(proxies) >= max {
+			log.Warningf("More than %d upstreams discovered, ignoring %s", max, k)
+			continue
+		}
+		p, ok := d.current[k]
+		if !ok {
+			p = NewProxy(a.addr, a.trans)
+			f.configureProxy(p, a.trans)
+			p.start(f.hcInterval)
+		}
+		current[k] = p
+		proxies = append(proxies, p)
+	}
+
+	var gone []*Proxy
+	for k, p := range d.current {
+		if _, ok := current[k]; !ok {
+			gone = append(gone, p)
+		}
+	}
+	d.current = current
+	f.setProxies(proxies)
+
+	if len(gone) > 0 {
+		time.AfterFunc(drainTimeout, func() { drain(gone) })
+	}
+}
+
+// drain stops the proxies that are no longer used. It is called drainTimeout after they were removed, queries
+// still in flight by then fail.
+func drain(proxies []*Proxy) {
+	for _, p := range proxies {
+		p.stop()
+		runtime.SetFinalizer(p, nil)
+		p.transport.Stop()
+	}
+}
+
+// resolve returns the upstream addresses for dn and the smallest TTL seen.
+func (d *discovery) resolve(dn dynamicName) ([]upstreamAddr, uint32, error) {
+	if !dn.srv {
+		ips, ttl, err := d.lookupHost(dn.name, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		addrs := make([]upstreamAddr, len(ips))
+		for i, ip := range ips {
+			addrs[i] = upstreamAddr{addr: net.JoinHostPort(ip, dn.port), trans: dn.trans}
+		}
+		return addrs, ttl, nil
+	}
+
+	m, err := d.lookup(dn.name, dns.TypeSRV)
+	if err != nil {
+		return nil, 0, err
+	}
+	ttl := minTTL(m.Answer)
+	addrs := []upstreamAddr{}
+	for _, rr := range m.Answer {
+		srv, ok := rr.(*dns.SRV)
+		if !ok || srv.Target == "." {
+			continue
+		}
+		ips, t, err := d.lookupHost(srv.Target, m.Extra)
+		if err != nil {
+			return nil, 0, err
+		}
+		if t > 0 && (ttl == 0 || t < ttl) {
+			ttl = t
+		}
+		port := strconv.Itoa(int(srv.Port))
+		for _, ip := range ips {
+			addrs = append(addrs, upstreamAddr{addr: net.JoinHostPort(ip, port), trans: dn.trans})
+		}
+	}
+	return addrs, ttl, nil
+}
+
+// lookupHost returns the addresses of name. If extra (i.e. the additional section of an SRV reply) contains
+// addresses for name these are used, otherwise name is resolved.
+func (d *discovery) lookupHost(name string, extra []dns.RR) ([]string, uint32, error) {
+	var rrs []dns.RR
+	for _, rr := range extra {
+		if strings.EqualFold(rr.Header().Name, name) {
+			rrs = append(rrs, rr)
+		}
+	}
+	if len(rrs) == 0 {
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			m, err := d.lookup(name, qtype)
+			if err != nil {
+				return nil, 0, err
+			}
+			rrs = append(rrs, m.Answer...)
+		}
+	}
+
+	ips := []string{}
+	for _, rr := range rrs {
+		switch x := rr.(type) {
+		case *dns.A:
+			ips = append(ips, x.A.String())
+		case *dns.AAAA:
+			ips = append(ips, x.AAAA.String())
+		}
+	}
+	return ips, minTTL(rrs), nil
+}
+
+// lookup sends a query for name and qtype to the resolvers until one of them answers. A name error is not an error,
+// the returned message then has an empty answer section.
+func (d *discovery) lookup(name string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+
+	var err error
+	for _, r := range d.resolvers {
+		var ret *dns.Msg
+		ret, _, err = d.c.Exchange(m, r)
+		if err != nil {
+			continue
+		}
+		if ret.Rcode != dns.RcodeSuccess && ret.Rcode != dns.RcodeNameError {
+			err = fmt.Errorf("resolver %s returned %s", r, dns.RcodeToString[ret.Rcode])
+			continue
+		}
+		return ret, nil
+	}
+	if err == nil {
+		err = errNoResolvers
+	}
+	return nil, err
+}
+
+// minTTL returns the smallest TTL of rrs, or 0 if rrs is empty.
+func minTTL(rrs []dns.RR) uint32 {
+	ttl := uint32(0)
+	for _, rr := range rrs {
+		if ttl == 0 || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	return ttl
+}
+
+var errNoResolvers = errors.New("no resolvers configured")
+
+const (
+	defaultMinRefresh = 5 * time.Second
+	defaultMaxRefresh = 5 * time.Minute
+)
+
+// drainTimeout is the grace period after which a removed proxy is stopped. It doesn't track the queries in
+// flight, but no query takes longer than defaultTimeout.
+var drainTimeout = defaultTimeout
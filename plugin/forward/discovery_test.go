@@ -0,0 +1,226 @@
+package forward
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/horahoradev/dns"
+)
+
+func TestParseDynamicName(t *testing.T) {
+	tests := []struct {
+		input    string
+		dynamic  bool
+		expected dynamicName
+	}{
+		{"127.0.0.1", false, dynamicName{}},
+		{"/etc/resolv.conf", false, dynamicName{}},
+		{"resolver.example.org", false, dynamicName{}},
+		{"resolver.example.org.", true, dynamicName{name: "resolver.example.org.", trans: "dns", port: "53"}},
+		{"Resolver.Example.org.:5353", true, dynamicName{name: "resolver.example.org.", trans: "dns", port: "5353"}},
+		{"tls://dot.example.org.", true, dynamicName{name: "dot.example.org.", trans: "tls", port: "853"}},
+		{"_dns._udp.example.org.", true, dynamicName{name: "_dns._udp.example.org.", trans: "dns", port: "53", srv: true}},
+	}
+
+	for i, tc := range tests {
+		dn, ok := parseDynamicName(tc.input)
+		if ok != tc.dynamic {
+			t.Errorf("Test %d: expected dynamic to be %t for %q", i, tc.dynamic, tc.input)
+			continue
+		}
+		if dn != tc.expected {
+			t.Errorf("Test %d: expected %+v, got %+v", i, tc.expected, dn)
+		}
+	}
+}
+
+func TestSetupDiscovery(t *testing.T) {
+	tests := []struct {
+		input       string
+		shouldErr   bool
+		proxies     int
+		names       int
+		minRefresh  time.Duration
+		maxRefresh  time.Duration
+		resolvers   []string
+		expectedErr string
+	}{
+		{"forward . resolver.example.org. {\ndiscovery_resolver 10.0.0.1\n}\n", false, 0, 1, defaultMinRefresh, defaultMaxRefresh, []string{"10.0.0.1:53"}, ""},
+		{"forward . 127.0.0.1 _dns._udp.example.org. {\ndiscovery_resolver 10.0.0.1:5353\ndiscovery_refresh 10s\n}\n", false, 1, 1, 10 * time.Second, defaultMaxRefresh, []string{"10.0.0.1:5353"}, ""},
+		{"forward . a.example.org. b.example.org. {\ndiscovery_resolver 10.0.0.1\ndiscovery_refresh 1s 1m\n}\n", false, 0, 2, time.Second, time.Minute, []string{"10.0.0.1:53"}, ""},
+		// negative
+		{"forward . 127.0.0.1 {\ndiscovery_refresh 10s\n}\n", true, 0, 0, 0, 0, nil, "requires an upstream specified by name"},
+		{"forward . a.example.org. {\ndiscovery_refresh 1m 1s\n}\n", true, 0, 0, 0, 0, nil, "is smaller than minimum"},
+		{"forward . a.example.org. {\ndiscovery_refresh 0s\n}\n", true, 0, 0, 0, 0, nil, "must be positive"},
+		{"forward . a.example.org. {\ndiscovery_resolver tls://10.0.0.1\n}\n", true, 0, 0, 0, 0, nil, "only plain DNS resolvers"},
+		{"forward . https://a.example.org.\n", true, 0, 0, 0, 0, nil, "'https' is not supported"},
+	}
+
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		fs, err := parseForward(c)
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error, got none", i)
+			} else if !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("Test %d: expected error to contain %q, got %q", i, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+
+		f := fs[0]
+		if f.Len() != tc.proxies {
+			t.Errorf("Test %d: expected %d static proxies, got %d", i, tc.proxies, f.Len())
+		}
+		d := f.discovery
+		if len(d.names) != tc.names {
+			t.Errorf("Test %d: expected %d names, got %d", i, tc.names, len(d.names))
+		}
+		if d.minRefresh != tc.minRefresh || d.maxRefresh != tc.maxRefresh {
+			t.Errorf("Test %d: expected refresh %s-%s, got %s-%s", i, tc.minRefresh, tc.maxRefresh, d.minRefresh, d.maxRefresh)
+		}
+		if strings.Join(d.resolvers, ",") != strings.Join(tc.resolvers, ",") {
+			t.Errorf("Test %d: expected resolvers %v, got %v", i, tc.resolvers, d.resolvers)
+		}
+	}
+}
+
+func TestDiscoveryRefresh(t *testing.T) {
+	var mu sync.Mutex
+	addrs := []string{"10.0.0.1", "10.0.0.2"}
+	failSRV := false
+
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Question[0].Qtype {
+		case dns.TypeA:
+			if r.Question[0].Name == "resolver.example.org." {
+				for _, a := range addrs {
+					ret.Answer = append(ret.Answer, test.A("resolver.example.org. 30 IN A "+a))
+				}
+			}
+		case dns.TypeSRV:
+			if failSRV {
+				ret.Rcode = dns.RcodeServerFailure
+				break
+			}
+			ret.Answer = append(ret.Answer, test.SRV("_dns._udp.example.org. 60 IN SRV 10 10 5353 ns.example.org."))
+			ret.Extra = append(ret.Extra, test.AAAA("ns.example.org. 10 IN AAAA ::1"))
+		}
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	c := caddy.NewTestController("dns", "forward . 127.0.0.1 resolver.example.org. _dns._udp.example.org. {\ndiscovery_resolver "+s.Addr+"\ndiscovery_refresh 1s 1m\n}\n")
+	fs, err := parseForward(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := fs[0]
+	defer f.OnShutdown()
+	d := f.discovery
+	d.static = f.Proxies()
+
+	wait := d.refresh(f)
+	if wait != 10*time.Second {
+		t.Errorf("Expected refresh after the smallest TTL of %s, got %s", 10*time.Second, wait)
+	}
+
+	expected := []string{"127.0.0.1:53", "10.0.0.1:53", "10.0.0.2:53", "[::1]:5353"}
+	if got := proxyAddrs(f); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected proxies %v, got %v", expected, got)
+	}
+	kept := f.Proxies()[1]
+
+	mu.Lock()
+	addrs = []string{"10.0.0.1"}
+	mu.Unlock()
+
+	d.refresh(f)
+	expected = []string{"127.0.0.1:53", "10.0.0.1:53", "[::1]:5353"}
+	if got := proxyAddrs(f); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected proxies %v, got %v", expected, got)
+	}
+	if f.Proxies()[1] != kept {
+		t.Errorf("Expected existing proxy for 10.0.0.1:53 to be kept")
+	}
+
+	// A name that fails to resolve keeps its proxies, the others are updated.
+	mu.Lock()
+	addrs = []string{"10.0.0.2"}
+	failSRV = true
+	mu.Unlock()
+
+	if wait := d.refresh(f); wait != d.minRefresh {
+		t.Errorf("Expected refresh after %s on failure, got %s", d.minRefresh, wait)
+	}
+	expected = []string{"127.0.0.1:53", "10.0.0.2:53", "[::1]:5353"}
+	if got := proxyAddrs(f); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected proxies %v, got %v", expected, got)
+	}
+
+	// Resolution failures keep the current proxies.
+	d.resolvers = []string{"127.0.0.1:1"}
+	if wait := d.refresh(f); wait != d.minRefresh {
+		t.Errorf("Expected refresh after %s on failure, got %s", d.minRefresh, wait)
+	}
+	if got := proxyAddrs(f); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected proxies %v, got %v", expected, got)
+	}
+}
+
+func TestDiscoveryStop(t *testing.T) {
+	// Every refresh discovers a new address, and is slow enough to be in progress on shutdown.
+	var n int32
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		if r.Question[0].Qtype == dns.TypeA {
+			i := atomic.AddInt32(&n, 1)
+			ret.Answer = append(ret.Answer, test.A(fmt.Sprintf("resolver.example.org. 1 IN A 10.0.0.%d", i%250+1)))
+			time.Sleep(20 * time.Millisecond)
+		}
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	c := caddy.NewTestController("dns", "forward . resolver.example.org. {\ndiscovery_resolver "+s.Addr+"\ndiscovery_refresh 10ms 10ms\n}\n")
+	fs, err := parseForward(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := fs[0]
+	f.OnStartup()
+	time.Sleep(50 * time.Millisecond)
+
+	f.OnShutdown()
+	// The refresh loop has exited, so the proxies can't change anymore.
+	proxies := proxyAddrs(f)
+	time.Sleep(100 * time.Millisecond)
+	if got := proxyAddrs(f); strings.Join(got, ",") != strings.Join(proxies, ",") {
+		t.Errorf("Expected the proxies %v not to change after shutdown, got %v", proxies, got)
+	}
+	f.discovery.stop()
+}
+
+func proxyAddrs(f *Forward) []string {
+	addrs := []string{}
+	for _, p := range f.Proxies() {
+		addrs = append(addrs, p.addr)
+	}
+	return addrs
+}
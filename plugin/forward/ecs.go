@@ -0,0 +1,211 @@
+package forward
+
+import (
+	"net"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+// ECS (EDNS Client Subnet, RFC 7871) modes.
+const (
+	ecsPass  = "pass"  // send the client's ECS option as-is
+	ecsStrip = "strip" // remove any ECS option
+	ecsAdd   = "add"   // send an ECS option derived from the client
+)
+
+// ecsPolicy determines the ECS option that is sent to the upstreams.
+type ecsPolicy struct {
+	mode string
+	v4   uint8 // source prefix length for IPv4 in ecsAdd mode
+	v6   uint8 // source prefix length for IPv6 in ecsAdd mode
+
+	allow []*net.IPNet // upstreams that may receive an ECS option, nil means all
+}
+
+func newECSPolicy() *ecsPolicy {
+	return &ecsPolicy{mode: ecsPass, v4: defaultECSv4, v6: defaultECSv6}
+}
+
+// allowed returns true if p may receive an ECS option.
+func (e *ecsPolicy) allowed(p *Proxy) bool {
+	if e.allow == nil {
+		return true
+	}
+	return proxyIn(p, e.allow)
+}
+
+// request returns the request to send to p and the ECS option in that request. The original request is never
+// modified, if changes are needed a copy is returned.
+func (e *ecsPolicy) request(state request.Request, p *Proxy) (*dns.Msg, *dns.EDNS0_SUBNET) {
+	client := ecsOption(state.Req)
+
+	mode := e.mode
+	if !e.allowed(p) {
+		mode = ecsStrip
+	}
+
+	switch mode {
+	case ecsPass:
+		return state.Req, client
+	case ecsStrip:
+		if client == nil {
+			return state.Req, nil
+		}
+		r := state.Req.Copy()
+		removeECSOption(r)
+		return r, nil
+	}
+
+	ecs := e.subnet(state, client)
+	r := state.Req.Copy()
+	removeECSOption(r)
+	if ecs == nil {
+		return r, nil
+	}
+	o := r.IsEdns0()
+	if o == nil {
+		r.SetEdns0(uint16(state.Size()), false)
+		o = r.IsEdns0()
+	}
+	o.Option = append(o.Option, ecs)
+	return r, ecs
+}
+
+// subnet returns the ECS option to add for this request. If the client sent an ECS option its address is used,
+// but the source prefix length is never longer than configured. Otherwise the client's address is used.
+func (e *ecsPolicy) subnet(state request.Request, client *dns.EDNS0_SUBNET) *dns.EDNS0_SUBNET {
+	ip := net.ParseIP(state.IP())
+	v4, v6 := e.v4, e.v6
+	if client != nil {
+		ip = client.Address
+		if client.SourceNetmask < v4 {
+			v4 = client.SourceNetmask
+		}
+		if client.SourceNetmask < v6 {
+			v6 = client.SourceNetmask
+		}
+	}
+	if ip == nil {
+		return nil
+	}
+
+	ecs := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET}
+	if ip4 := ip.To4(); ip4 != nil {
+		ecs.Family = 1
+		ecs.SourceNetmask = v4
+		ecs.Address = ip4.Mask(net.CIDRMask(int(v4), net.IPv4len*8))
+		return ecs
+	}
+	ecs.Family = 2
+	ecs.SourceNetmask = v6
+	ecs.Address = ip.To16().Mask(net.CIDRMask(int(v6), net.IPv6len*8))
+	return ecs
+}
+
+// reply fixes up the ECS option in the reply ret from upstream to, when the request sent upstream differs from the
+// client's request. The client gets its own ECS option back with the scope prefix length returned by the upstream,
+// limited to the source prefix length that was sent. If the upstream's ECS option doesn't match what was sent, it
+// is ignored and the reply is treated as valid for all clients.
+//
+// A client that didn't send an ECS option must not get one, but a reply that only applies to its subnet keeps the
+// option that was sent, with the scope, so the cache can tell. The server removes it, and the OPT RR of a client
+// that didn't use EDNS, before the reply is written to the client.
+func (e *ecsPolicy) reply(state request.Request, ret *dns.Msg, sent *dns.EDNS0_SUBNET, to string) {
+	client := ecsOption(state.Req)
+	if sent == client {
+		return
+	}
+
+	got := ecsOption(ret)
+	removeECSOption(ret)
+
+	scope := uint8(0)
+	if got != nil {
+		if sent != nil && ecsMatch(sent, got) {
+			scope = got.SourceScope
+			if scope > sent.SourceNetmask {
+				scope = sent.SourceNetmask
+			}
+		} else {
+			ECSMismatchCount.WithLabelValues(to).Add(1)
+		}
+	}
+
+	ecs := client
+	if ecs == nil && scope > 0 {
+		ecs = sent
+	}
+	if ecs == nil {
+		if state.Req.IsEdns0() == nil {
+			// The client didn't use EDNS, so we added the OPT RR and need to remove it again.
+			for i := len(ret.Extra) - 1; i >= 0; i-- {
+				if ret.Extra[i].Header().Rrtype == dns.TypeOPT {
+					ret.Extra = append(ret.Extra[:i], ret.Extra[i+1:]...)
+				}
+			}
+		}
+		return
+	}
+
+	o := ret.IsEdns0()
+	if o == nil {
+		return
+	}
+	o.Option = append(o.Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        ecs.Family,
+		SourceNetmask: ecs.SourceNetmask,
+		SourceScope:   scope,
+		Address:       ecs.Address,
+	})
+}
+
+// ecsMatch returns true if the family, source prefix length and address of a and b are equal.
+func ecsMatch(a, b *dns.EDNS0_SUBNET) bool {
+	if a.Family != b.Family || a.SourceNetmask != b.SourceNetmask {
+		return false
+	}
+	bits := net.IPv4len * 8
+	if a.Family == 2 {
+		bits = net.IPv6len * 8
+	}
+	mask := net.CIDRMask(int(a.SourceNetmask), bits)
+	return a.Address.Mask(mask).Equal(b.Address.Mask(mask))
+}
+
+// ecsOption returns the ECS option in m, or nil if there is none.
+func ecsOption(m *dns.Msg) *dns.EDNS0_SUBNET {
+	o := m.IsEdns0()
+	if o == nil {
+		return nil
+	}
+	for _, s := range o.Option {
+		if e, ok := s.(*dns.EDNS0_SUBNET); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// removeECSOption removes all ECS options from m.
+func removeECSOption(m *dns.Msg) {
+	o := m.IsEdns0()
+	if o == nil {
+		return
+	}
+	options := o.Option[:0]
+	for _, s := range o.Option {
+		if _, ok := s.(*dns.EDNS0_SUBNET); !ok {
+			options = append(options, s)
+		}
+	}
+	o.Option = options
+}
+
+// Default source prefix lengths as recommended in RFC 7871, section 11.1.
+const (
+	defaultECSv4 = 24
+	defaultECSv6 = 56
+)
@@ -0,0 +1,232 @@
+package forward
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+func TestSetupECS(t *testing.T) {
+	tests := []struct {
+		input       string
+		shouldErr   bool
+		mode        string
+		v4, v6      uint8
+		allow       int
+		expectedErr string
+	}{
+		{"forward . 127.0.0.1 {\necs strip\n}\n", false, ecsStrip, defaultECSv4, defaultECSv6, 0, ""},
+		{"forward . 127.0.0.1 {\necs add\n}\n", false, ecsAdd, defaultECSv4, defaultECSv6, 0, ""},
+		{"forward . 127.0.0.1 {\necs add 20\n}\n", false, ecsAdd, 20, defaultECSv6, 0, ""},
+		{"forward . 127.0.0.1 {\necs add 16 48\n}\n", false, ecsAdd, 16, 48, 0, ""},
+		{"forward . 127.0.0.1 {\necs_allow 127.0.0.1 10.0.0.0/8 ::1\n}\n", false, ecsPass, defaultECSv4, defaultECSv6, 3, ""},
+		// negative
+		{"forward . 127.0.0.1 {\necs\n}\n", true, "", 0, 0, 0, "Wrong argument count"},
+		{"forward . 127.0.0.1 {\necs strip 24\n}\n", true, "", 0, 0, 0, "Wrong argument count"},
+		{"forward . 127.0.0.1 {\necs replace\n}\n", true, "", 0, 0, 0, "unknown ecs mode 'replace'"},
+		{"forward . 127.0.0.1 {\necs add 33\n}\n", true, "", 0, 0, 0, "invalid source prefix length 33"},
+		{"forward . 127.0.0.1 {\necs add 24 129\n}\n", true, "", 0, 0, 0, "invalid source prefix length 129"},
+		{"forward . 127.0.0.1 {\necs_allow example.org\n}\n", true, "", 0, 0, 0, "not an IP address or CIDR"},
+	}
+
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		fs, err := parseForward(c)
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error, got none", i)
+			} else if !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("Test %d: expected error to contain %q, got %q", i, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		e := fs[0].ecs
+		if e.mode != tc.mode || e.v4 != tc.v4 || e.v6 != tc.v6 || len(e.allow) != tc.allow {
+			t.Errorf("Test %d: expected %s %d %d with %d allowed, got %s %d %d with %d allowed", i, tc.mode, tc.v4, tc.v6, tc.allow, e.mode, e.v4, e.v6, len(e.allow))
+		}
+	}
+}
+
+func TestECSRequest(t *testing.T) {
+	withECS := func(addr string, source uint8) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeA)
+		m.SetEdns0(4096, false)
+		ecs := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: source, Address: net.ParseIP(addr).To4()}
+		m.IsEdns0().Option = append(m.IsEdns0().Option, ecs)
+		return m
+	}
+	plain := new(dns.Msg)
+	plain.SetQuestion("example.org.", dns.TypeA)
+
+	allowed := NewProxy("10.0.0.1:53", "dns")
+	denied := NewProxy("192.168.0.1:53", "dns")
+	_, allow, _ := net.ParseCIDR("10.0.0.0/8")
+
+	tests := []struct {
+		policy   *ecsPolicy
+		req      *dns.Msg
+		v6       bool // client connects over IPv6
+		proxy    *Proxy
+		expected string // expected ECS option sent, empty if none
+	}{
+		{&ecsPolicy{mode: ecsPass}, withECS("192.0.2.0", 24), false, allowed, "192.0.2.0/24/0"},
+		{&ecsPolicy{mode: ecsStrip}, withECS("192.0.2.0", 24), false, allowed, ""},
+		{&ecsPolicy{mode: ecsStrip}, plain, false, allowed, ""},
+		{&ecsPolicy{mode: ecsAdd, v4: 24, v6: 56}, plain, false, allowed, "10.240.0.0/24/0"},
+		{&ecsPolicy{mode: ecsAdd, v4: 24, v6: 56}, plain, true, allowed, "[fe80::]/56/0"},
+		{&ecsPolicy{mode: ecsAdd, v4: 24, v6: 56}, withECS("192.0.2.129", 32), false, allowed, "192.0.2.0/24/0"},
+		{&ecsPolicy{mode: ecsAdd, v4: 24, v6: 56}, withECS("192.0.2.129", 16), false, allowed, "192.0.0.0/16/0"},
+		{&ecsPolicy{mode: ecsAdd, v4: 24, v6: 56, allow: []*net.IPNet{allow}}, plain, false, denied, ""},
+		{&ecsPolicy{mode: ecsPass, allow: []*net.IPNet{allow}}, withECS("192.0.2.0", 24), false, denied, ""},
+		{&ecsPolicy{mode: ecsPass, allow: []*net.IPNet{allow}}, withECS("192.0.2.0", 24), false, allowed, "192.0.2.0/24/0"},
+	}
+
+	for i, tc := range tests {
+		state := request.Request{W: &test.ResponseWriter{}, Req: tc.req}
+		if tc.v6 {
+			state.W = &test.ResponseWriter6{}
+		}
+		before := tc.req.String()
+
+		r, ecs := tc.policy.request(state, tc.proxy)
+		if tc.req.String() != before {
+			t.Errorf("Test %d: expected the client's request to be unchanged", i)
+		}
+		if ecsOption(r) != ecs {
+			t.Errorf("Test %d: expected the returned option to be the one in the request", i)
+		}
+		got := ""
+		if ecs != nil {
+			got = ecs.String()
+		}
+		if got != tc.expected {
+			t.Errorf("Test %d: expected ECS %q, got %q", i, tc.expected, got)
+		}
+	}
+}
+
+func TestECSReply(t *testing.T) {
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		if o := r.IsEdns0(); o != nil {
+			ret.SetEdns0(o.UDPSize(), false)
+			if ecs := ecsOption(r); ecs != nil {
+				e := *ecs
+				e.SourceScope = 28
+				ret.IsEdns0().Option = append(ret.IsEdns0().Option, &e)
+			}
+		}
+		ret.Answer = append(ret.Answer, test.A("example.org. 5 IN A 127.0.0.1"))
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	tests := []struct {
+		ecs      string
+		edns     bool
+		clientIP string
+		expected string // ECS option returned to the client, empty if none
+	}{
+		{"add 24", false, "", ""},
+		{"add 24", true, "", ""},
+		{"add 24", true, "192.0.2.129", "192.0.2.129/32/24"},
+		{"add 32", true, "192.0.2.129", "192.0.2.129/32/28"},
+		{"strip", true, "192.0.2.129", "192.0.2.129/32/0"},
+		{"pass", true, "192.0.2.129", "192.0.2.129/32/28"},
+	}
+
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", "forward . "+s.Addr+" {\necs "+tc.ecs+"\n}\n")
+		fs, err := parseForward(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := fs[0]
+		f.OnStartup()
+
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeA)
+		if tc.edns {
+			m.SetEdns0(4096, false)
+			if tc.clientIP != "" {
+				ecs := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 32, Address: net.ParseIP(tc.clientIP).To4()}
+				m.IsEdns0().Option = append(m.IsEdns0().Option, ecs)
+			}
+		}
+
+		// The server scrubs the reply, and removes what forward kept for the cache.
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := f.ServeDNS(context.TODO(), request.NewScrubWriter(m, rec), m); err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		f.OnShutdown()
+
+		if !tc.edns && rec.Msg.IsEdns0() != nil {
+			t.Errorf("Test %d: expected no OPT RR in the reply to a non-EDNS request", i)
+		}
+		got := ""
+		if ecs := ecsOption(rec.Msg); ecs != nil {
+			got = ecs.String()
+		}
+		if got != tc.expected {
+			t.Errorf("Test %d: expected ECS %q, got %q", i, tc.expected, got)
+		}
+	}
+}
+
+func TestECSReplyScope(t *testing.T) {
+	e := newECSPolicy()
+	e.mode = ecsAdd
+
+	tests := []struct {
+		edns     bool
+		scope    uint8
+		expected string // ECS option kept in the reply for the cache, empty if none
+	}{
+		{true, 24, "10.240.0.0/24/24"},
+		{false, 24, "10.240.0.0/24/24"},
+		{true, 0, ""},
+		{false, 0, ""},
+	}
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeA)
+		if tc.edns {
+			m.SetEdns0(4096, false)
+		}
+		state := request.Request{W: &test.ResponseWriter{}, Req: m}
+		req, sent := e.request(state, nil)
+
+		ret := new(dns.Msg)
+		ret.SetReply(req)
+		ret.SetEdns0(4096, false)
+		got := *sent
+		got.SourceScope = tc.scope
+		ret.IsEdns0().Option = append(ret.IsEdns0().Option, &got)
+
+		e.reply(state, ret, sent, "upstream")
+		kept := ""
+		if ecs := ecsOption(ret); ecs != nil {
+			kept = ecs.String()
+		}
+		if kept != tc.expected {
+			t.Errorf("Test %d: expected ECS %q, got %q", i, tc.expected, kept)
+		}
+		if tc.expected == "" && !tc.edns && ret.IsEdns0() != nil {
+			t.Errorf("Test %d: expected the added OPT RR to be removed", i)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package forward
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/horahoradev/dns"
+)
+
+// EDNS compliance levels of an upstream, from fully compliant to not supporting EDNS at all.
+const (
+	ednsFull        = iota // queries are sent as-is
+	ednsNoOptions          // EDNS options are removed
+	ednsSmallBuffer        // EDNS options are removed and the buffer size is lowered to 512 bytes
+	ednsNone               // the OPT RR is removed
+)
+
+var ednsLevelNames = [...]string{"full", "no_options", "small_buffer", "none"}
+
+// ednsCompliance remembers how much EDNS an upstream can handle (RFC 6891, section 6.2.2 and 7). When an upstream
+// returns FORMERR or NOTIMP for a query with EDNS, or doesn't reply to it at all, queries are retried with less
+// EDNS. A lower level is only remembered once the upstream replied to a query with it, it is then used for ttl,
+// after that the upstream is given another chance.
+type ednsCompliance struct {
+	addr string
+	ttl  time.Duration
+
+	sync.Mutex
+	level   int
+	until   time.Time
+	replied bool // the last query sent to the upstream got a reply
+}
+
+func newEDNSCompliance(addr string, ttl time.Duration) *ednsCompliance {
+	return &ednsCompliance{addr: addr, ttl: ttl}
+}
+
+// get returns the current compliance level.
+func (e *ednsCompliance) get() int {
+	e.Lock()
+	defer e.Unlock()
+	if e.level != ednsFull && time.Now().After(e.until) {
+		e.level = ednsFull
+		EDNSLevel.WithLabelValues(e.addr).Set(ednsFull)
+	}
+	return e.level
+}
+
+// degrade returns the level to retry the query sent with and true, if it failed because of err or the reply ret
+// in a way that hints at an upstream that can't handle its EDNS. A timeout only counts when the previous query
+// got a reply, an upstream that is down doesn't reply at any level.
+func (e *ednsCompliance) degrade(sent, ret *dns.Msg, err error, udp bool) (int, bool) {
+	e.Lock()
+	replied := e.replied
+	e.replied = err == nil
+	e.Unlock()
+
+	o := sent.IsEdns0()
+	if o == nil {
+		return ednsNone, false
+	}
+
+	timeout := false
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		// Only UDP queries with EDNS are dropped by broken middle boxes.
+		timeout = udp && replied
+	}
+	switch {
+	case timeout:
+	case err == nil && (ret.Rcode == dns.RcodeFormatError || ret.Rcode == dns.RcodeNotImplemented) && ret.IsEdns0() == nil:
+	default:
+		return ednsFull, false
+	}
+
+	level := ednsNone
+	switch {
+	case len(o.Option) > 0:
+		level = ednsNoOptions
+	case timeout && o.UDPSize() > dns.MinMsgSize:
+		level = ednsSmallBuffer
+	}
+	EDNSFallbackCount.WithLabelValues(e.addr, ednsLevelNames[level]).Add(1)
+	return level, true
+}
+
+// reply remembers level for ttl, if it is lower than the current one, after a query sent with it got the reply
+// ret. A FORMERR or NOTIMP reply doesn't show that the upstream can handle the level.
+func (e *ednsCompliance) reply(ret *dns.Msg, level int) {
+	if ret.Rcode == dns.RcodeFormatError || ret.Rcode == dns.RcodeNotImplemented {
+		return
+	}
+	e.Lock()
+	defer e.Unlock()
+	if level > e.level {
+		e.level = level
+		e.until = time.Now().Add(e.ttl)
+		EDNSLevel.WithLabelValues(e.addr).Set(float64(level))
+	}
+}
+
+// ednsRequest returns r adjusted to compliance level. The original request is never modified, if changes are
+// needed a copy is returned.
+func ednsRequest(r *dns.Msg, level int) *dns.Msg {
+	o := r.IsEdns0()
+	if o == nil || level == ednsFull {
+		return r
+	}
+
+	m := r.Copy()
+	switch level {
+	case ednsNoOptions:
+		m.IsEdns0().Option = nil
+	case ednsSmallBuffer:
+		m.IsEdns0().Option = nil
+		m.IsEdns0().SetUDPSize(dns.MinMsgSize)
+	case ednsNone:
+		extra := m.Extra[:0]
+		for _, rr := range m.Extra {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				extra = append(extra, rr)
+			}
+		}
+		m.Extra = extra
+	}
+	return m
+}
+
+// ednsReply adds an OPT RR to the reply ret if the client's request r has one, but the query sent upstream didn't.
+func ednsReply(r, ret *dns.Msg) {
+	o := r.IsEdns0()
+	if o == nil || ret.IsEdns0() != nil {
+		return
+	}
+	ret.SetEdns0(o.UDPSize(), o.Do())
+}
+
+const defaultEDNSFallbackTTL = 10 * time.Minute
@@ -0,0 +1,242 @@
+package forward
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/horahoradev/dns"
+)
+
+func TestSetupEDNSFallback(t *testing.T) {
+	tests := []struct {
+		input       string
+		shouldErr   bool
+		expected    time.Duration
+		expectedErr string
+	}{
+		{"forward . 127.0.0.1\n", false, 0, ""},
+		{"forward . 127.0.0.1 {\nedns_fallback\n}\n", false, defaultEDNSFallbackTTL, ""},
+		{"forward . 127.0.0.1 {\nedns_fallback 1h\n}\n", false, time.Hour, ""},
+		// negative
+		{"forward . 127.0.0.1 {\nedns_fallback 0s\n}\n", true, 0, "can't be zero or negative"},
+		{"forward . 127.0.0.1 {\nedns_fallback 1h 2h\n}\n", true, 0, "Wrong argument count"},
+		{"forward . 127.0.0.1 {\nedns_fallback soon\n}\n", true, 0, "invalid duration"},
+	}
+
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		fs, err := parseForward(c)
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error, got none", i)
+			} else if !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("Test %d: expected error to contain %q, got %q", i, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		f := fs[0]
+		if f.ednsFallback != tc.expected || (f.proxies[0].edns != nil) != (tc.expected > 0) {
+			t.Errorf("Test %d: expected EDNS fallback %s, got %s", i, tc.expected, f.ednsFallback)
+		}
+	}
+}
+
+func TestEDNSRequest(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	m.SetEdns0(4096, true)
+	m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+
+	tests := []struct {
+		level   int
+		edns    bool
+		options int
+		size    uint16
+	}{
+		{ednsFull, true, 1, 4096},
+		{ednsNoOptions, true, 0, 4096},
+		{ednsSmallBuffer, true, 0, dns.MinMsgSize},
+		{ednsNone, false, 0, 0},
+	}
+
+	for i, tc := range tests {
+		r := ednsRequest(m, tc.level)
+		if len(m.IsEdns0().Option) != 1 || m.IsEdns0().UDPSize() != 4096 {
+			t.Fatalf("Test %d: expected the client's request to be unchanged", i)
+		}
+		o := r.IsEdns0()
+		if (o != nil) != tc.edns {
+			t.Fatalf("Test %d: expected EDNS to be %t", i, tc.edns)
+		}
+		if o == nil {
+			continue
+		}
+		if len(o.Option) != tc.options || o.UDPSize() != tc.size || !o.Do() {
+			t.Errorf("Test %d: expected %d options and size %d with DO, got %d options and size %d", i, tc.options, tc.size, len(o.Option), o.UDPSize())
+		}
+	}
+}
+
+func TestEDNSFallback(t *testing.T) {
+	defer func(old time.Duration) { readTimeout = old }(readTimeout)
+	readTimeout = 100 * time.Millisecond
+
+	var (
+		mode    atomic.Value
+		queries uint32
+	)
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		atomic.AddUint32(&queries, 1)
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		o := r.IsEdns0()
+		switch mode.Load().(string) {
+		case "formerr": // doesn't support EDNS
+			if o != nil {
+				ret.Rcode = dns.RcodeFormatError
+				w.WriteMsg(ret)
+				return
+			}
+		case "drop": // drops queries with EDNS options
+			if o != nil && len(o.Option) > 0 {
+				return
+			}
+		}
+		if o != nil {
+			ret.SetEdns0(o.UDPSize(), false)
+		}
+		ret.Answer = append(ret.Answer, test.A("example.org. 5 IN A 127.0.0.1"))
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	tests := []struct {
+		mode    string
+		level   int
+		warm    bool   // send a query without EDNS options first, timeouts only count after a reply
+		queries uint32 // queries sent upstream for two client queries
+	}{
+		{"ok", ednsFull, false, 2},
+		{"formerr", ednsNone, false, 4},
+		{"drop", ednsNoOptions, true, 4},
+	}
+
+	for i, tc := range tests {
+		mode.Store(tc.mode)
+		atomic.StoreUint32(&queries, 0)
+
+		c := caddy.NewTestController("dns", "forward . "+s.Addr+" {\nedns_fallback\n}\n")
+		fs, err := parseForward(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := fs[0]
+		f.OnStartup()
+
+		if tc.warm {
+			m := new(dns.Msg)
+			m.SetQuestion("example.org.", dns.TypeA)
+			m.SetEdns0(4096, false)
+			if _, err := f.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), m); err != nil {
+				t.Fatalf("Test %d: expected no error, got %s", i, err)
+			}
+		}
+		for j := 0; j < 2; j++ {
+			m := new(dns.Msg)
+			m.SetQuestion("example.org.", dns.TypeA)
+			m.SetEdns0(4096, false)
+			m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+				t.Fatalf("Test %d: expected no error, got %s", i, err)
+			}
+			if rec.Msg.Rcode != dns.RcodeSuccess || len(rec.Msg.Answer) != 1 {
+				t.Errorf("Test %d: expected an answer, got %s", i, rec.Msg)
+			}
+			if rec.Msg.IsEdns0() == nil {
+				t.Errorf("Test %d: expected an OPT RR in the reply", i)
+			}
+		}
+		f.OnShutdown()
+
+		if level := f.proxies[0].edns.get(); level != tc.level {
+			t.Errorf("Test %d: expected level %d, got %d", i, tc.level, level)
+		}
+		if q := atomic.LoadUint32(&queries); q != tc.queries {
+			t.Errorf("Test %d: expected %d queries upstream, got %d", i, tc.queries, q)
+		}
+	}
+}
+
+func TestEDNSComplianceExpire(t *testing.T) {
+	e := newEDNSCompliance("127.0.0.1:53", time.Hour)
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	m.SetEdns0(4096, false)
+	ret := new(dns.Msg)
+	ret.SetRcode(m, dns.RcodeFormatError)
+
+	if level, retry := e.degrade(m, ret, nil, true); level != ednsNone || !retry {
+		t.Errorf("Expected to retry with level %d, got %d", ednsNone, level)
+	}
+	if level := e.get(); level != ednsFull {
+		t.Errorf("Expected level %d before a reply, got %d", ednsFull, level)
+	}
+	ret.Rcode = dns.RcodeSuccess
+	e.reply(ret, ednsNone)
+	if level := e.get(); level != ednsNone {
+		t.Errorf("Expected level %d after a reply, got %d", ednsNone, level)
+	}
+	e.until = time.Now().Add(-time.Second)
+	if level := e.get(); level != ednsFull {
+		t.Errorf("Expected level %d after expiry, got %d", ednsFull, level)
+	}
+
+	ret.Rcode = dns.RcodeServerFailure
+	if _, retry := e.degrade(m, ret, nil, true); retry {
+		t.Errorf("Expected no retry for SERVFAIL")
+	}
+}
+
+func TestEDNSComplianceTimeout(t *testing.T) {
+	e := newEDNSCompliance("127.0.0.1:53", time.Hour)
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	m.SetEdns0(4096, false)
+	ret := new(dns.Msg)
+	ret.SetReply(m)
+	timeout := &net.DNSError{IsTimeout: true}
+
+	if _, retry := e.degrade(m, nil, timeout, true); retry {
+		t.Errorf("Expected no retry for a timeout before any reply")
+	}
+	if _, retry := e.degrade(m, ret, nil, true); retry {
+		t.Errorf("Expected no retry for a reply")
+	}
+	if _, retry := e.degrade(m, nil, timeout, false); retry {
+		t.Errorf("Expected no retry for a TCP timeout")
+	}
+
+	e.degrade(m, ret, nil, true)
+	level, retry := e.degrade(m, nil, timeout, true)
+	if level != ednsSmallBuffer || !retry {
+		t.Errorf("Expected to retry with level %d after a reply, got %d", ednsSmallBuffer, level)
+	}
+	if _, retry := e.degrade(ednsRequest(m, level), nil, timeout, true); retry {
+		t.Errorf("Expected no retry for a second timeout")
+	}
+	if level := e.get(); level != ednsFull {
+		t.Errorf("Expected level %d without a reply at a lower level, got %d", ednsFull, level)
+	}
+}
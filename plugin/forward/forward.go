@@ -8,6 +8,8 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -31,9 +33,22 @@ type Forward struct {
 	concurrent int64 // atomic counters need to be first in struct for proper alignment
 
 	proxies    []*Proxy
+	proxiesMu  sync.RWMutex // protects proxies, which can be updated by discovery
 	p          Policy
 	hcInterval time.Duration
 
+	discovery *discovery // dynamic upstreams, nil if none are configured
+	table     *table     // conditional forwarding table, nil if none is configured
+	ecs       *ecsPolicy // EDNS Client Subnet handling, nil means pass through
+
+	randomizeCase bool         // randomize the case of the query name (DNS 0x20)
+	caseExcept    []*net.IPNet // upstreams that don't preserve the case of the query name
+
+	cookie      bool // send DNS Cookies to the upstreams
+	maxInflight int  // queries in flight per shared TCP or TLS connection, 0 disables pipelining
+
+	ednsFallback time.Duration // how long a lower EDNS compliance level of an upstream is remembered, 0 disables fallback
+
 	from    string
 	ignored []string
 
@@ -62,12 +77,37 @@ func New() *Forward {
 
 // SetProxy appends p to the proxy list and starts healthchecking.
 func (f *Forward) SetProxy(p *Proxy) {
+	f.proxiesMu.Lock()
 	f.proxies = append(f.proxies, p)
+	f.proxiesMu.Unlock()
 	p.start(f.hcInterval)
 }
 
+// setProxies replaces the proxy list with proxies.
+func (f *Forward) setProxies(proxies []*Proxy) {
+	f.proxiesMu.Lock()
+	f.proxies = proxies
+	f.proxiesMu.Unlock()
+}
+
+// Proxies returns the currently configured proxies.
+func (f *Forward) Proxies() []*Proxy {
+	f.proxiesMu.RLock()
+	defer f.proxiesMu.RUnlock()
+	return f.proxies
+}
+
+// staticProxies returns the proxies of the upstreams configured as an address. Unlike the proxies found by
+// discovery, these are never stopped while the forwarder runs.
+func (f *Forward) staticProxies() []*Proxy {
+	if f.discovery != nil {
+		return f.discovery.static
+	}
+	return f.Proxies()
+}
+
 // Len returns the number of configured proxies.
-func (f *Forward) Len() int { return len(f.proxies) }
+func (f *Forward) Len() int { return len(f.Proxies()) }
 
 // Name implements plugin.Handler.
 func (f *Forward) Name() string { return "forward" }
@@ -94,6 +134,17 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 	span = ot.SpanFromContext(ctx)
 	i := 0
 	list := f.List()
+	if f.table != nil {
+		if proxies, ok := f.table.lookup(state.Name()); ok {
+			if len(proxies) == 0 {
+				return plugin.NextOrFailure(f.Name(), f.Next, ctx, w, r)
+			}
+			list = f.p.List(proxies)
+		}
+	}
+	if len(list) == 0 {
+		return dns.RcodeServerFailure, ErrNoHealthy
+	}
 	deadline := time.Now().Add(defaultTimeout)
 	start := time.Now()
 	for time.Now().Before(deadline) {
@@ -107,13 +158,13 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 		i++
 		if proxy.Down(f.maxfails) {
 			fails++
-			if fails < len(f.proxies) {
+			if fails < len(list) {
 				continue
 			}
 			// All upstream proxies are dead, assume healthcheck is completely broken and randomly
 			// select an upstream to connect to.
 			r := new(random)
-			proxy = r.List(f.proxies)[0]
+			proxy = r.List(list)[0]
 
 			HealthcheckBrokenCount.Add(1)
 		}
@@ -133,8 +184,29 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 			err error
 		)
 		opts := f.opts
+		req, ecs := state, (*dns.EDNS0_SUBNET)(nil)
+		if f.ecs != nil {
+			req.Req, ecs = f.ecs.request(state, proxy)
+		}
+		qname := ""
+		if f.randomizeCase && !proxyIn(proxy, f.caseExcept) {
+			req.Req, qname = randomizeCase(req.Req)
+		}
+		base, badCookie := req.Req, false
+		level := ednsFull
+		if proxy.edns != nil {
+			level = proxy.edns.get()
+		}
+		build := func() *dns.Msg {
+			m := base
+			if proxy.cookie != nil {
+				m = proxy.cookie.request(m, state.Size())
+			}
+			return ednsRequest(m, level)
+		}
+		req.Req = build()
 		for {
-			ret, err = proxy.Connect(ctx, state, opts)
+			ret, err = proxy.Connect(ctx, req, opts)
 			if err == ErrCachedClosed { // Remote side closed conn, can only happen with TCP.
 				continue
 			}
@@ -143,6 +215,48 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 				opts.forceTCP = true
 				continue
 			}
+			// A reply that doesn't echo the randomized query name may be spoofed, drop it and retry with TCP.
+			// Over TCP spoofing isn't a concern, so the reply is used: the upstream doesn't preserve the case.
+			if err == nil && qname != "" && !caseMatch(ret, qname) {
+				CaseMismatchCount.WithLabelValues(proxy.addr).Add(1)
+				if !opts.forceTCP {
+					opts.forceTCP = true
+					continue
+				}
+				log.Debugf("Upstream %s doesn't preserve the case of the query name, consider listing it in randomize_case except", proxy.addr)
+			}
+			if err == nil && proxy.cookie != nil {
+				// A reply that doesn't echo our client cookie may be spoofed, drop it and retry with TCP.
+				if !proxy.cookie.reply(ret) {
+					CookieMismatchCount.WithLabelValues(proxy.addr).Add(1)
+					if !opts.forceTCP {
+						opts.forceTCP = true
+						continue
+					}
+				} else if ret.Rcode == dns.RcodeBadCookie && !opts.forceTCP {
+					BadCookieCount.WithLabelValues(proxy.addr).Add(1)
+					// Retry once with the server cookie we just learned, then fall back to TCP.
+					if !badCookie {
+						badCookie = true
+						req.Req = build()
+						continue
+					}
+					opts.forceTCP = true
+					continue
+				}
+			}
+			// Retry with less EDNS if the upstream choked on it, and remember the level once it replies.
+			if proxy.edns != nil {
+				udp := !opts.forceTCP && (opts.preferUDP || state.Proto() == "udp")
+				if l, retry := proxy.edns.degrade(req.Req, ret, err, udp); retry && l > level && time.Now().Before(deadline) {
+					level = l
+					req.Req = build()
+					continue
+				}
+				if err == nil {
+					proxy.edns.reply(ret, level)
+				}
+			}
 			break
 		}
 
@@ -162,7 +276,7 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 				proxy.Healthcheck()
 			}
 
-			if fails < len(f.proxies) {
+			if fails < len(list) {
 				continue
 			}
 			break
@@ -178,6 +292,19 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 			return 0, nil
 		}
 
+		if level != ednsFull {
+			ednsReply(state.Req, ret)
+		}
+		if f.ecs != nil {
+			f.ecs.reply(state, ret, ecs, proxy.addr)
+		}
+		if qname != "" {
+			restoreCase(ret, state.Req.Question[0].Name)
+		}
+		if proxy.cookie != nil {
+			stripCookie(state.Req, ret)
+		}
+
 		w.WriteMsg(ret)
 		return 0, nil
 	}
@@ -217,7 +344,13 @@ func (f *Forward) ForceTCP() bool { return f.opts.forceTCP }
 func (f *Forward) PreferUDP() bool { return f.opts.preferUDP }
 
 // List returns a set of proxies to be used for this client depending on the policy in f.
-func (f *Forward) List() []*Proxy { return f.p.List(f.proxies) }
+func (f *Forward) List() []*Proxy {
+	proxies := f.Proxies()
+	if len(proxies) == 0 {
+		return nil
+	}
+	return f.p.List(proxies)
+}
 
 var (
 	// ErrNoHealthy means no healthy proxies left.
@@ -234,6 +367,10 @@ type options struct {
 	preferUDP          bool
 	hcRecursionDesired bool
 	hcDomain           string
+	hcType             uint16
+	hcRcode            int
+	hcMatchRcode       bool
+	hcAnswer           dns.RR
 }
 
 var defaultTimeout = 5 * time.Second
@@ -2,6 +2,7 @@ package forward
 
 import (
 	"crypto/tls"
+	"errors"
 	"sync/atomic"
 	"time"
 
@@ -18,6 +19,12 @@ type HealthChecker interface {
 	GetRecursionDesired() bool
 	SetDomain(domain string)
 	GetDomain() string
+	SetQueryType(qtype uint16)
+	GetQueryType() uint16
+	SetRcode(rcode int)
+	GetRcode() int
+	SetAnswer(answer dns.RR)
+	GetAnswer() dns.RR
 	SetTCPTransport()
 }
 
@@ -26,6 +33,9 @@ type dnsHc struct {
 	c                *dns.Client
 	recursionDesired bool
 	domain           string
+	qtype            uint16
+	rcode            int    // expected rcode, -1 means any rcode is accepted
+	answer           dns.RR // expected record in the answer section, only its rdata is compared, nil means no check
 }
 
 var (
@@ -42,7 +52,7 @@ func NewHealthChecker(trans string, recursionDesired bool, domain string) Health
 		c.ReadTimeout = hcReadTimeout
 		c.WriteTimeout = hcWriteTimeout
 
-		return &dnsHc{c: c, recursionDesired: recursionDesired, domain: domain}
+		return &dnsHc{c: c, recursionDesired: recursionDesired, domain: domain, qtype: dns.TypeNS, rcode: -1}
 	}
 
 	log.Warningf("No healthchecker for transport %q", trans)
@@ -68,12 +78,34 @@ func (h *dnsHc) GetDomain() string {
 	return h.domain
 }
 
+func (h *dnsHc) SetQueryType(qtype uint16) {
+	h.qtype = qtype
+}
+func (h *dnsHc) GetQueryType() uint16 {
+	return h.qtype
+}
+
+func (h *dnsHc) SetRcode(rcode int) {
+	h.rcode = rcode
+}
+func (h *dnsHc) GetRcode() int {
+	return h.rcode
+}
+
+func (h *dnsHc) SetAnswer(answer dns.RR) {
+	h.answer = answer
+}
+func (h *dnsHc) GetAnswer() dns.RR {
+	return h.answer
+}
+
 func (h *dnsHc) SetTCPTransport() {
 	h.c.Net = "tcp"
 }
 
 // For HC we send to . IN NS +[no]rec message to the upstream. Dial timeouts and empty
 // replies are considered fails, basically anything else constitutes a healthy upstream.
+// When an expected rcode or answer is configured the reply must also match those.
 
 // Check is used as the up.Func in the up.Probe.
 func (h *dnsHc) Check(p *Proxy) error {
@@ -90,7 +122,7 @@ func (h *dnsHc) Check(p *Proxy) error {
 
 func (h *dnsHc) send(addr string) error {
 	ping := new(dns.Msg)
-	ping.SetQuestion(h.domain, dns.TypeNS)
+	ping.SetQuestion(h.domain, h.qtype)
 	ping.MsgHdr.RecursionDesired = h.recursionDesired
 
 	m, _, err := h.c.Exchange(ping, addr)
@@ -101,6 +133,41 @@ func (h *dnsHc) send(addr string) error {
 			err = nil
 		}
 	}
+	if err != nil {
+		return err
+	}
 
-	return err
+	return h.verify(m)
 }
+
+// verify checks the reply m against the configured expected rcode and answer.
+func (h *dnsHc) verify(m *dns.Msg) error {
+	rcode := h.rcode
+	if rcode == -1 && h.answer != nil {
+		rcode = dns.RcodeSuccess
+	}
+	if rcode != -1 && m.Rcode != rcode {
+		return errUnexpectedRcode
+	}
+	if h.answer == nil {
+		return nil
+	}
+
+	for _, rr := range m.Answer {
+		if rr.Header().Rrtype != h.answer.Header().Rrtype {
+			continue
+		}
+		// Give the expected record the header of rr, so only the rdata is compared.
+		want := dns.Copy(h.answer)
+		*want.Header() = *rr.Header()
+		if dns.IsDuplicate(want, rr) {
+			return nil
+		}
+	}
+	return errUnexpectedAnswer
+}
+
+var (
+	errUnexpectedRcode  = errors.New("health check returned unexpected rcode")
+	errUnexpectedAnswer = errors.New("health check did not return the expected answer")
+)
@@ -281,3 +281,64 @@ func TestHealthDomain(t *testing.T) {
 		t.Errorf("Expected number of health checks with Domain==%s to be %d, got %d", hcDomain, 1, i1)
 	}
 }
+
+func TestHealthQuery(t *testing.T) {
+	hcReadTimeout = 10 * time.Millisecond
+	hcWriteTimeout = 10 * time.Millisecond
+
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		switch {
+		case r.Question[0].Name == "canary.example.org." && r.Question[0].Qtype == dns.TypeA:
+			ret.Answer = append(ret.Answer, test.A("canary.example.org. 5 IN A 10.0.0.1"))
+		case r.Question[0].Name == "canary.example.org." && r.Question[0].Qtype == dns.TypeMX:
+			ret.Answer = append(ret.Answer, test.MX("canary.example.org. 5 IN MX 10 Mail.Example.org."))
+		default:
+			ret.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	tests := []struct {
+		domain    string
+		qtype     uint16
+		rcode     int
+		answer    string // parsed as the health_check answer option would
+		expectErr bool
+	}{
+		{".", dns.TypeNS, -1, "", false},
+		{".", dns.TypeNS, dns.RcodeSuccess, "", true},
+		{"canary.example.org.", dns.TypeA, dns.RcodeSuccess, "", false},
+		{"canary.example.org.", dns.TypeA, -1, "A 10.0.0.1", false},
+		{"canary.example.org.", dns.TypeA, -1, "A 10.0.0.2", true},
+		{"canary.example.org.", dns.TypeAAAA, -1, "A 10.0.0.1", true},
+		{"canary.example.org.", dns.TypeAAAA, dns.RcodeNameError, "", false},
+		{"canary.example.org.", dns.TypeMX, -1, "MX 10 mail.example.org.", false},
+		{"canary.example.org.", dns.TypeMX, -1, "MX 10 mail.example.org", false},
+		{"canary.example.org.", dns.TypeMX, -1, "MX 20 mail.example.org", true},
+	}
+
+	for i, tc := range tests {
+		p := NewProxy(s.Addr, transport.DNS)
+		p.health.SetDomain(tc.domain)
+		p.health.SetQueryType(tc.qtype)
+		p.health.SetRcode(tc.rcode)
+		if tc.answer != "" {
+			rr, err := dns.NewRR(". IN " + tc.answer)
+			if err != nil {
+				t.Fatalf("Test %d: %s", i, err)
+			}
+			p.health.SetAnswer(rr)
+		}
+
+		err := p.health.Check(p)
+		if tc.expectErr && err == nil {
+			t.Errorf("Test %d: expected health check to fail", i)
+		}
+		if !tc.expectErr && err != nil {
+			t.Errorf("Test %d: expected health check to succeed, got: %s", i, err)
+		}
+	}
+}
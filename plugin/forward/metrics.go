@@ -58,4 +58,70 @@ var (
 		Name:      "conn_cache_misses_total",
 		Help:      "Counter of connection cache misses per upstream and protocol.",
 	}, []string{"to", "proto"})
+	DiscoveredUpstreams = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "discovered_upstreams",
+		Help:      "Gauge of the number of upstreams discovered per name.",
+	}, []string{"name"})
+	DiscoveryFailureCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "discovery_failures_total",
+		Help:      "Counter of failed resolutions of upstreams specified by name.",
+	}, []string{"name"})
+	TableEntries = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "table_entries",
+		Help:      "The number of domains in the forwarding table.",
+	}, []string{"file"})
+	TableReloadTime = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "table_reload_timestamp_seconds",
+		Help:      "The timestamp of the last reload of the forwarding table.",
+	}, []string{"file"})
+	ECSMismatchCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "ecs_mismatches_total",
+		Help:      "Counter of responses with an ECS option that doesn't match the request per upstream.",
+	}, []string{"to"})
+	CaseMismatchCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "case_mismatches_total",
+		Help:      "Counter of responses that didn't echo the randomized case of the query name per upstream.",
+	}, []string{"to"})
+	CookieMismatchCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "cookie_mismatches_total",
+		Help:      "Counter of responses that didn't echo our client cookie per upstream.",
+	}, []string{"to"})
+	BadCookieCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "bad_cookies_total",
+		Help:      "Counter of BADCOOKIE responses per upstream.",
+	}, []string{"to"})
+	PipelineConnCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "pipeline_connections",
+		Help:      "Gauge of open connections shared by pipelined queries per upstream and protocol.",
+	}, []string{"to", "proto"})
+	EDNSLevel = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "edns_level",
+		Help:      "Gauge of the EDNS compliance level per upstream: 0 full, 1 no options, 2 small buffer, 3 no EDNS.",
+	}, []string{"to"})
+	EDNSFallbackCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "edns_fallbacks_total",
+		Help:      "Counter of queries retried with less EDNS per upstream and level.",
+	}, []string{"to", "level"})
 )
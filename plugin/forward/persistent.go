@@ -3,6 +3,7 @@ package forward
 import (
 	"crypto/tls"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/horahoradev/dns"
@@ -22,6 +23,10 @@ type Transport struct {
 	addr        string
 	tlsConfig   *tls.Config
 
+	maxInflight int                   // queries in flight per shared connection, 0 disables pipelining
+	muxMu       sync.Mutex            // protects muxConns
+	muxConns    map[string][]*muxConn // shared connections per protocol
+
 	dial  chan string
 	yield chan *persistConn
 	ret   chan *persistConn
@@ -34,6 +39,7 @@ func newTransport(addr string) *Transport {
 		conns:       [typeTotalCount][]*persistConn{},
 		expire:      defaultExpire,
 		addr:        addr,
+		muxConns:    make(map[string][]*muxConn),
 		dial:        make(chan string),
 		yield:       make(chan *persistConn),
 		ret:         make(chan *persistConn),
@@ -140,8 +146,11 @@ func (t *Transport) Yield(pc *persistConn) {
 // Start starts the transport's connection manager.
 func (t *Transport) Start() { go t.connManager() }
 
-// Stop stops the transport's connection manager.
-func (t *Transport) Stop() { close(t.stop) }
+// Stop stops the transport's connection manager and closes the shared connections.
+func (t *Transport) Stop() {
+	close(t.stop)
+	t.closeMux()
+}
 
 // SetExpire sets the connection expire time in transport.
 func (t *Transport) SetExpire(expire time.Duration) { t.expire = expire }
@@ -0,0 +1,332 @@
+package forward
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/horahoradev/dns"
+)
+
+// A muxConn is a TCP or TLS connection to an upstream that is shared by concurrent queries, as described in
+// RFC 7766, section 6.2.1.1. A single goroutine reads the responses and hands them to the queries waiting for
+// them by matching the ID. Queries can reserve a slot while the connection is still being dialed, so a burst of
+// queries doesn't open a connection each.
+type muxConn struct {
+	c     *dns.Conn
+	addr  string
+	proto string
+
+	ready chan struct{} // closed when dialing is done
+	err   error         // dial error, only read after ready is closed
+
+	wmu sync.Mutex // serializes writes
+
+	mu       sync.Mutex
+	inflight map[uint16]chan *dns.Msg
+	idle     time.Duration // idle timeout, the upstream can change it with the edns-tcp-keepalive option
+	draining bool          // the upstream asked us to close the connection, don't send new queries
+	closed   bool
+}
+
+func newMuxConn(addr, proto string, idle time.Duration) *muxConn {
+	return &muxConn{
+		addr:     addr,
+		proto:    proto,
+		ready:    make(chan struct{}),
+		idle:     idle,
+		inflight: make(map[uint16]chan *dns.Msg),
+	}
+}
+
+// connected sets the dialed connection c, or the error if dialing failed, and starts reading responses.
+func (mc *muxConn) connected(c *dns.Conn, err error) {
+	mc.mu.Lock()
+	mc.c, mc.err = c, err
+	closed := mc.closed
+	mc.mu.Unlock()
+	close(mc.ready)
+
+	switch {
+	case err != nil:
+		mc.close()
+	case closed: // closed while dialing
+		c.Close()
+	default:
+		PipelineConnCount.WithLabelValues(mc.addr, mc.proto).Inc()
+		go mc.read()
+	}
+}
+
+// reserve reserves a slot for a query if less than limit queries are in flight. It returns the ID the query must
+// use and the channel its response will be delivered on.
+func (mc *muxConn) reserve(limit int) (uint16, chan *dns.Msg, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.closed || mc.draining || len(mc.inflight) >= limit {
+		return 0, nil, false
+	}
+	id := dns.Id()
+	for _, ok := mc.inflight[id]; ok; _, ok = mc.inflight[id] {
+		id = dns.Id()
+	}
+	ch := make(chan *dns.Msg, 1)
+	mc.inflight[id] = ch
+	return id, ch, true
+}
+
+// release releases the slot of the query with id, if it is still reserved.
+func (mc *muxConn) release(id uint16) {
+	mc.mu.Lock()
+	delete(mc.inflight, id)
+	drained := mc.draining && len(mc.inflight) == 0
+	mc.mu.Unlock()
+	if drained {
+		mc.close()
+	}
+}
+
+// usable returns true if new queries can be sent over mc.
+func (mc *muxConn) usable() bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return !mc.closed && !mc.draining
+}
+
+// write writes m to the connection.
+func (mc *muxConn) write(m *dns.Msg) error {
+	mc.wmu.Lock()
+	defer mc.wmu.Unlock()
+	mc.c.SetWriteDeadline(time.Now().Add(maxTimeout))
+	return mc.c.WriteMsg(m)
+}
+
+// read reads responses until the connection fails or has been idle for too long. Any read error closes the
+// connection: a timeout in the middle of a message leaves the stream at an unknown offset.
+func (mc *muxConn) read() {
+	for {
+		mc.mu.Lock()
+		deadline := mc.idle
+		// Queries in flight time out by themselves, give them the time to do so.
+		if len(mc.inflight) > 0 && deadline < readTimeout {
+			deadline = readTimeout
+		}
+		mc.mu.Unlock()
+		mc.c.SetReadDeadline(time.Now().Add(deadline))
+
+		ret, err := mc.c.ReadMsg()
+		if err != nil {
+			mc.close()
+			return
+		}
+
+		mc.mu.Lock()
+		if ka := keepaliveOption(ret); ka != nil {
+			// A timeout of 0 means the upstream wants us to close the connection (RFC 7828, section 3.3.2).
+			if ka.Timeout == 0 {
+				mc.draining = true
+			} else {
+				mc.idle = time.Duration(ka.Timeout) * 100 * time.Millisecond
+			}
+		}
+		ch, ok := mc.inflight[ret.Id]
+		delete(mc.inflight, ret.Id)
+		drained := mc.draining && len(mc.inflight) == 0
+		mc.mu.Unlock()
+
+		// Responses to queries that timed out are dropped.
+		if ok {
+			ch <- ret
+		}
+		if drained {
+			mc.close()
+			return
+		}
+	}
+}
+
+// close closes the connection, queries that are still in flight get their channel closed.
+func (mc *muxConn) close() {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.closed {
+		return
+	}
+	mc.closed = true
+	for id, ch := range mc.inflight {
+		close(ch)
+		delete(mc.inflight, id)
+	}
+	if mc.c != nil {
+		mc.c.Close()
+		PipelineConnCount.WithLabelValues(mc.addr, mc.proto).Dec()
+	}
+}
+
+// pipelined returns true if queries over proto share connections.
+func (t *Transport) pipelined(proto string) bool {
+	return t.maxInflight > 0 && (proto == "tcp" || t.tlsConfig != nil)
+}
+
+// muxConn returns a connection for proto that has room for another query, and reserves a slot on it. If there
+// is no such connection a new one is dialed. The returned connection may still be dialing if another query
+// started it.
+func (t *Transport) muxConn(proto string) (*muxConn, uint16, chan *dns.Msg, bool) {
+	t.muxMu.Lock()
+	conns := t.muxConns[proto][:0]
+	var (
+		mc *muxConn
+		id uint16
+		ch chan *dns.Msg
+	)
+	for _, c := range t.muxConns[proto] {
+		if !c.usable() {
+			continue
+		}
+		conns = append(conns, c)
+		if mc == nil {
+			if i, r, ok := c.reserve(t.maxInflight); ok {
+				mc, id, ch = c, i, r
+			}
+		}
+	}
+	t.muxConns[proto] = conns
+
+	if mc != nil {
+		t.muxMu.Unlock()
+		ConnCacheHitsCount.WithLabelValues(t.addr, proto).Add(1)
+		return mc, id, ch, true
+	}
+
+	mc = newMuxConn(t.addr, proto, t.expire)
+	id, ch, _ = mc.reserve(t.maxInflight)
+	t.muxConns[proto] = append(t.muxConns[proto], mc)
+	t.muxMu.Unlock()
+	ConnCacheMissesCount.WithLabelValues(t.addr, proto).Add(1)
+
+	reqTime := time.Now()
+	var (
+		conn *dns.Conn
+		err  error
+	)
+	if proto == "tcp-tls" {
+		conn, err = dns.DialTimeoutWithTLS("tcp", t.addr, t.tlsConfig, t.dialTimeout())
+	} else {
+		conn, err = dns.DialTimeout(proto, t.addr, t.dialTimeout())
+	}
+	t.updateDialTimeout(time.Since(reqTime))
+	mc.connected(conn, err)
+	return mc, id, ch, false
+}
+
+// exchange sends m over a shared connection and waits for the response. The edns-tcp-keepalive option is added
+// to queries that use EDNS, so the upstream can tell us how long to keep the connection open.
+func (t *Transport) exchange(proto string, m *dns.Msg) (*dns.Msg, error) {
+	if t.tlsConfig != nil {
+		proto = "tcp-tls"
+	}
+
+	mc, id, ch, cached := t.muxConn(proto)
+	defer mc.release(id)
+
+	<-mc.ready
+	if mc.err != nil {
+		return nil, mc.err
+	}
+
+	// m may be shared with other goroutines, a copy is written with our ID and options.
+	w := m.Copy()
+	w.Id = id
+	o := w.IsEdns0()
+	keepalive := o != nil && keepaliveOption(w) == nil
+	if keepalive {
+		o.Option = append(o.Option, &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE})
+	}
+	if err := mc.write(w); err != nil {
+		mc.close()
+		if err == io.EOF && cached {
+			return nil, ErrCachedClosed
+		}
+		return nil, err
+	}
+
+	timer := time.NewTimer(readTimeout)
+	defer timer.Stop()
+	select {
+	case ret, ok := <-ch:
+		if !ok {
+			if cached {
+				return nil, ErrCachedClosed
+			}
+			return nil, errPipelineClosed
+		}
+		ret.Id = m.Id
+		if keepalive {
+			removeKeepaliveOption(ret)
+		}
+		return ret, nil
+	case <-timer.C:
+		return nil, errPipelineTimeout
+	}
+}
+
+// closeMux closes all shared connections.
+func (t *Transport) closeMux() {
+	t.muxMu.Lock()
+	defer t.muxMu.Unlock()
+	for proto, conns := range t.muxConns {
+		for _, mc := range conns {
+			mc.close()
+		}
+		delete(t.muxConns, proto)
+	}
+}
+
+// SetMaxInflight sets the maximum number of queries in flight on a shared connection, 0 disables pipelining.
+func (t *Transport) SetMaxInflight(limit int) { t.maxInflight = limit }
+
+// keepaliveOption returns the edns-tcp-keepalive option in m, or nil if there is none.
+func keepaliveOption(m *dns.Msg) *dns.EDNS0_TCP_KEEPALIVE {
+	o := m.IsEdns0()
+	if o == nil {
+		return nil
+	}
+	for _, s := range o.Option {
+		if e, ok := s.(*dns.EDNS0_TCP_KEEPALIVE); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// removeKeepaliveOption removes all edns-tcp-keepalive options from m.
+func removeKeepaliveOption(m *dns.Msg) {
+	o := m.IsEdns0()
+	if o == nil {
+		return
+	}
+	options := o.Option[:0]
+	for _, s := range o.Option {
+		if _, ok := s.(*dns.EDNS0_TCP_KEEPALIVE); !ok {
+			options = append(options, s)
+		}
+	}
+	o.Option = options
+}
+
+var (
+	errPipelineClosed = errors.New("pipelined connection closed")
+	// errPipelineTimeout is a net.Error, so it is counted as a timeout like the read timeouts of a connection.
+	errPipelineTimeout net.Error = timeoutError("timeout waiting for pipelined response")
+)
+
+// timeoutError is a net.Error that is a timeout.
+type timeoutError string
+
+func (e timeoutError) Error() string   { return string(e) }
+func (e timeoutError) Timeout() bool   { return true }
+func (e timeoutError) Temporary() bool { return true }
+
+const defaultMaxInflight = 100
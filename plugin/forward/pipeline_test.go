@@ -0,0 +1,244 @@
+package forward
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+func TestSetupPipeline(t *testing.T) {
+	tests := []struct {
+		input       string
+		shouldErr   bool
+		expected    int
+		expectedErr string
+	}{
+		{"forward . 127.0.0.1\n", false, 0, ""},
+		{"forward . 127.0.0.1 {\npipeline\n}\n", false, defaultMaxInflight, ""},
+		{"forward . 127.0.0.1 {\npipeline 10\n}\n", false, 10, ""},
+		// negative
+		{"forward . 127.0.0.1 {\npipeline 0\n}\n", true, 0, "can't be zero or negative"},
+		{"forward . 127.0.0.1 {\npipeline 10 20\n}\n", true, 0, "Wrong argument count"},
+		{"forward . 127.0.0.1 {\npipeline many\n}\n", true, 0, "invalid syntax"},
+	}
+
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		fs, err := parseForward(c)
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error, got none", i)
+			} else if !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("Test %d: expected error to contain %q, got %q", i, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		if f := fs[0]; f.maxInflight != tc.expected || f.proxies[0].transport.maxInflight != tc.expected {
+			t.Errorf("Test %d: expected max in flight %d, got %d", i, tc.expected, f.maxInflight)
+		}
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		conns = map[string]bool{}
+	)
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		mu.Lock()
+		conns[w.RemoteAddr().String()] = true
+		mu.Unlock()
+
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		if keepaliveOption(r) != nil {
+			ret.SetEdns0(4096, false)
+			ret.IsEdns0().Option = append(ret.IsEdns0().Option, &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE, Timeout: 100})
+		}
+		ret.Answer = append(ret.Answer, test.TXT(r.Question[0].Name+" 5 IN TXT "+r.Question[0].Name))
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	p := NewProxy(s.Addr, "dns")
+	p.SetMaxInflight(5)
+	p.start(hcInterval)
+	defer p.stop()
+	defer p.transport.Stop()
+
+	const queries = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, queries)
+	for i := 0; i < queries; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m := new(dns.Msg)
+			m.SetQuestion(strings.Repeat("a", i+1)+".example.org.", dns.TypeTXT)
+			m.SetEdns0(4096, false)
+			m.Id = uint16(i)
+			req := request.Request{W: &test.ResponseWriter{}, Req: m}
+
+			ret, err := p.Connect(context.Background(), req, options{forceTCP: true})
+			if err != nil {
+				errs <- err
+				return
+			}
+			if ret.Id != m.Id {
+				t.Errorf("Expected ID %d, got %d", m.Id, ret.Id)
+			}
+			if txt := ret.Answer[0].(*dns.TXT).Txt[0]; txt != m.Question[0].Name {
+				t.Errorf("Expected the response to %s, got the one to %s", m.Question[0].Name, txt)
+			}
+			if keepaliveOption(ret) != nil {
+				t.Errorf("Expected the edns-tcp-keepalive option to be removed from the response")
+			}
+			if keepaliveOption(m) != nil {
+				t.Errorf("Expected the request to be unchanged")
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Expected no error, got %s", err)
+	}
+
+	// 20 queries with at most 5 in flight per connection need at most 4 connections, fewer if some queries
+	// were answered before the others were sent.
+	mu.Lock()
+	defer mu.Unlock()
+	if len(conns) > queries/5 {
+		t.Errorf("Expected at most %d connections, got %d", queries/5, len(conns))
+	}
+	for _, mc := range p.transport.muxConns["tcp"] {
+		mc.mu.Lock()
+		if mc.idle != 10*time.Second {
+			t.Errorf("Expected the idle timeout to be set by the edns-tcp-keepalive option, got %s", mc.idle)
+		}
+		mc.mu.Unlock()
+	}
+}
+
+func TestPipelineKeepaliveClose(t *testing.T) {
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		// Ask the client to close the connection.
+		ret.SetEdns0(4096, false)
+		ret.IsEdns0().Option = append(ret.IsEdns0().Option, &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE})
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	tr := newTransport(s.Addr)
+	tr.SetMaxInflight(5)
+	tr.Start()
+	defer tr.Stop()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	m.SetEdns0(4096, false)
+
+	if _, err := tr.exchange("tcp", m); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	first := tr.muxConns["tcp"][0]
+	if _, err := tr.exchange("tcp", m); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if first.usable() {
+		t.Errorf("Expected the connection to be closed")
+	}
+	if tr.muxConns["tcp"][0] == first {
+		t.Errorf("Expected a new connection")
+	}
+}
+
+func TestPipelineTimeout(t *testing.T) {
+	defer func(d time.Duration) { readTimeout = d }(readTimeout)
+	readTimeout = 100 * time.Millisecond
+
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		// Never reply.
+	})
+	defer s.Close()
+
+	tr := newTransport(s.Addr)
+	tr.SetMaxInflight(5)
+	tr.Start()
+	defer tr.Stop()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+
+	_, err := tr.exchange("tcp", m)
+	if err == nil {
+		t.Fatal("Expected an error, got none")
+	}
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("Expected a timeout net.Error, got %T: %s", err, err)
+	}
+}
+
+func TestPipelinePartialRead(t *testing.T) {
+	defer func(d time.Duration) { readTimeout = d }(readTimeout)
+	readTimeout = 100 * time.Millisecond
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, 512)
+		c.Read(buf)
+		// Send the length and the start of a message, but never the rest.
+		c.Write([]byte{0, 64, 0, 1})
+		time.Sleep(time.Second)
+	}()
+
+	tr := newTransport(l.Addr().String())
+	tr.SetMaxInflight(5)
+	tr.SetExpire(50 * time.Millisecond)
+	tr.Start()
+	defer tr.Stop()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	m.SetEdns0(4096, false)
+	id := m.Id
+
+	if _, err := tr.exchange("tcp", m); err == nil {
+		t.Fatal("Expected an error, got none")
+	}
+	if m.Id != id || len(m.IsEdns0().Option) != 0 {
+		t.Errorf("Expected the query to be unchanged, got %s", m)
+	}
+
+	mc := tr.muxConns["tcp"][0]
+	for i := 0; i < 20 && mc.usable(); i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if mc.usable() {
+		t.Errorf("Expected the connection to be closed after a read timeout in the middle of a message")
+	}
+}
@@ -2,6 +2,7 @@ package forward
 
 import (
 	"crypto/tls"
+	"net"
 	"runtime"
 	"sync/atomic"
 	"time"
@@ -13,18 +14,23 @@ import (
 type Proxy struct {
 	fails uint32
 	addr  string
+	trans string
 
 	transport *Transport
 
 	// health checking
 	probe  *up.Probe
 	health HealthChecker
+
+	cookie *clientCookie   // DNS Cookie state, nil if cookies are disabled
+	edns   *ednsCompliance // EDNS compliance level, nil if EDNS fallback is disabled
 }
 
 // NewProxy returns a new proxy.
 func NewProxy(addr, trans string) *Proxy {
 	p := &Proxy{
 		addr:      addr,
+		trans:     trans,
 		fails:     0,
 		probe:     up.New(),
 		transport: newTransport(addr),
@@ -40,6 +46,10 @@ func (p *Proxy) SetTLSConfig(cfg *tls.Config) {
 	p.health.SetTLSConfig(cfg)
 }
 
+// SetMaxInflight sets the maximum number of queries in flight on a shared TCP or TLS connection in the lower
+// p.transport, 0 disables pipelining.
+func (p *Proxy) SetMaxInflight(limit int) { p.transport.SetMaxInflight(limit) }
+
 // SetExpire sets the expire duration in the lower p.transport.
 func (p *Proxy) SetExpire(expire time.Duration) { p.transport.SetExpire(expire) }
 
@@ -65,6 +75,21 @@ func (p *Proxy) Down(maxfails uint32) bool {
 	return fails > maxfails
 }
 
+// proxyIn returns true if the address of p is in one of the networks.
+func proxyIn(p *Proxy, networks []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(p.addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // close stops the health checking goroutine.
 func (p *Proxy) stop()      { p.probe.Stop() }
 func (p *Proxy) finalizer() { p.transport.Stop() }
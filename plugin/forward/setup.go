@@ -4,7 +4,11 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coredns/caddy"
@@ -68,15 +72,27 @@ func setup(c *caddy.Controller) error {
 
 // OnStartup starts a goroutines for all proxies.
 func (f *Forward) OnStartup() (err error) {
-	for _, p := range f.proxies {
+	for _, p := range f.Proxies() {
 		p.start(f.hcInterval)
 	}
+	if f.discovery != nil {
+		f.discovery.start(f)
+	}
+	if f.table != nil {
+		f.table.start(f)
+	}
 	return nil
 }
 
 // OnShutdown stops all configured proxies.
 func (f *Forward) OnShutdown() error {
-	for _, p := range f.proxies {
+	if f.discovery != nil {
+		f.discovery.stop()
+	}
+	if f.table != nil {
+		f.table.stop()
+	}
+	for _, p := range f.Proxies() {
 		p.stop()
 	}
 	return nil
@@ -116,9 +132,25 @@ func parseStanza(c *caddy.Controller) (*Forward, error) {
 		return f, c.ArgErr()
 	}
 
-	toHosts, err := parse.HostPortOrFile(to...)
-	if err != nil {
-		return f, err
+	var static []string
+	for _, host := range to {
+		if dn, ok := parseDynamicName(host); ok {
+			if f.discovery == nil {
+				f.discovery = newDiscovery()
+			}
+			f.discovery.names = append(f.discovery.names, dn)
+			continue
+		}
+		static = append(static, host)
+	}
+
+	var toHosts []string
+	if len(static) > 0 {
+		var err error
+		toHosts, err = parse.HostPortOrFile(static...)
+		if err != nil {
+			return f, err
+		}
 	}
 
 	transports := make([]string, len(toHosts))
@@ -133,6 +165,13 @@ func parseStanza(c *caddy.Controller) (*Forward, error) {
 		f.proxies = append(f.proxies, p)
 		transports[i] = trans
 	}
+	if f.discovery != nil {
+		for _, dn := range f.discovery.names {
+			if !allowedTrans[dn.trans] {
+				return f, fmt.Errorf("'%s' is not supported as a destination protocol in forward: %s", dn.trans, dn.name)
+			}
+		}
+	}
 
 	for c.NextBlock() {
 		if err := parseBlock(c, f); err != nil {
@@ -145,26 +184,54 @@ func parseStanza(c *caddy.Controller) (*Forward, error) {
 	}
 
 	// Initialize ClientSessionCache in tls.Config. This may speed up a TLS handshake
-	// in upcoming connections to the same TLS server.
-	f.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(len(f.proxies))
+	// in upcoming connections to the same TLS server. It is sized for the most upstreams we use, as
+	// discovery can add upstreams later on.
+	f.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(max)
 
 	for i := range f.proxies {
-		// Only set this for proxies that need it.
-		if transports[i] == transport.TLS {
-			f.proxies[i].SetTLSConfig(f.tlsConfig)
-		}
-		f.proxies[i].SetExpire(f.expire)
-		f.proxies[i].health.SetRecursionDesired(f.opts.hcRecursionDesired)
-		// when TLS is used, checks are set to tcp-tls
-		if f.opts.forceTCP && transports[i] != transport.TLS {
-			f.proxies[i].health.SetTCPTransport()
+		f.configureProxy(f.proxies[i], transports[i])
+	}
+
+	if f.discovery != nil && len(f.discovery.resolvers) == 0 {
+		resolvers, err := parse.HostPortOrFile(defaultResolvConf)
+		if err != nil {
+			return f, err
 		}
-		f.proxies[i].health.SetDomain(f.opts.hcDomain)
+		f.discovery.resolvers = resolvers
 	}
 
 	return f, nil
 }
 
+// configureProxy applies the settings of f to the proxy p that uses transport trans.
+func (f *Forward) configureProxy(p *Proxy, trans string) {
+	// Only set this for proxies that need it.
+	if trans == transport.TLS {
+		p.SetTLSConfig(f.tlsConfig)
+	}
+	p.SetExpire(f.expire)
+	p.SetMaxInflight(f.maxInflight)
+	p.health.SetRecursionDesired(f.opts.hcRecursionDesired)
+	// when TLS is used, checks are set to tcp-tls
+	if f.opts.forceTCP && trans != transport.TLS {
+		p.health.SetTCPTransport()
+	}
+	p.health.SetDomain(f.opts.hcDomain)
+	if f.opts.hcType != 0 {
+		p.health.SetQueryType(f.opts.hcType)
+	}
+	if f.opts.hcMatchRcode {
+		p.health.SetRcode(f.opts.hcRcode)
+	}
+	p.health.SetAnswer(f.opts.hcAnswer)
+	if f.cookie {
+		p.cookie = newClientCookie()
+	}
+	if f.ednsFallback > 0 {
+		p.edns = newEDNSCompliance(p.addr, f.ednsFallback)
+	}
+}
+
 func parseBlock(c *caddy.Controller, f *Forward) error {
 	switch c.Val() {
 	case "except":
@@ -197,6 +264,7 @@ func parseBlock(c *caddy.Controller, f *Forward) error {
 		}
 		f.hcInterval = dur
 		f.opts.hcDomain = "."
+		hcAnswer := ""
 
 		for c.NextArg() {
 			switch hcOpts := c.Val(); hcOpts {
@@ -211,10 +279,47 @@ func parseBlock(c *caddy.Controller, f *Forward) error {
 					return fmt.Errorf("health_check: invalid domain name %s", hcDomain)
 				}
 				f.opts.hcDomain = plugin.Name(hcDomain).Normalize()
+			case "type":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				qtype, ok := dns.StringToType[strings.ToUpper(c.Val())]
+				if !ok {
+					return fmt.Errorf("health_check: invalid type %s", c.Val())
+				}
+				f.opts.hcType = qtype
+			case "rcode":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				rcode, ok := dns.StringToRcode[strings.ToUpper(c.Val())]
+				if !ok {
+					return fmt.Errorf("health_check: invalid rcode %s", c.Val())
+				}
+				f.opts.hcRcode = rcode
+				f.opts.hcMatchRcode = true
+			case "answer":
+				// The rdata can have more than one field, it takes the rest of the line.
+				data := c.RemainingArgs()
+				if len(data) == 0 {
+					return c.ArgErr()
+				}
+				hcAnswer = strings.Join(data, " ")
 			default:
 				return fmt.Errorf("health_check: unknown option %s", hcOpts)
 			}
 		}
+		if hcAnswer != "" {
+			qtype := f.opts.hcType
+			if qtype == 0 {
+				qtype = dns.TypeNS
+			}
+			rr, err := dns.NewRR(". IN " + dns.TypeToString[qtype] + " " + hcAnswer)
+			if err != nil || rr == nil {
+				return fmt.Errorf("health_check: invalid answer %s for type %s", hcAnswer, dns.TypeToString[qtype])
+			}
+			f.opts.hcAnswer = rr
+		}
 
 	case "force_tcp":
 		if c.NextArg() {
@@ -281,6 +386,183 @@ func parseBlock(c *caddy.Controller, f *Forward) error {
 		}
 		f.ErrLimitExceeded = errors.New("concurrent queries exceeded maximum " + c.Val())
 		f.maxConcurrent = int64(n)
+	case "ecs":
+		args := c.RemainingArgs()
+		if len(args) == 0 || len(args) > 3 {
+			return c.ArgErr()
+		}
+		if f.ecs == nil {
+			f.ecs = newECSPolicy()
+		}
+		switch args[0] {
+		case ecsPass, ecsStrip:
+			if len(args) > 1 {
+				return c.ArgErr()
+			}
+		case ecsAdd:
+			for i, limit := range []int{32, 128} {
+				if len(args) < i+2 {
+					break
+				}
+				n, err := strconv.Atoi(args[i+1])
+				if err != nil {
+					return err
+				}
+				if n < 0 || n > limit {
+					return fmt.Errorf("ecs: invalid source prefix length %d", n)
+				}
+				if i == 0 {
+					f.ecs.v4 = uint8(n)
+				} else {
+					f.ecs.v6 = uint8(n)
+				}
+			}
+		default:
+			return c.Errf("unknown ecs mode '%s'", args[0])
+		}
+		f.ecs.mode = args[0]
+	case "ecs_allow":
+		args := c.RemainingArgs()
+		if len(args) == 0 {
+			return c.ArgErr()
+		}
+		if f.ecs == nil {
+			f.ecs = newECSPolicy()
+		}
+		networks, err := parseNetworks(args)
+		if err != nil {
+			return fmt.Errorf("ecs_allow: %s", err)
+		}
+		f.ecs.allow = networks
+	case "randomize_case":
+		args := c.RemainingArgs()
+		if len(args) > 0 {
+			if args[0] != "except" || len(args) == 1 {
+				return c.ArgErr()
+			}
+			networks, err := parseNetworks(args[1:])
+			if err != nil {
+				return fmt.Errorf("randomize_case: %s", err)
+			}
+			f.caseExcept = networks
+		}
+		f.randomizeCase = true
+	case "pipeline":
+		args := c.RemainingArgs()
+		if len(args) > 1 {
+			return c.ArgErr()
+		}
+		f.maxInflight = defaultMaxInflight
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil {
+				return err
+			}
+			if n <= 0 {
+				return fmt.Errorf("pipeline can't be zero or negative: %d", n)
+			}
+			f.maxInflight = n
+		}
+	case "edns_fallback":
+		args := c.RemainingArgs()
+		if len(args) > 1 {
+			return c.ArgErr()
+		}
+		f.ednsFallback = defaultEDNSFallbackTTL
+		if len(args) == 1 {
+			dur, err := time.ParseDuration(args[0])
+			if err != nil {
+				return err
+			}
+			if dur <= 0 {
+				return fmt.Errorf("edns_fallback can't be zero or negative: %s", dur)
+			}
+			f.ednsFallback = dur
+		}
+	case "cookie":
+		if c.NextArg() {
+			return c.ArgErr()
+		}
+		f.cookie = true
+	case "table":
+		args := c.RemainingArgs()
+		if len(args) == 0 || len(args) > 2 {
+			return c.ArgErr()
+		}
+		format := formatDnsmasq
+		if len(args) == 2 {
+			format = args[1]
+		}
+		if format != formatDnsmasq && format != formatCSV {
+			return c.Errf("unknown table format '%s'", format)
+		}
+		path := args[0]
+		if !filepath.IsAbs(path) && dnsserver.GetConfig(c).Root != "" {
+			path = filepath.Join(dnsserver.GetConfig(c).Root, path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+		f.table = newTable(path, format)
+	case "table_reload":
+		if f.table == nil {
+			return c.Errf("table_reload requires a table")
+		}
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		dur, err := time.ParseDuration(c.Val())
+		if err != nil {
+			return err
+		}
+		if dur < 0 {
+			return fmt.Errorf("table_reload can't be negative: %s", dur)
+		}
+		f.table.reload = dur
+	case "discovery_refresh":
+		if f.discovery == nil {
+			return c.Errf("discovery_refresh requires an upstream specified by name")
+		}
+		args := c.RemainingArgs()
+		if len(args) == 0 || len(args) > 2 {
+			return c.ArgErr()
+		}
+		durs := make([]time.Duration, len(args))
+		for i := range args {
+			dur, err := time.ParseDuration(args[i])
+			if err != nil {
+				return err
+			}
+			if dur <= 0 {
+				return fmt.Errorf("discovery_refresh must be positive: %s", dur)
+			}
+			durs[i] = dur
+		}
+		f.discovery.minRefresh = durs[0]
+		if len(durs) == 2 {
+			f.discovery.maxRefresh = durs[1]
+		}
+		if f.discovery.maxRefresh < f.discovery.minRefresh {
+			return fmt.Errorf("discovery_refresh: maximum %s is smaller than minimum %s", f.discovery.maxRefresh, f.discovery.minRefresh)
+		}
+	case "discovery_resolver":
+		if f.discovery == nil {
+			return c.Errf("discovery_resolver requires an upstream specified by name")
+		}
+		args := c.RemainingArgs()
+		if len(args) == 0 {
+			return c.ArgErr()
+		}
+		resolvers, err := parse.HostPortOrFile(args...)
+		if err != nil {
+			return err
+		}
+		for _, r := range resolvers {
+			if trans, _ := parse.Transport(r); trans != transport.DNS {
+				return fmt.Errorf("discovery_resolver: only plain DNS resolvers are supported: %s", r)
+			}
+		}
+		f.discovery.resolvers = resolvers
 
 	default:
 		return c.Errf("unknown property '%s'", c.Val())
@@ -289,4 +571,31 @@ func parseBlock(c *caddy.Controller, f *Forward) error {
 	return nil
 }
 
+// parseNetworks parses a list of IP addresses and CIDRs.
+func parseNetworks(args []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(args))
+	for _, a := range args {
+		if !strings.Contains(a, "/") {
+			ip := net.ParseIP(a)
+			if ip == nil {
+				return nil, fmt.Errorf("not an IP address or CIDR: %q", a)
+			}
+			if ip.To4() != nil {
+				a += "/32"
+			} else {
+				a += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(a)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
 const max = 15 // Maximum number of upstreams.
+
+// defaultResolvConf is used to find the resolvers for upstream discovery if none are configured.
+var defaultResolvConf = "/etc/resolv.conf"
@@ -255,6 +255,12 @@ func TestSetupHealthCheck(t *testing.T) {
 		{"forward . 127.0.0.1 {\nhealth_check 0.5s rec\n}\n", true, true, ".", "health_check: unknown option rec"},
 		{"forward . 127.0.0.1 {\nhealth_check 0.5s domain\n}\n", true, true, ".", "Wrong argument count or unexpected line ending after 'domain'"},
 		{"forward . 127.0.0.1 {\nhealth_check 0.5s domain example..org\n}\n", true, true, ".", "health_check: invalid domain name"},
+		{"forward . 127.0.0.1 {\nhealth_check 0.5s type\n}\n", true, true, ".", "Wrong argument count or unexpected line ending after 'type'"},
+		{"forward . 127.0.0.1 {\nhealth_check 0.5s type FOO\n}\n", true, true, ".", "health_check: invalid type FOO"},
+		{"forward . 127.0.0.1 {\nhealth_check 0.5s rcode FOO\n}\n", true, true, ".", "health_check: invalid rcode FOO"},
+		{"forward . 127.0.0.1 {\nhealth_check 0.5s answer\n}\n", true, true, ".", "Wrong argument count or unexpected line ending after 'answer'"},
+		{"forward . 127.0.0.1 {\nhealth_check 0.5s type A answer example.org\n}\n", true, true, ".", "health_check: invalid answer example.org for type A"},
+		{"forward . 127.0.0.1 {\nhealth_check 0.5s type MX answer 10\n}\n", true, true, ".", "health_check: invalid answer 10 for type MX"},
 	}
 
 	for i, test := range tests {
@@ -286,6 +292,45 @@ func TestSetupHealthCheck(t *testing.T) {
 	}
 }
 
+func TestSetupHealthCheckQuery(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedType   uint16
+		expectedRcode  int
+		expectedAnswer string
+	}{
+		{"forward . 127.0.0.1\n", dns.TypeNS, -1, ""},
+		{"forward . 127.0.0.1 {\nhealth_check 0.5s domain canary.example.org type A\n}\n", dns.TypeA, -1, ""},
+		{"forward . 127.0.0.1 {\nhealth_check 0.5s domain canary.example.org type aaaa rcode nxdomain\n}\n", dns.TypeAAAA, dns.RcodeNameError, ""},
+		{"forward . 127.0.0.1 {\nhealth_check 0.5s domain canary.example.org type A rcode NOERROR answer 10.0.0.1\n}\n", dns.TypeA, dns.RcodeSuccess, "10.0.0.1"},
+		{"forward . 127.0.0.1 {\nhealth_check 0.5s domain canary.example.org type MX answer 10 mail.example.org\n}\n", dns.TypeMX, -1, "10 mail.example.org."},
+		{"forward . 127.0.0.1 {\nhealth_check 0.5s answer a.root-servers.net.\n}\n", dns.TypeNS, -1, "a.root-servers.net."},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		fs, err := parseForward(c)
+		if err != nil {
+			t.Fatalf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+		}
+
+		h := fs[0].proxies[0].health
+		if h.GetQueryType() != test.expectedType {
+			t.Errorf("Test %d: expected type %d, got %d", i, test.expectedType, h.GetQueryType())
+		}
+		if h.GetRcode() != test.expectedRcode {
+			t.Errorf("Test %d: expected rcode %d, got %d", i, test.expectedRcode, h.GetRcode())
+		}
+		answer := ""
+		if rr := h.GetAnswer(); rr != nil {
+			answer = strings.TrimPrefix(rr.String(), rr.Header().String())
+		}
+		if answer != test.expectedAnswer {
+			t.Errorf("Test %d: expected answer %q, got %q", i, test.expectedAnswer, answer)
+		}
+	}
+}
+
 func TestMultiForward(t *testing.T) {
 	input := `
       forward 1st.example.org 10.0.0.1
@@ -0,0 +1,353 @@
+package forward
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/parse"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+
+	"github.com/horahoradev/dns"
+)
+
+// table is a conditional forwarding table loaded from a file. It maps domains to the upstreams that queries
+// for names in those domains are forwarded to. Entries that use the same upstream share a single proxy, and
+// thus its connection cache and health checking.
+type table struct {
+	path   string
+	format string
+	reload time.Duration
+
+	sync.RWMutex
+	// entries maps domains to upstreams. An empty list means the domain is not forwarded, a nil list that the
+	// upstreams configured as TO are used.
+	entries map[string][]*Proxy
+	proxies map[string]*Proxy // proxies used by the table, keyed by upstreamAddr.key()
+
+	// mtime and size are only read and modified by a single goroutine
+	mtime time.Time
+	size  int64
+
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// Supported table file formats.
+const (
+	formatDnsmasq = "dnsmasq" // server=/example.org/10.0.0.1#53
+	formatCSV     = "csv"     // example.org,10.0.0.1:53,tls://10.0.0.2
+)
+
+func newTable(path, format string) *table {
+	return &table{
+		path:    path,
+		format:  format,
+		reload:  defaultTableReload,
+		entries: make(map[string][]*Proxy),
+		proxies: make(map[string]*Proxy),
+		done:    make(chan struct{}),
+	}
+}
+
+// start loads the table and, if configured, starts periodically reloading it.
+func (t *table) start(f *Forward) {
+	t.readTable(f)
+	if t.reload == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(t.reload)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.done:
+				return
+			case <-ticker.C:
+				t.readTable(f)
+			}
+		}
+	}()
+}
+
+// stop stops reloading the table and the health checking of the proxies owned by the table.
+func (t *table) stop() {
+	t.stopOnce.Do(func() { close(t.done) })
+
+	t.RLock()
+	defer t.RUnlock()
+	for _, p := range t.proxies {
+		p.stop()
+	}
+}
+
+// lookup returns the upstreams for the longest domain in the table that qname is part of. If no domain matches,
+// or the domain uses the upstreams configured as TO, the second return value is false.
+func (t *table) lookup(qname string) ([]*Proxy, bool) {
+	t.RLock()
+	defer t.RUnlock()
+	if len(t.entries) == 0 {
+		return nil, false
+	}
+
+	qname = strings.ToLower(qname)
+	for off, end := 0, false; !end; off, end = dns.NextLabel(qname, off) {
+		if proxies, ok := t.entries[qname[off:]]; ok {
+			return proxies, proxies != nil
+		}
+	}
+	return nil, false
+}
+
+// Len returns the number of domains in the table.
+func (t *table) Len() int {
+	t.RLock()
+	defer t.RUnlock()
+	return len(t.entries)
+}
+
+// readTable determines if the table needs to be updated based on the size and modification time of the file.
+func (t *table) readTable(f *Forward) {
+	file, err := os.Open(t.path)
+	if err != nil {
+		log.Warningf("Failed to open forwarding table %q: %s", t.path, err)
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return
+	}
+	if t.mtime.Equal(stat.ModTime()) && t.size == stat.Size() {
+		return
+	}
+
+	domains, err := parseTable(file, t.format)
+	if err != nil {
+		log.Warningf("Failed to parse forwarding table %q: %s", t.path, err)
+		return
+	}
+	t.update(f, domains)
+	log.Debugf("Parsed forwarding table %q into %d entries", t.path, len(domains))
+
+	t.mtime = stat.ModTime()
+	t.size = stat.Size()
+
+	TableEntries.WithLabelValues(t.path).Set(float64(len(domains)))
+	TableReloadTime.WithLabelValues(t.path).Set(float64(stat.ModTime().UnixNano()) / 1e9)
+}
+
+// update replaces the entries of the table with domains. Proxies are shared between entries, with the
+// upstreams configured as an address in TO and with the previous version of the table. Upstreams found by
+// discovery get a proxy of their own, as discovery stops its proxies when their address disappears. Proxies
+// that are no longer used are stopped after a fixed grace period, see drainTimeout.
+func (t *table) update(f *Forward, domains map[string][]upstreamAddr) {
+	static := make(map[string]*Proxy)
+	for _, p := range f.staticProxies() {
+		static[upstreamAddr{addr: p.addr, trans: p.trans}.key()] = p
+	}
+
+	t.RLock()
+	previous := t.proxies
+	t.RUnlock()
+
+	entries := make(map[string][]*Proxy, len(domains))
+	proxies := make(map[string]*Proxy)
+	for domain, addrs := range domains {
+		list := make([]*Proxy, 0, len(addrs))
+		for _, a := range addrs {
+			if a == defaultUpstream {
+				list = nil
+				break
+			}
+			k := a.key()
+			if p, ok := static[k]; ok {
+				list = append(list, p)
+				continue
+			}
+			p, ok := proxies[k]
+			if !ok {
+				p, ok = previous[k]
+				if !ok {
+					p = NewProxy(a.addr, a.trans)
+					f.configureProxy(p, a.trans)
+					p.start(f.hcInterval)
+				}
+				proxies[k] = p
+			}
+			list = append(list, p)
+		}
+		entries[domain] = list
+	}
+
+	var gone []*Proxy
+	for k, p := range previous {
+		if _, ok := proxies[k]; !ok {
+			gone = append(gone, p)
+		}
+	}
+
+	t.Lock()
+	t.entries = entries
+	t.proxies = proxies
+	t.Unlock()
+
+	if len(gone) > 0 {
+		time.AfterFunc(drainTimeout, func() { drain(gone) })
+	}
+}
+
+// parseTable parses a forwarding table in format from r. Entries for the same domain are merged. Lines that can't
+// be parsed are logged and skipped.
+func parseTable(r io.Reader, format string) (map[string][]upstreamAddr, error) {
+	domains := make(map[string][]upstreamAddr)
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		var (
+			names []string
+			addr  string
+			err   error
+		)
+		switch format {
+		case formatDnsmasq:
+			names, addr, err = parseDnsmasqLine(line)
+		case formatCSV:
+			names, addr, err = parseCSVLine(line)
+		default:
+			return nil, fmt.Errorf("unknown format %q", format)
+		}
+		if err != nil {
+			log.Warningf("Skipping line %d of forwarding table: %s", i, err)
+			continue
+		}
+
+		var upstreams []upstreamAddr
+		for _, a := range strings.Split(addr, ",") {
+			if a == "" {
+				continue
+			}
+			u, err := parseTableUpstream(a)
+			if err != nil {
+				log.Warningf("Skipping line %d of forwarding table: %s", i, err)
+				upstreams = nil
+				names = nil
+				break
+			}
+			upstreams = append(upstreams, u)
+		}
+
+		for _, name := range names {
+			if _, ok := dns.IsDomainName(name); !ok {
+				log.Warningf("Skipping invalid domain %q on line %d of forwarding table", name, i)
+				continue
+			}
+			name = plugin.Name(name).Normalize()
+			if _, ok := domains[name]; !ok {
+				domains[name] = []upstreamAddr{}
+			}
+			for _, u := range upstreams {
+				if seen[name+" "+u.key()] {
+					continue
+				}
+				seen[name+" "+u.key()] = true
+				domains[name] = append(domains[name], u)
+			}
+		}
+	}
+	return domains, scanner.Err()
+}
+
+// parseDnsmasqLine parses a dnsmasq server=/domain/.../addr#port line. It returns the domains and the address,
+// the address is empty when the domains should not be forwarded.
+func parseDnsmasqLine(line string) ([]string, string, error) {
+	option := "server="
+	if strings.HasPrefix(line, "local=") {
+		option = "local="
+	}
+	if !strings.HasPrefix(line, option) {
+		return nil, "", fmt.Errorf("not a server line: %q", line)
+	}
+	value := strings.TrimSpace(line[len(option):])
+	if !strings.HasPrefix(value, "/") {
+		return nil, "", fmt.Errorf("no domain in line: %q", line)
+	}
+
+	parts := strings.Split(value[1:], "/")
+	if len(parts) < 2 {
+		return nil, "", fmt.Errorf("malformed line: %q", line)
+	}
+	names, addr := parts[:len(parts)-1], parts[len(parts)-1]
+	if option == "local=" {
+		addr = ""
+	}
+	// Strip the optional source address or interface.
+	if i := strings.IndexByte(addr, '@'); i >= 0 {
+		addr = addr[:i]
+	}
+	if addr == "#" {
+		return names, addr, nil
+	}
+	// dnsmasq uses # as the port separator, accept the port only when it's there.
+	if i := strings.IndexByte(addr, '#'); i >= 0 {
+		host, port := addr[:i], addr[i+1:]
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		addr = host + ":" + port
+	}
+	return names, addr, nil
+}
+
+// parseCSVLine parses a domain,upstream,... line. It returns the domain and a comma separated list of upstreams.
+func parseCSVLine(line string) ([]string, string, error) {
+	fields := strings.Split(line, ",")
+	name := strings.TrimSpace(fields[0])
+	if name == "" {
+		return nil, "", fmt.Errorf("no domain in line: %q", line)
+	}
+	upstreams := make([]string, 0, len(fields)-1)
+	for _, u := range fields[1:] {
+		upstreams = append(upstreams, strings.TrimSpace(u))
+	}
+	return []string{name}, strings.Join(upstreams, ","), nil
+}
+
+// parseTableUpstream parses an upstream address, optionally prefixed with a transport. As in dnsmasq, "#" stands
+// for the upstreams configured as TO.
+func parseTableUpstream(s string) (upstreamAddr, error) {
+	if s == "#" {
+		return defaultUpstream, nil
+	}
+	trans, host := parse.Transport(s)
+	if trans != transport.DNS && trans != transport.TLS {
+		return upstreamAddr{}, fmt.Errorf("'%s' is not supported as a destination protocol in forward: %s", trans, s)
+	}
+	port := transport.Port
+	if trans == transport.TLS {
+		port = transport.TLSPort
+	}
+	addr, err := parse.HostPort(host, port)
+	if err != nil {
+		return upstreamAddr{}, err
+	}
+	return upstreamAddr{addr: addr, trans: trans}, nil
+}
+
+// defaultUpstream is the upstream of domains that are forwarded to the upstreams configured as TO.
+var defaultUpstream = upstreamAddr{addr: "#"}
+
+const defaultTableReload = 5 * time.Second
@@ -0,0 +1,264 @@
+package forward
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/horahoradev/dns"
+)
+
+func TestParseTable(t *testing.T) {
+	tests := []struct {
+		format   string
+		input    string
+		expected map[string][]upstreamAddr
+	}{
+		{
+			formatDnsmasq,
+			`# comment
+server=/example.org/10.0.0.1
+server=/example.org/10.0.0.2#5353
+server=/a.example.net/b.example.net/::1#53
+server=/Example.ORG/10.0.0.1
+local=/internal.example.org/
+server=/local.example.org/
+server=/default.example.org/#
+server=/bad.example.org/not-an-ip
+server=10.0.0.9
+no-resolv
+`,
+			map[string][]upstreamAddr{
+				"example.org.":          {{"10.0.0.1:53", "dns"}, {"10.0.0.2:5353", "dns"}},
+				"a.example.net.":        {{"[::1]:53", "dns"}},
+				"b.example.net.":        {{"[::1]:53", "dns"}},
+				"internal.example.org.": {},
+				"local.example.org.":    {},
+				"default.example.org.":  {defaultUpstream},
+			},
+		},
+		{
+			formatCSV,
+			`example.org,10.0.0.1, tls://10.0.0.2
+example.net.,[::1]:5353
+internal.example.org
+bad.example.org,https://10.0.0.3
+`,
+			map[string][]upstreamAddr{
+				"example.org.":          {{"10.0.0.1:53", "dns"}, {"10.0.0.2:853", "tls"}},
+				"example.net.":          {{"[::1]:5353", "dns"}},
+				"internal.example.org.": {},
+			},
+		},
+	}
+
+	for i, tc := range tests {
+		domains, err := parseTable(strings.NewReader(tc.input), tc.format)
+		if err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		if !reflect.DeepEqual(domains, tc.expected) {
+			t.Errorf("Test %d: expected %v, got %v", i, tc.expected, domains)
+		}
+	}
+}
+
+func TestSetupTable(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "table")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input          string
+		shouldErr      bool
+		expectedFormat string
+		expectedErr    string
+	}{
+		{"forward . 127.0.0.1 {\ntable " + file + "\n}\n", false, formatDnsmasq, ""},
+		{"forward . 127.0.0.1 {\ntable " + file + " csv\ntable_reload 0s\n}\n", false, formatCSV, ""},
+		// negative
+		{"forward . 127.0.0.1 {\ntable\n}\n", true, "", "Wrong argument count"},
+		{"forward . 127.0.0.1 {\ntable " + file + " json\n}\n", true, "", "unknown table format 'json'"},
+		{"forward . 127.0.0.1 {\ntable /does/not/exist\n}\n", true, "", "no such file"},
+		{"forward . 127.0.0.1 {\ntable_reload 5s\n}\n", true, "", "table_reload requires a table"},
+		{"forward . 127.0.0.1 {\ntable " + file + "\ntable_reload -1s\n}\n", true, "", "can't be negative"},
+	}
+
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		fs, err := parseForward(c)
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error, got none", i)
+			} else if !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("Test %d: expected error to contain %q, got %q", i, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		if fs[0].table.format != tc.expectedFormat {
+			t.Errorf("Test %d: expected format %s, got %s", i, tc.expectedFormat, fs[0].table.format)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	// dnstest servers share a handler, so answer with the port the query was received on.
+	handler := func(w dns.ResponseWriter, r *dns.Msg) {
+		_, port, _ := net.SplitHostPort(w.LocalAddr().String())
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		ret.Answer = append(ret.Answer, test.TXT(r.Question[0].Name+" 5 IN TXT "+port))
+		w.WriteMsg(ret)
+	}
+	def, a, b := dnstest.NewServer(handler), dnstest.NewServer(handler), dnstest.NewServer(handler)
+	defer def.Close()
+	defer a.Close()
+	defer b.Close()
+	names := map[string]string{}
+	for name, s := range map[string]*dnstest.Server{"default": def, "a": a, "b": b} {
+		_, port, _ := net.SplitHostPort(s.Addr)
+		names[port] = name
+	}
+
+	file := filepath.Join(t.TempDir(), "table")
+	content := "example.org," + a.Addr + "\nsub.example.org," + b.Addr + "\nexample.net," + a.Addr + "\nlocal.example.org\ndefault.sub.example.org,#\n"
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := caddy.NewTestController("dns", "forward . "+def.Addr+" {\ntable "+file+" csv\ntable_reload 0s\n}\n")
+	fs, err := parseForward(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := fs[0]
+	f.Next = test.NextHandler(dns.RcodeRefused, nil)
+	f.OnStartup()
+	defer f.OnShutdown()
+
+	if f.table.Len() != 5 {
+		t.Errorf("Expected 5 entries, got %d", f.table.Len())
+	}
+	if len(f.table.proxies) != 2 {
+		t.Errorf("Expected entries to share 2 proxies, got %d", len(f.table.proxies))
+	}
+
+	tests := []struct {
+		qname    string
+		expected string
+		rcode    int
+	}{
+		{"www.example.org.", "a", dns.RcodeSuccess},
+		{"example.org.", "a", dns.RcodeSuccess},
+		{"www.sub.example.org.", "b", dns.RcodeSuccess},
+		{"WWW.Example.NET.", "a", dns.RcodeSuccess},
+		{"www.example.com.", "default", dns.RcodeSuccess},
+		{"notexample.org.", "default", dns.RcodeSuccess},
+		{"www.local.example.org.", "", dns.RcodeRefused},
+		{"www.default.sub.example.org.", "default", dns.RcodeSuccess},
+	}
+
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, dns.TypeTXT)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+
+		rcode, _ := f.ServeDNS(context.TODO(), rec, m)
+		if tc.expected == "" {
+			if rcode != tc.rcode {
+				t.Errorf("Test %d: expected rcode %d, got %d", i, tc.rcode, rcode)
+			}
+			continue
+		}
+		if rec.Msg == nil || len(rec.Msg.Answer) != 1 {
+			t.Fatalf("Test %d: expected one answer, got %v", i, rec.Msg)
+		}
+		if txt := names[rec.Msg.Answer[0].(*dns.TXT).Txt[0]]; txt != tc.expected {
+			t.Errorf("Test %d: expected to be forwarded to %q, got %q", i, tc.expected, txt)
+		}
+	}
+}
+
+func TestTableDiscovery(t *testing.T) {
+	defer func(d time.Duration) { drainTimeout = d }(drainTimeout)
+	drainTimeout = 0
+
+	var mu sync.Mutex
+	addr := "127.0.0.1"
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		mu.Lock()
+		if r.Question[0].Qtype == dns.TypeA && r.Question[0].Name == "resolver.example.org." {
+			ret.Answer = append(ret.Answer, test.A("resolver.example.org. 30 IN A "+addr))
+		}
+		mu.Unlock()
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+	_, port, _ := net.SplitHostPort(s.Addr)
+
+	// The table uses the address that is also found by discovery.
+	file := filepath.Join(t.TempDir(), "table")
+	if err := os.WriteFile(file, []byte("example.org,127.0.0.1:"+port+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := caddy.NewTestController("dns", "forward . resolver.example.org.:"+port+" {\ndiscovery_resolver "+s.Addr+
+		"\ndiscovery_refresh 1m 1m\ntable "+file+" csv\ntable_reload 0s\n}\n")
+	fs, err := parseForward(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := fs[0]
+	f.OnStartup()
+	defer f.OnShutdown()
+
+	discovered := f.Proxies()
+	if len(discovered) != 1 || discovered[0].addr != "127.0.0.1:"+port {
+		t.Fatalf("Expected one discovered proxy for 127.0.0.1:%s, got %v", port, proxyAddrs(f))
+	}
+	proxies, _ := f.table.lookup("example.org.")
+	if len(proxies) != 1 || proxies[0] == discovered[0] {
+		t.Fatalf("Expected the table to have a proxy of its own, got %v", proxies)
+	}
+
+	// Discovery drops the address and stops its proxy, the one of the table keeps running.
+	mu.Lock()
+	addr = "127.0.0.2"
+	mu.Unlock()
+	f.discovery.refresh(f)
+	time.Sleep(50 * time.Millisecond)
+
+	select {
+	case <-discovered[0].transport.stop:
+	default:
+		t.Errorf("Expected the dropped proxy of discovery to be stopped")
+	}
+	select {
+	case <-proxies[0].transport.stop:
+		t.Errorf("Expected the proxy of the table not to be stopped")
+	default:
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Errorf("Expected the table domain to be forwarded, got %s", err)
+	}
+}
@@ -1,6 +1,7 @@
 // Package cache implements a cache. The cache hold 256 shards, each shard
 // holds a cache: a map with a mutex. There is no fancy expunge algorithm, it
-// just randomly evicts elements when it gets full.
+// just randomly evicts elements when it gets full. A cache created with
+// NewBounded is also limited in bytes, and evicts with a segmented LRU.
 package cache
 
 import (
@@ -20,10 +21,11 @@ type Cache struct {
 	shards [shardSize]*shard
 }
 
-// shard is a cache with random eviction.
+// shard is a cache with random eviction, or segmented LRU eviction if lru is set.
 type shard struct {
 	items map[uint64]interface{}
 	size  int
+	lru   *slru
 
 	sync.RWMutex
 }
@@ -44,6 +46,27 @@ func New(size int) *Cache {
 	return c
 }
 
+// NewBounded returns a new cache that holds at most size elements and maxBytes bytes, as measured by cost.
+// The least recently used elements are evicted first, elements that have been looked up more than once are
+// kept longer than the ones that haven't.
+func NewBounded(size int, maxBytes int64, cost func(interface{}) int) *Cache {
+	c := New(size)
+	sbytes := maxBytes / shardSize
+	for i := 0; i < shardSize; i++ {
+		c.shards[i].lru = newSLRU(sbytes, cost)
+	}
+	return c
+}
+
+// Bytes returns the number of bytes used by the elements in the cache, or 0 if it isn't bounded in bytes.
+func (c *Cache) Bytes() int64 {
+	b := int64(0)
+	for _, s := range &c.shards {
+		b += s.Bytes()
+	}
+	return b
+}
+
 // Add adds a new element to the cache. If the element already exists it is overwritten.
 // Returns true if an existing element was evicted to make room for this element.
 func (c *Cache) Add(key uint64, el interface{}) bool {
@@ -85,6 +108,9 @@ func newShard(size int) *shard { return &shard{items: make(map[uint64]interface{
 // Add adds element indexed by key into the cache. Any existing element is overwritten
 // Returns true if an existing element was evicted to make room for this element.
 func (s *shard) Add(key uint64, el interface{}) bool {
+	if s.lru != nil {
+		return s.addLRU(key, el)
+	}
 	eviction := false
 	s.Lock()
 	if len(s.items) >= s.size {
@@ -101,25 +127,67 @@ func (s *shard) Add(key uint64, el interface{}) bool {
 	return eviction
 }
 
+// addLRU adds element indexed by key, and evicts elements until the shard is within its limits again.
+func (s *shard) addLRU(key uint64, el interface{}) bool {
+	eviction := false
+	s.Lock()
+	s.items[key] = el
+	s.lru.add(key, el)
+	for len(s.items) > s.size || s.lru.bytes > s.lru.max {
+		k, ok := s.lru.victim(key)
+		if !ok {
+			break
+		}
+		delete(s.items, k)
+		s.lru.remove(k)
+		eviction = true
+	}
+	s.Unlock()
+	return eviction
+}
+
 // Remove removes the element indexed by key from the cache.
 func (s *shard) Remove(key uint64) {
 	s.Lock()
 	delete(s.items, key)
+	if s.lru != nil {
+		s.lru.remove(key)
+	}
 	s.Unlock()
 }
 
-// Evict removes a random element from the cache.
+// Evict removes a random element from the cache, or the least recently used one.
 func (s *shard) Evict() {
 	s.Lock()
+	defer s.Unlock()
+	if s.lru != nil {
+		if k, ok := s.lru.oldest(); ok {
+			delete(s.items, k)
+			s.lru.remove(k)
+		}
+		return
+	}
 	for k := range s.items {
 		delete(s.items, k)
 		break
 	}
-	s.Unlock()
 }
 
 // Get looks up the element indexed under key.
 func (s *shard) Get(key uint64) (interface{}, bool) {
+	if s.lru != nil {
+		s.RLock()
+		el, found := s.items[key]
+		promote := found && s.lru.onProbation(key)
+		s.RUnlock()
+		// Only a hit on an element in probation updates the segments, which needs the write lock.
+		if promote {
+			s.Lock()
+			s.lru.hit(key)
+			s.Unlock()
+		}
+		return el, found
+	}
 	s.RLock()
 	el, found := s.items[key]
 	s.RUnlock()
@@ -134,6 +202,17 @@ func (s *shard) Len() int {
 	return l
 }
 
+// Bytes returns the number of bytes used by the elements in the shard.
+func (s *shard) Bytes() int64 {
+	if s.lru == nil {
+		return 0
+	}
+	s.RLock()
+	b := s.lru.bytes
+	s.RUnlock()
+	return b
+}
+
 // Walk walks the shard for each element the function f is executed while holding a write lock.
 func (s *shard) Walk(f func(map[uint64]interface{}, uint64) bool) {
 	s.RLock()
@@ -147,6 +226,14 @@ func (s *shard) Walk(f func(map[uint64]interface{}, uint64) bool) {
 	for _, k := range items {
 		s.Lock()
 		ok := f(s.items, k)
+		if s.lru != nil {
+			// f may have removed or replaced the element.
+			if el, found := s.items[k]; found {
+				s.lru.resize(k, el)
+			} else {
+				s.lru.remove(k)
+			}
+		}
 		s.Unlock()
 		if !ok {
 			return
@@ -0,0 +1,143 @@
+package cache
+
+import "container/list"
+
+// slru is a segmented LRU that keeps the items of a shard within a budget in bytes. New items enter the
+// probation segment, items that are hit again move to the protected segment. Items are evicted from the tail
+// of the probation segment first, so a scan of items that are used only once doesn't push out the items that
+// are used repeatedly. Hits on protected items don't change their order, so they only need a read lock; the
+// protected items that were promoted the longest ago are demoted first, and promoted again on their next hit.
+type slru struct {
+	cost  func(interface{}) int // size of an item in bytes
+	max   int64                 // budget in bytes
+	bytes int64                 // bytes used by all items
+
+	probation list.List
+	protected list.List
+	pbytes    int64 // bytes used by items in the protected segment
+
+	elems map[uint64]*list.Element
+}
+
+type slruEntry struct {
+	key       uint64
+	cost      int64
+	protected bool
+}
+
+func newSLRU(max int64, cost func(interface{}) int) *slru {
+	return &slru{cost: cost, max: max, elems: make(map[uint64]*list.Element)}
+}
+
+// add records that el was added under key. The caller has already added it to items.
+func (l *slru) add(key uint64, el interface{}) {
+	cost := int64(l.cost(el))
+	if e, ok := l.elems[key]; ok {
+		en := e.Value.(*slruEntry)
+		l.bytes += cost - en.cost
+		if en.protected {
+			l.pbytes += cost - en.cost
+			l.protected.MoveToFront(e)
+		} else {
+			l.probation.MoveToFront(e)
+		}
+		en.cost = cost
+		l.rebalance()
+		return
+	}
+	l.elems[key] = l.probation.PushFront(&slruEntry{key: key, cost: cost})
+	l.bytes += cost
+}
+
+// resize updates the size of the item under key to that of el, without changing how recently it was used.
+func (l *slru) resize(key uint64, el interface{}) {
+	e, ok := l.elems[key]
+	if !ok {
+		return
+	}
+	en := e.Value.(*slruEntry)
+	cost := int64(l.cost(el))
+	l.bytes += cost - en.cost
+	if en.protected {
+		l.pbytes += cost - en.cost
+	}
+	en.cost = cost
+}
+
+// oldest returns the key of the least recently used item, preferring the probation segment. It returns false
+// if there are no items.
+func (l *slru) oldest() (uint64, bool) {
+	for _, segment := range []*list.List{&l.probation, &l.protected} {
+		if e := segment.Back(); e != nil {
+			return e.Value.(*slruEntry).key, true
+		}
+	}
+	return 0, false
+}
+
+// onProbation returns true if the item under key is in the probation segment.
+func (l *slru) onProbation(key uint64) bool {
+	e, ok := l.elems[key]
+	return ok && !e.Value.(*slruEntry).protected
+}
+
+// hit records that the item under key was used, which moves it from the probation to the protected segment.
+func (l *slru) hit(key uint64) {
+	e, ok := l.elems[key]
+	if !ok {
+		return
+	}
+	en := e.Value.(*slruEntry)
+	if en.protected {
+		return
+	}
+	l.probation.Remove(e)
+	en.protected = true
+	l.pbytes += en.cost
+	l.elems[key] = l.protected.PushFront(en)
+	l.rebalance()
+}
+
+// rebalance demotes the least recently used protected items to the probation segment until the protected
+// segment is within its share of the budget.
+func (l *slru) rebalance() {
+	for l.pbytes > l.max*protectedPercentage/100 && l.protected.Len() > 1 {
+		e := l.protected.Back()
+		en := l.protected.Remove(e).(*slruEntry)
+		en.protected = false
+		l.pbytes -= en.cost
+		l.elems[en.key] = l.probation.PushFront(en)
+	}
+}
+
+// remove forgets the item under key. The caller removes it from items.
+func (l *slru) remove(key uint64) {
+	e, ok := l.elems[key]
+	if !ok {
+		return
+	}
+	delete(l.elems, key)
+	en := e.Value.(*slruEntry)
+	l.bytes -= en.cost
+	if en.protected {
+		l.pbytes -= en.cost
+		l.protected.Remove(e)
+		return
+	}
+	l.probation.Remove(e)
+}
+
+// victim returns the key of the item to evict next, other than keep. It returns false if there is none.
+func (l *slru) victim(keep uint64) (uint64, bool) {
+	for _, segment := range []*list.List{&l.probation, &l.protected} {
+		for e := segment.Back(); e != nil; e = e.Prev() {
+			if en := e.Value.(*slruEntry); en.key != keep {
+				return en.key, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// protectedPercentage is the share of the budget for the protected segment.
+const protectedPercentage = 80
@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func cost(el interface{}) int { return el.(int) }
+
+func TestBoundedBytes(t *testing.T) {
+	// 256 shards of 100 bytes each, use a single shard by adding keys that are multiples of shardSize.
+	c := NewBounded(shardSize*100, shardSize*100, cost)
+	for i := uint64(0); i < 10; i++ {
+		c.Add(i*shardSize, 10)
+	}
+	if b := c.Bytes(); b != 100 {
+		t.Fatalf("Expected 100 bytes, got %d", b)
+	}
+
+	// One more evicts the oldest.
+	if !c.Add(10*shardSize, 10) {
+		t.Errorf("Expected an eviction")
+	}
+	if _, found := c.Get(0); found {
+		t.Errorf("Expected the oldest element to be evicted")
+	}
+
+	// A large element evicts as many as needed.
+	c.Add(11*shardSize, 50)
+	if b := c.Bytes(); b > 100 {
+		t.Errorf("Expected at most 100 bytes, got %d", b)
+	}
+	if l := c.Len(); l != 6 {
+		t.Errorf("Expected 6 elements, got %d", l)
+	}
+
+	c.Remove(11 * shardSize)
+	if b := c.Bytes(); b != 50 {
+		t.Errorf("Expected 50 bytes after removing, got %d", b)
+	}
+}
+
+func TestBoundedScanResistance(t *testing.T) {
+	c := NewBounded(shardSize*10, shardSize*100, cost)
+	hot := []uint64{0, shardSize, 2 * shardSize}
+	for _, k := range hot {
+		c.Add(k, 10)
+		c.Get(k) // a second use protects them
+	}
+
+	// A scan of elements that are only used once.
+	for i := uint64(3); i < 100; i++ {
+		c.Add(i*shardSize, 10)
+	}
+	for _, k := range hot {
+		if _, found := c.Get(k); !found {
+			t.Errorf("Expected hot element %d to survive the scan", k)
+		}
+	}
+}
+
+func TestBoundedWalk(t *testing.T) {
+	c := NewBounded(shardSize*10, shardSize*100, cost)
+	for i := uint64(0); i < 5; i++ {
+		c.Add(i*shardSize, 10)
+	}
+	c.Walk(func(items map[uint64]interface{}, key uint64) bool {
+		if key == 0 {
+			delete(items, key)
+		} else {
+			items[key] = 20
+		}
+		return true
+	})
+	if b := c.Bytes(); b != 80 {
+		t.Errorf("Expected 80 bytes after walking, got %d", b)
+	}
+}
+
+// workload returns n keys: a Zipf distributed hot set, with every other key a one-off scan.
+func workload(n int) []uint64 {
+	r := rand.New(rand.NewSource(1))
+	z := rand.NewZipf(r, 1.1, 1, 1<<16)
+	keys := make([]uint64, n)
+	scan := uint64(1 << 32)
+	for i := range keys {
+		if i%2 == 0 {
+			keys[i] = z.Uint64()
+			continue
+		}
+		keys[i] = scan
+		scan++
+	}
+	return keys
+}
+
+func benchmarkHitRatio(b *testing.B, c *Cache) {
+	keys := workload(1 << 18)
+	b.ReportAllocs()
+	b.ResetTimer()
+	hits, lookups := 0, 0
+	for n := 0; n < b.N; n++ {
+		k := keys[n%len(keys)]
+		lookups++
+		if _, found := c.Get(k); found {
+			hits++
+			continue
+		}
+		c.Add(k, 100)
+	}
+	b.ReportMetric(100*float64(hits)/float64(lookups), "hit%")
+}
+
+func BenchmarkHitRatioRandom(b *testing.B) {
+	benchmarkHitRatio(b, New(10000))
+}
+
+func BenchmarkHitRatioBounded(b *testing.B) {
+	benchmarkHitRatio(b, NewBounded(10000, 10000*100, cost))
+}
+
+func BenchmarkBounded(b *testing.B) {
+	b.ReportAllocs()
+
+	c := NewBounded(4, 1024, cost)
+	for n := 0; n < b.N; n++ {
+		c.Add(1, 1)
+		c.Get(1)
+	}
+}
+
+func benchmarkGetParallel(b *testing.B, c *Cache) {
+	keys := workload(1 << 16)
+	for _, k := range keys {
+		c.Add(k, 100)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			c.Get(keys[i%len(keys)])
+		}
+	})
+}
+
+func BenchmarkGetParallelRandom(b *testing.B) {
+	benchmarkGetParallel(b, New(10000))
+}
+
+func BenchmarkGetParallelBounded(b *testing.B) {
+	benchmarkGetParallel(b, NewBounded(10000, 10000*100, cost))
+}
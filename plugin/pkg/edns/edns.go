@@ -2,7 +2,11 @@
 package edns
 
 import (
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/horahoradev/dns"
@@ -69,3 +73,44 @@ func Size(proto string, size uint16) uint16 {
 	}
 	return size
 }
+
+// OptionData returns the data of the first EDNS0 option with code in req, or nil if there is none. The data is in
+// wire format, so this works for options this package doesn't know about too.
+func OptionData(req *dns.Msg, code uint16) []byte {
+	o := req.IsEdns0()
+	if o == nil {
+		return nil
+	}
+	buf := make([]byte, dns.Len(o))
+	n, err := dns.PackRR(o, buf, 0, nil, false)
+	if err != nil {
+		return nil
+	}
+	// The OPT RR has the root as name, then type, class, TTL and the length of the options.
+	for opts := buf[11:n]; len(opts) >= 4; {
+		c := binary.BigEndian.Uint16(opts)
+		l := int(binary.BigEndian.Uint16(opts[2:]))
+		if len(opts) < 4+l {
+			return nil
+		}
+		if c == code {
+			return opts[4 : 4+l]
+		}
+		opts = opts[4+l:]
+	}
+	return nil
+}
+
+// ParseEDECode parses an Extended DNS Error (RFC 8914) info code by its number, or by its name without spaces, e.g.
+// Blocked or NotAuthoritative.
+func ParseEDECode(s string) (uint16, error) {
+	if code, err := strconv.ParseUint(s, 10, 16); err == nil {
+		return uint16(code), nil
+	}
+	for code, name := range dns.ExtendedErrorCodeToString {
+		if strings.EqualFold(strings.ReplaceAll(name, " ", ""), s) {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid extended DNS error code %q", s)
+}
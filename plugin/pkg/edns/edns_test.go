@@ -38,6 +38,58 @@ func TestVersionNoEdns(t *testing.T) {
 	}
 }
 
+func TestOptionData(t *testing.T) {
+	m := ednsMsg()
+	o := m.IsEdns0()
+	o.Option = append(o.Option,
+		&dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: "abcd"},
+		&dns.EDNS0_LOCAL{Code: 0xffee, Data: []byte("xyz")},
+	)
+
+	tests := []struct {
+		code     uint16
+		expected string
+	}{
+		{dns.EDNS0NSID, "\xab\xcd"},
+		{0xffee, "xyz"},
+		{0xfffe, ""},
+	}
+	for i, tc := range tests {
+		if d := OptionData(m, tc.code); string(d) != tc.expected {
+			t.Errorf("Test %d: expected %q, got %q", i, tc.expected, d)
+		}
+	}
+
+	m.Extra = nil
+	if d := OptionData(m, dns.EDNS0NSID); d != nil {
+		t.Errorf("Expected no data without EDNS0, got %q", d)
+	}
+}
+
+func TestParseEDECode(t *testing.T) {
+	tests := []struct {
+		in        string
+		expected  uint16
+		shouldErr bool
+	}{
+		{"15", dns.ExtendedErrorCodeBlocked, false},
+		{"Blocked", dns.ExtendedErrorCodeBlocked, false},
+		{"notauthoritative", dns.ExtendedErrorCodeNotAuthoritative, false},
+		{"NoSuchCode", 0, true},
+		{"70000", 0, true},
+	}
+	for i, tc := range tests {
+		code, err := ParseEDECode(tc.in)
+		if (err != nil) != tc.shouldErr {
+			t.Errorf("Test %d: expected error %t, got %v", i, tc.shouldErr, err)
+			continue
+		}
+		if code != tc.expected {
+			t.Errorf("Test %d: expected %d, got %d", i, tc.expected, code)
+		}
+	}
+}
+
 func ednsMsg() *dns.Msg {
 	m := new(dns.Msg)
 	m.SetQuestion("example.com.", dns.TypeA)
@@ -2,11 +2,18 @@ package expression
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"net"
+	"strings"
+	"time"
 
+	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/pkg/edns"
 	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
 )
 
 // DefaultEnv returns the default set of custom state variables and functions available to for use in expression evaluation.
@@ -23,25 +30,89 @@ func DefaultEnv(ctx context.Context, state *request.Request) map[string]interfac
 			}
 			return cidr.Contains(ip), nil
 		},
-		"metadata": func(label string) string {
-			f := metadata.ValueFunc(ctx, label)
-			if f == nil {
+		"metadata": func(label string) string { return metadataValue(ctx, label) },
+		// client_group returns the group of the client, as published by the clients plugin.
+		"client_group": func() string { return metadataValue(ctx, "clients/group") },
+		"type":         state.Type,
+		"name":         state.Name,
+		"class":        state.Class,
+		"proto":        state.Proto,
+		"size":         state.Len,
+		"client_ip":    state.IP,
+		"port":         state.Port,
+		"id":           func() int { return int(state.Req.Id) },
+		"opcode":       func() int { return state.Req.Opcode },
+		"do":           state.Do,
+		"bufsize":      state.Size,
+		"server_ip":    state.LocalIP,
+		"server_port":  state.LocalPort,
+		// tsig returns the TSIG key of the request, only if the server verified its signature.
+		"tsig": func() string {
+			name, _ := ctx.Value(dnsserver.TsigKey{}).(string)
+			return name
+		},
+		"edns_option": func(code int) string {
+			return hex.EncodeToString(edns.OptionData(state.Req, uint16(code)))
+		},
+		"ecs_ip": func() string {
+			if ip := ecsAddress(state.Req); ip != nil {
+				return ip.String()
+			}
+			return ""
+		},
+		"label": func(n int) string {
+			labels := dns.SplitDomainName(state.Name())
+			if n < 0 {
+				n += len(labels)
+			}
+			if n < 0 || n >= len(labels) {
 				return ""
 			}
-			return f()
+			return labels[n]
+		},
+		"name_suffix": func(suffix string) bool {
+			return dns.IsSubDomain(dns.Fqdn(strings.ToLower(suffix)), state.Name())
 		},
-		"type":        state.Type,
-		"name":        state.Name,
-		"class":       state.Class,
-		"proto":       state.Proto,
-		"size":        state.Len,
-		"client_ip":   state.IP,
-		"port":        state.Port,
-		"id":          func() int { return int(state.Req.Id) },
-		"opcode":      func() int { return state.Req.Opcode },
-		"do":          state.Do,
-		"bufsize":     state.Size,
-		"server_ip":   state.LocalIP,
-		"server_port": state.LocalPort,
+		"geoip":   func(field string) string { return metadataValue(ctx, "geoip/"+field) },
+		"hour":    func() int { return now().Hour() },
+		"minute":  func() int { return now().Minute() },
+		"weekday": func() string { return now().Weekday().String() },
+	}
+}
+
+// SplitCondition splits args at the "if" keyword, into the arguments before it and those of the expression after it.
+// The expression is nil if there is no "if".
+func SplitCondition(args []string) ([]string, []string) {
+	for i, arg := range args {
+		if strings.ToLower(arg) == "if" {
+			return args[:i], args[i+1:]
+		}
+	}
+	return args, nil
+}
+
+// ecsAddress returns the address in the EDNS0 subnet option of r, or nil if there is none.
+func ecsAddress(r *dns.Msg) net.IP {
+	o := r.IsEdns0()
+	if o == nil {
+		return nil
+	}
+	for _, s := range o.Option {
+		if e, ok := s.(*dns.EDNS0_SUBNET); ok {
+			return e.Address
+		}
 	}
+	return nil
 }
+
+// metadataValue returns the value of the metadata with label, or an empty string if it isn't set.
+func metadataValue(ctx context.Context, label string) string {
+	f := metadata.ValueFunc(ctx, label)
+	if f == nil {
+		return ""
+	}
+	return f()
+}
+
+// now returns the current time, it is replaced in tests.
+var now = time.Now
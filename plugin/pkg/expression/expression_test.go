@@ -2,10 +2,18 @@ package expression
 
 import (
 	"context"
+	"net"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/test"
 	"github.com/coredns/coredns/request"
+
+	"github.com/antonmedv/expr"
+	"github.com/horahoradev/dns"
 )
 
 func TestInCidr(t *testing.T) {
@@ -71,3 +79,222 @@ func TestMetadata(t *testing.T) {
 		}
 	}
 }
+
+func TestClientGroup(t *testing.T) {
+	ctx := metadata.ContextWithMetadata(context.Background())
+	f := DefaultEnv(ctx, &request.Request{})["client_group"].(func() string)
+	if g := f(); g != "" {
+		t.Errorf("Expected no group, got %q", g)
+	}
+	metadata.SetValueFunc(ctx, "clients/group", func() string { return "office" })
+	if g := f(); g != "office" {
+		t.Errorf("Expected group office, got %q", g)
+	}
+}
+
+func TestEDNSOption(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("example.org.", dns.TypeA)
+	f := DefaultEnv(context.Background(), &request.Request{Req: r})["edns_option"].(func(int) string)
+	if v := f(0xffee); v != "" {
+		t.Errorf("Expected no option without EDNS0, got %q", v)
+	}
+
+	r.SetEdns0(4096, false)
+	o := r.IsEdns0()
+	o.Option = append(o.Option,
+		&dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: "abcd"},
+		&dns.EDNS0_LOCAL{Code: 0xffee, Data: []byte("xyz")},
+	)
+
+	cases := []struct {
+		code     int
+		expected string
+	}{
+		{dns.EDNS0NSID, "abcd"},
+		{0xffee, "78797a"},
+		{0xfffe, ""},
+	}
+	for i, c := range cases {
+		if v := f(c.code); v != c.expected {
+			t.Errorf("Test %d: expected %q, got %q", i, c.expected, v)
+		}
+	}
+}
+
+func TestTsig(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("example.org.", dns.TypeA)
+	f := DefaultEnv(context.Background(), &request.Request{W: &test.ResponseWriter{}, Req: r})["tsig"].(func() string)
+	if v := f(); v != "" {
+		t.Errorf("Expected no key name without TSIG, got %q", v)
+	}
+	// Without secrets the signature isn't verified, and TsigStatus of the writer returns nil.
+	r.SetTsig("key.example.org.", dns.HmacSHA256, 300, 0)
+	if v := f(); v != "" {
+		t.Errorf("Expected no key name for an unverified TSIG, got %q", v)
+	}
+
+	ctx := context.WithValue(context.Background(), dnsserver.TsigKey{}, "key.example.org.")
+	f = DefaultEnv(ctx, &request.Request{W: &test.ResponseWriter{}, Req: r})["tsig"].(func() string)
+	if v := f(); v != "key.example.org." {
+		t.Errorf("Expected the key name, got %q", v)
+	}
+}
+
+func TestTime(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	now = func() time.Time { return time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC) }
+
+	env := DefaultEnv(context.Background(), &request.Request{})
+	if h := env["hour"].(func() int)(); h != 15 {
+		t.Errorf("Expected hour 15, got %d", h)
+	}
+	if m := env["minute"].(func() int)(); m != 4 {
+		t.Errorf("Expected minute 4, got %d", m)
+	}
+	if d := env["weekday"].(func() string)(); d != "Monday" {
+		t.Errorf("Expected Monday, got %s", d)
+	}
+}
+
+func TestECS(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("example.org.", dns.TypeA)
+	f := DefaultEnv(context.Background(), &request.Request{Req: r})["ecs_ip"].(func() string)
+	if v := f(); v != "" {
+		t.Errorf("Expected no address without EDNS0, got %q", v)
+	}
+	r.SetEdns0(4096, false)
+	o := r.IsEdns0()
+	o.Option = append(o.Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 2, SourceNetmask: 56, Address: net.ParseIP("2001:db8::")})
+	if v := f(); v != "2001:db8::" {
+		t.Errorf("Expected 2001:db8::, got %q", v)
+	}
+}
+
+func TestLabel(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("www.Example.org.", dns.TypeA)
+	f := DefaultEnv(context.Background(), &request.Request{Req: r})["label"].(func(int) string)
+
+	cases := []struct {
+		n        int
+		expected string
+	}{
+		{0, "www"},
+		{1, "example"},
+		{2, "org"},
+		{3, ""},
+		{-1, "org"},
+		{-3, "www"},
+		{-4, ""},
+	}
+	for i, c := range cases {
+		if v := f(c.n); v != c.expected {
+			t.Errorf("Test %d: expected %q, got %q", i, c.expected, v)
+		}
+	}
+}
+
+func TestNameSuffix(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("www.example.org.", dns.TypeA)
+	f := DefaultEnv(context.Background(), &request.Request{Req: r})["name_suffix"].(func(string) bool)
+
+	cases := []struct {
+		suffix   string
+		expected bool
+	}{
+		{"example.org.", true},
+		{"Example.ORG", true},
+		{"www.example.org", true},
+		{"org", true},
+		{".", true},
+		{"ample.org", false},
+		{"example.net", false},
+	}
+	for i, c := range cases {
+		if v := f(c.suffix); v != c.expected {
+			t.Errorf("Test %d: expected %t, got %t", i, c.expected, v)
+		}
+	}
+}
+
+func TestGeoip(t *testing.T) {
+	ctx := metadata.ContextWithMetadata(context.Background())
+	metadata.SetValueFunc(ctx, "geoip/country/code", func() string { return "NL" })
+	f := DefaultEnv(ctx, &request.Request{})["geoip"].(func(string) string)
+	if v := f("country/code"); v != "NL" {
+		t.Errorf("Expected NL, got %q", v)
+	}
+	if v := f("city/name"); v != "" {
+		t.Errorf("Expected no city, got %q", v)
+	}
+}
+
+// benchmarkExpression is a typical expression, compiled once like the plugins that use this package do.
+const benchmarkExpression = `incidr(client_ip(), '10.240.0.0/16') && type() in ['A', 'AAAA'] && name() matches '^www\\.' && name_suffix('example.org')`
+
+func benchmarkRequest() *request.Request {
+	r := new(dns.Msg)
+	r.SetQuestion("www.example.org.", dns.TypeA)
+	r.SetEdns0(4096, false)
+	return &request.Request{W: &test.ResponseWriter{}, Req: r}
+}
+
+func TestBenchmarkExpression(t *testing.T) {
+	prog, err := expr.Compile(benchmarkExpression, expr.Env(DefaultEnv(context.Background(), nil)))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	result, err := expr.Run(prog, DefaultEnv(context.Background(), benchmarkRequest()))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if b, ok := result.(bool); !ok || !b {
+		t.Errorf("Expected true, got %v", result)
+	}
+}
+
+// BenchmarkDefaultEnv measures the cost of creating the environment, which is done once per request.
+func BenchmarkDefaultEnv(b *testing.B) {
+	ctx := context.Background()
+	state := benchmarkRequest()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		DefaultEnv(ctx, state)
+	}
+}
+
+// BenchmarkRun measures the cost of evaluating a compiled expression.
+func BenchmarkRun(b *testing.B) {
+	prog, err := expr.Compile(benchmarkExpression, expr.Env(DefaultEnv(context.Background(), nil)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	env := DefaultEnv(context.Background(), benchmarkRequest())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		expr.Run(prog, env)
+	}
+}
+
+func TestSplitCondition(t *testing.T) {
+	tests := []struct {
+		args         []string
+		rule, cond   string
+		hasCondition bool
+	}{
+		{[]string{"block", "if", "type()", "==", "'A'"}, "block", "type() == 'A'", true},
+		{[]string{"block", "IF"}, "block", "", true},
+		{[]string{"block", "ede", "15"}, "block ede 15", "", false},
+	}
+	for i, tc := range tests {
+		rule, cond := SplitCondition(tc.args)
+		if strings.Join(rule, " ") != tc.rule || strings.Join(cond, " ") != tc.cond || (cond != nil) != tc.hasCondition {
+			t.Errorf("Test %d: expected %q and %q, got %q and %q", i, tc.rule, tc.cond, rule, cond)
+		}
+	}
+}
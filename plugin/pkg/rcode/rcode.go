@@ -1,7 +1,9 @@
 package rcode
 
 import (
+	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/horahoradev/dns"
 )
@@ -13,3 +15,19 @@ func ToString(rcode int) string {
 	}
 	return "RCODE" + strconv.Itoa(rcode)
 }
+
+// Parse parses a response code by its name, e.g. NXDOMAIN, or by its number. Extended response codes, above 15,
+// are rejected: they need an OPT record, which a reply to a client that doesn't use EDNS can't have.
+func Parse(s string) (int, error) {
+	rcode, ok := dns.StringToRcode[strings.ToUpper(s)]
+	if !ok {
+		var err error
+		if rcode, err = strconv.Atoi(s); err != nil || rcode < 0 {
+			return 0, fmt.Errorf("invalid rcode %q", s)
+		}
+	}
+	if rcode > 0xF {
+		return 0, fmt.Errorf("extended rcode %q is not supported", s)
+	}
+	return rcode, nil
+}
@@ -27,3 +27,37 @@ func TestToString(t *testing.T) {
 		}
 	}
 }
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in        string
+		expected  int
+		shouldErr bool
+	}{
+		{"NXDOMAIN", dns.RcodeNameError, false},
+		{"refused", dns.RcodeRefused, false},
+		{"3", dns.RcodeNameError, false},
+		{"15", 15, false},
+		// fails
+		{"16", 0, true},
+		{"BADCOOKIE", 0, true},
+		{"-1", 0, true},
+		{"NOSUCHCODE", 0, true},
+	}
+	for i, test := range tests {
+		got, err := Parse(test.in)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected an error for %q, got %d", i, test.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error for %q, got %s", i, test.in, err)
+			continue
+		}
+		if got != test.expected {
+			t.Errorf("Test %d: expected %d, got %d", i, test.expected, got)
+		}
+	}
+}
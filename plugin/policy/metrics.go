@@ -0,0 +1,18 @@
+package policy
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// RuleHitCount is the number of requests a rule applied to.
+	RuleHitCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "policy",
+		Name:      "rule_hits_total",
+		Help:      "Counter of requests a policy rule applied to.",
+	}, []string{"server", "zone", "rule", "action", "view"})
+)
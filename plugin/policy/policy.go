@@ -0,0 +1,116 @@
+// Package policy implements a plugin that applies access policies written as expressions.
+package policy
+
+import (
+	"context"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/plugin/pkg/expression"
+	"github.com/coredns/coredns/request"
+
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/vm"
+	"github.com/horahoradev/dns"
+)
+
+// Policy applies the action of the first rule whose expression is true to a request.
+type Policy struct {
+	Next  plugin.Handler
+	Zones []string
+
+	rules []rule
+}
+
+// rule is an action with the expression that selects the requests it applies to.
+type rule struct {
+	name   string // label in the metrics
+	action action
+	rcode  int
+	ede    *dns.EDNS0_EDE // added to the response, if the client uses EDNS
+	cond   *vm.Program
+}
+
+// action defines what is done with a request.
+type action int
+
+const (
+	// actionAllow passes the request to the next plugin.
+	actionAllow action = iota
+	// actionBlock responds with REFUSED.
+	actionBlock
+	// actionFilter responds with an empty NOERROR response.
+	actionFilter
+	// actionDrop doesn't respond.
+	actionDrop
+	// actionRcode responds with the rule's rcode.
+	actionRcode
+)
+
+// ServeDNS implements the plugin.Handler interface.
+func (p *Policy) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+	zone := plugin.Zones(p.Zones).Matches(state.Name())
+	if zone == "" {
+		return plugin.NextOrFailure(p.Name(), p.Next, ctx, w, r)
+	}
+
+	ru := p.match(ctx, &state)
+	if ru == nil {
+		return plugin.NextOrFailure(p.Name(), p.Next, ctx, w, r)
+	}
+	RuleHitCount.WithLabelValues(metrics.WithServer(ctx), zone, ru.name, ru.action.String(), metrics.WithView(ctx)).Inc()
+
+	switch ru.action {
+	case actionAllow:
+		return plugin.NextOrFailure(p.Name(), p.Next, ctx, w, r)
+	case actionDrop:
+		return dns.RcodeSuccess, nil
+	}
+
+	m := new(dns.Msg)
+	m.SetRcode(r, ru.rcode)
+	if state.Req.IsEdns0() != nil {
+		m.SetEdns0(uint16(state.Size()), state.Do())
+		if ru.ede != nil {
+			m.IsEdns0().Option = append(m.IsEdns0().Option, ru.ede)
+		}
+	}
+	w.WriteMsg(m)
+	return dns.RcodeSuccess, nil
+}
+
+// match returns the first rule whose expression is true for the request, or nil if there is none. An expression that
+// fails to evaluate is false.
+func (p *Policy) match(ctx context.Context, state *request.Request) *rule {
+	env := expression.DefaultEnv(ctx, state)
+	for i := range p.rules {
+		result, err := expr.Run(p.rules[i].cond, env)
+		if err != nil {
+			continue
+		}
+		if b, ok := result.(bool); ok && b {
+			return &p.rules[i]
+		}
+	}
+	return nil
+}
+
+// Name implements the plugin.Handler interface.
+func (p *Policy) Name() string { return "policy" }
+
+func (a action) String() string {
+	switch a {
+	case actionAllow:
+		return "allow"
+	case actionBlock:
+		return "block"
+	case actionFilter:
+		return "filter"
+	case actionDrop:
+		return "drop"
+	case actionRcode:
+		return "rcode"
+	}
+	return ""
+}
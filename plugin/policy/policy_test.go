@@ -0,0 +1,120 @@
+package policy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/horahoradev/dns"
+)
+
+// test.ResponseWriter has a remote address of 10.240.0.1.
+const config = `policy example.org {
+	allow if name() == 'www.example.org.'
+	block if type() == 'AXFR'
+	filter ede Prohibited "no games" if name() == 'games.example.org.'
+	drop if type() == 'ANY'
+	rcode NXDOMAIN if incidr(client_ip(), '10.240.0.0/16') && type() == 'TXT'
+	block if name() matches 'ads'
+	rcode REFUSED if name() == 'www.example.org.'
+}`
+
+func TestPolicy(t *testing.T) {
+	p, err := parse(caddy.NewTestController("dns", config))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	p.Next = test.NextHandler(dns.RcodeSuccess, nil)
+
+	tests := []struct {
+		name        string
+		qtype       uint16
+		edns        bool
+		expectRcode int
+		expectEDE   int // -1 means no EDE
+		dropped     bool
+	}{
+		// allow stops the evaluation, so the rcode REFUSED rule doesn't apply
+		{"www.example.org.", dns.TypeA, true, dns.RcodeSuccess, -1, false},
+		{"example.org.", dns.TypeAXFR, true, dns.RcodeRefused, int(dns.ExtendedErrorCodeBlocked), false},
+		{"example.org.", dns.TypeAXFR, false, dns.RcodeRefused, -1, false},
+		{"games.example.org.", dns.TypeA, true, dns.RcodeSuccess, int(dns.ExtendedErrorCodeProhibited), false},
+		{"example.org.", dns.TypeANY, true, 0, -1, true},
+		{"example.org.", dns.TypeTXT, false, dns.RcodeNameError, -1, false},
+		{"ads.example.org.", dns.TypeA, true, dns.RcodeRefused, int(dns.ExtendedErrorCodeBlocked), false},
+		// no rule applies
+		{"example.org.", dns.TypeA, true, dns.RcodeSuccess, -1, false},
+		// not in the zones
+		{"example.net.", dns.TypeAXFR, false, dns.RcodeSuccess, -1, false},
+	}
+
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.name, tc.qtype)
+		if tc.edns {
+			m.SetEdns0(4096, false)
+		}
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		code, err := p.ServeDNS(context.TODO(), rec, m)
+		if err != nil {
+			t.Errorf("Test %d: Expected no error, got %s", i, err)
+			continue
+		}
+		if tc.dropped {
+			if rec.Msg != nil {
+				t.Errorf("Test %d: Expected no response, got %v", i, rec.Msg)
+			}
+			continue
+		}
+		// The next handler doesn't write a response.
+		if rec.Msg == nil {
+			if code != tc.expectRcode {
+				t.Errorf("Test %d: Expected rcode %d, got %d", i, tc.expectRcode, code)
+			}
+			continue
+		}
+		if rec.Msg.Rcode != tc.expectRcode {
+			t.Errorf("Test %d: Expected rcode %d, got %d", i, tc.expectRcode, rec.Msg.Rcode)
+		}
+		ede := -1
+		if o := rec.Msg.IsEdns0(); o != nil {
+			if !tc.edns {
+				t.Errorf("Test %d: Expected no OPT record in the response", i)
+			}
+			for _, opt := range o.Option {
+				if e, ok := opt.(*dns.EDNS0_EDE); ok {
+					ede = int(e.InfoCode)
+				}
+			}
+		}
+		if ede != tc.expectEDE {
+			t.Errorf("Test %d: Expected EDE %d, got %d", i, tc.expectEDE, ede)
+		}
+	}
+}
+
+func TestPolicyEvalError(t *testing.T) {
+	p, err := parse(caddy.NewTestController("dns", `policy {
+		block if incidr(client_ip(), 'bogus')
+		block if name()
+	}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	called := false
+	p.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		called = true
+		return dns.RcodeSuccess, nil
+	})
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	p.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), m)
+	if !called {
+		t.Errorf("Expected rules that fail to evaluate, or aren't boolean, to not apply")
+	}
+}
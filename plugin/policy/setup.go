@@ -0,0 +1,135 @@
+package policy
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/edns"
+	"github.com/coredns/coredns/plugin/pkg/expression"
+	"github.com/coredns/coredns/plugin/pkg/rcode"
+
+	"github.com/antonmedv/expr"
+	"github.com/horahoradev/dns"
+)
+
+const pluginName = "policy"
+
+func init() { plugin.Register(pluginName, setup) }
+
+func setup(c *caddy.Controller) error {
+	p, err := parse(c)
+	if err != nil {
+		return plugin.Error(pluginName, err)
+	}
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		p.Next = next
+		return p
+	})
+
+	return nil
+}
+
+func parse(c *caddy.Controller) (*Policy, error) {
+	p := new(Policy)
+
+	i := 0
+	for c.Next() {
+		i++
+		if i > 1 {
+			return nil, plugin.ErrOnce
+		}
+		p.Zones = plugin.OriginsFromArgsOrServerBlock(c.RemainingArgs(), c.ServerBlockKeys)
+
+		for c.NextBlock() {
+			r, err := parseRule(c, len(p.rules)+1)
+			if err != nil {
+				return nil, err
+			}
+			p.rules = append(p.rules, r)
+		}
+	}
+	if len(p.rules) == 0 {
+		return nil, c.Err("at least one rule is required")
+	}
+	return p, nil
+}
+
+// parseRule parses a rule: ACTION [ARGS...] [as NAME] if EXPRESSION. Rules without a name are labeled with their
+// position in the block, starting at 1.
+func parseRule(c *caddy.Controller, n int) (rule, error) {
+	r := rule{name: strconv.Itoa(n)}
+
+	switch strings.ToLower(c.Val()) {
+	case "allow":
+		r.action = actionAllow
+	case "block":
+		r.action, r.rcode = actionBlock, dns.RcodeRefused
+		r.ede = &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeBlocked}
+	case "filter":
+		r.action, r.rcode = actionFilter, dns.RcodeSuccess
+		r.ede = &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeFiltered}
+	case "drop":
+		r.action = actionDrop
+	case "rcode":
+		r.action = actionRcode
+	default:
+		return r, c.Errf("unexpected token %q; expect 'allow', 'block', 'filter', 'drop' or 'rcode'", c.Val())
+	}
+
+	args, cond := expression.SplitCondition(c.RemainingArgs())
+	if len(cond) == 0 {
+		return r, c.Errf("rule %q must end with 'if EXPRESSION'", c.Val())
+	}
+	prog, err := expr.Compile(strings.Join(cond, " "), expr.Env(expression.DefaultEnv(context.Background(), nil)))
+	if err != nil {
+		return r, err
+	}
+	r.cond = prog
+
+	if r.action == actionRcode {
+		if len(args) == 0 {
+			return r, c.Err("rcode requires a response code")
+		}
+		code, err := rcode.Parse(args[0])
+		if err != nil {
+			return r, c.Err(err.Error())
+		}
+		r.rcode = code
+		args = args[1:]
+	}
+	for len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "as":
+			if len(args) < 2 {
+				return r, c.Err("as requires a name")
+			}
+			r.name = args[1]
+			args = args[2:]
+		case "ede":
+			if r.action == actionAllow || r.action == actionDrop {
+				return r, c.Errf("ede is not allowed for %q", r.action)
+			}
+			if len(args) < 2 {
+				return r, c.Err("ede requires a code")
+			}
+			code, err := edns.ParseEDECode(args[1])
+			if err != nil {
+				return r, c.Err(err.Error())
+			}
+			r.ede = &dns.EDNS0_EDE{InfoCode: code}
+			args = args[2:]
+			if len(args) > 0 && strings.ToLower(args[0]) != "as" {
+				r.ede.ExtraText = args[0]
+				args = args[1:]
+			}
+		default:
+			return r, c.Errf("unexpected token %q; expect 'ede' or 'as'", args[0])
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,108 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/coredns/caddy"
+
+	"github.com/horahoradev/dns"
+)
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		config    string
+		shouldErr bool
+		rules     int
+	}{
+		{`policy {
+			allow if tsig() == 'transfer.'
+			block if type() == 'AXFR'
+		}`, false, 2},
+		{`policy example.org {
+			filter ede Prohibited "no games" as games if name() == 'games.example.org.'
+			rcode NXDOMAIN as ads if name() matches 'ads'
+			rcode 5 ede 15 if proto() == 'tcp'
+			drop if type() == 'ANY'
+		}`, false, 4},
+		// fails
+		{`policy`, true, 0},
+		{`policy {
+			block
+		}`, true, 0},
+		{`policy {
+			block if
+		}`, true, 0},
+		{`policy {
+			block if nosuchfunc()
+		}`, true, 0},
+		{`policy {
+			deny if type() == 'A'
+		}`, true, 0},
+		{`policy {
+			rcode if type() == 'A'
+		}`, true, 0},
+		{`policy {
+			rcode BOGUS if type() == 'A'
+		}`, true, 0},
+		{`policy {
+			rcode 23 if type() == 'A'
+		}`, true, 0},
+		{`policy {
+			allow ede Blocked if type() == 'A'
+		}`, true, 0},
+		{`policy {
+			block ede Bogus if type() == 'A'
+		}`, true, 0},
+		{`policy {
+			block as if type() == 'A'
+		}`, true, 0},
+		{`policy {
+			block if type() == 'A'
+		}
+		policy {
+			block if type() == 'AAAA'
+		}`, true, 0},
+	}
+
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.config)
+		p, err := parse(c)
+		if tc.shouldErr && err == nil {
+			t.Errorf("Test %d: Expected error but found nil", i)
+			continue
+		} else if !tc.shouldErr && err != nil {
+			t.Errorf("Test %d: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if tc.shouldErr {
+			continue
+		}
+		if len(p.rules) != tc.rules {
+			t.Errorf("Test %d: Expected %d rules, got %d", i, tc.rules, len(p.rules))
+		}
+	}
+}
+
+func TestSetupRule(t *testing.T) {
+	c := caddy.NewTestController("dns", `policy {
+		filter ede Prohibited "no games" as games if name() == 'games.example.org.'
+		rcode NXDOMAIN if type() == 'ANY'
+	}`)
+	p, err := parse(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	r := p.rules[0]
+	if r.name != "games" || r.action != actionFilter || r.rcode != dns.RcodeSuccess {
+		t.Errorf("Expected a filter rule named games, got %q %s %d", r.name, r.action, r.rcode)
+	}
+	if r.ede == nil || r.ede.InfoCode != dns.ExtendedErrorCodeProhibited || r.ede.ExtraText != "no games" {
+		t.Errorf("Expected EDE Prohibited with text, got %v", r.ede)
+	}
+
+	r = p.rules[1]
+	if r.name != "2" || r.action != actionRcode || r.rcode != dns.RcodeNameError || r.ede != nil {
+		t.Errorf("Expected an unnamed rcode NXDOMAIN rule, got %q %s %d %v", r.name, r.action, r.rcode, r.ede)
+	}
+}
@@ -0,0 +1,105 @@
+// Package requestinfo implements a plugin that publishes details of requests as metadata.
+package requestinfo
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/pkg/edns"
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+// RequestInfo is a plugin that publishes the EDNS0 options and the transport details of a request as metadata.
+type RequestInfo struct {
+	Next plugin.Handler
+}
+
+// ServeDNS implements the plugin.Handler interface.
+func (ri RequestInfo) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	return plugin.NextOrFailure(ri.Name(), ri.Next, ctx, w, r)
+}
+
+// Metadata implements the metadata.Provider interface.
+func (ri RequestInfo) Metadata(ctx context.Context, state request.Request) context.Context {
+	setEDNS0(ctx, state.Req)
+
+	var cs *tls.ConnectionState
+	if r, ok := ctx.Value(dnsserver.HTTPRequestKey{}).(*http.Request); ok {
+		setHTTP(ctx, r)
+		cs = r.TLS
+	} else if s, ok := state.W.(dns.ConnectionStater); ok {
+		cs = s.ConnectionState()
+	}
+	if cs != nil {
+		setTLS(ctx, cs)
+	}
+	return ctx
+}
+
+// setEDNS0 publishes the data of each EDNS0 option of r in hex, by its code, and decoded for the options that
+// are commonly logged or matched on.
+func setEDNS0(ctx context.Context, r *dns.Msg) {
+	o := r.IsEdns0()
+	if o == nil {
+		return
+	}
+	for _, opt := range o.Option {
+		code := opt.Option()
+		metadata.SetValueFunc(ctx, pluginName+"/edns0/"+strconv.Itoa(int(code)), func() string {
+			return hex.EncodeToString(edns.OptionData(r, code))
+		})
+
+		switch e := opt.(type) {
+		case *dns.EDNS0_SUBNET:
+			metadata.SetValueFunc(ctx, pluginName+"/ecs", func() string {
+				return e.Address.String() + "/" + strconv.Itoa(int(e.SourceNetmask))
+			})
+		case *dns.EDNS0_NSID:
+			metadata.SetValueFunc(ctx, pluginName+"/nsid", func() string { return e.Nsid })
+		case *dns.EDNS0_COOKIE:
+			// The client cookie is 8 bytes, an optional server cookie follows it. Both are in hex.
+			metadata.SetValueFunc(ctx, pluginName+"/cookie/client", func() string {
+				if len(e.Cookie) < 16 {
+					return e.Cookie
+				}
+				return e.Cookie[:16]
+			})
+			metadata.SetValueFunc(ctx, pluginName+"/cookie/server", func() string {
+				if len(e.Cookie) < 16 {
+					return ""
+				}
+				return e.Cookie[16:]
+			})
+		}
+	}
+}
+
+// setHTTP publishes the path and the headers of a DNS over HTTPS request.
+func setHTTP(ctx context.Context, r *http.Request) {
+	metadata.SetValueFunc(ctx, pluginName+"/http/path", func() string { return r.URL.Path })
+	for name := range r.Header {
+		name := name
+		metadata.SetValueFunc(ctx, pluginName+"/http/header/"+name, func() string { return r.Header.Get(name) })
+	}
+}
+
+// setTLS publishes the server name the client asked for and the subject of its certificate, if it sent one.
+func setTLS(ctx context.Context, cs *tls.ConnectionState) {
+	if cs.ServerName != "" {
+		metadata.SetValueFunc(ctx, pluginName+"/tls/sni", func() string { return cs.ServerName })
+	}
+	if len(cs.PeerCertificates) > 0 {
+		metadata.SetValueFunc(ctx, pluginName+"/tls/subject", cs.PeerCertificates[0].Subject.String)
+	}
+}
+
+// Name implements the plugin.Handler interface.
+func (ri RequestInfo) Name() string { return pluginName }
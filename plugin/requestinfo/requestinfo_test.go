@@ -0,0 +1,114 @@
+package requestinfo
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+// tlsResponseWriter is a response writer of a DNS over TLS connection.
+type tlsResponseWriter struct {
+	test.ResponseWriter
+	state *tls.ConnectionState
+}
+
+func (w *tlsResponseWriter) ConnectionState() *tls.ConnectionState { return w.state }
+
+// values returns the values of labels in ctx, with "-" for labels that aren't set.
+func values(ctx context.Context, labels []string) []string {
+	v := make([]string, len(labels))
+	for i, label := range labels {
+		v[i] = "-"
+		if f := metadata.ValueFunc(ctx, label); f != nil {
+			v[i] = f()
+		}
+	}
+	return v
+}
+
+func TestMetadataEDNS0(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	m.SetEdns0(4096, false)
+	o := m.IsEdns0()
+	o.Option = append(o.Option,
+		&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("192.0.2.0").To4()},
+		&dns.EDNS0_NSID{Code: dns.EDNS0NSID},
+		&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0123456789abcdef0102030405060708"},
+		&dns.EDNS0_LOCAL{Code: 65001, Data: []byte("xyz")},
+	)
+
+	ctx := metadata.ContextWithMetadata(context.Background())
+	RequestInfo{}.Metadata(ctx, request.Request{W: &test.ResponseWriter{}, Req: m})
+
+	labels := []string{
+		"requestinfo/ecs", "requestinfo/nsid", "requestinfo/cookie/client", "requestinfo/cookie/server",
+		"requestinfo/edns0/8", "requestinfo/edns0/65001", "requestinfo/edns0/65002",
+		"requestinfo/http/path", "requestinfo/tls/sni",
+	}
+	expected := []string{
+		"192.0.2.0/24", "", "0123456789abcdef", "0102030405060708",
+		"00011800c00002", "78797a", "-",
+		"-", "-",
+	}
+	for i, v := range values(ctx, labels) {
+		if v != expected[i] {
+			t.Errorf("Expected %s to be %q, got %q", labels[i], expected[i], v)
+		}
+	}
+}
+
+func TestMetadataHTTP(t *testing.T) {
+	r := httptest.NewRequest("POST", "https://dns.example.org/dns-query", nil)
+	r.Header.Set("User-Agent", "test")
+	r.TLS.ServerName = "dns.example.org"
+	r.TLS.PeerCertificates = []*x509.Certificate{{Subject: pkix.Name{CommonName: "client.example.org", Organization: []string{"Example"}}}}
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	ctx := context.WithValue(context.Background(), dnsserver.HTTPRequestKey{}, r)
+	ctx = metadata.ContextWithMetadata(ctx)
+	RequestInfo{}.Metadata(ctx, request.Request{W: &test.ResponseWriter{}, Req: m})
+
+	labels := []string{
+		"requestinfo/http/path", "requestinfo/http/header/User-Agent", "requestinfo/http/header/Accept",
+		"requestinfo/tls/sni", "requestinfo/tls/subject", "requestinfo/ecs",
+	}
+	expected := []string{
+		"/dns-query", "test", "-",
+		"dns.example.org", "CN=client.example.org,O=Example", "-",
+	}
+	for i, v := range values(ctx, labels) {
+		if v != expected[i] {
+			t.Errorf("Expected %s to be %q, got %q", labels[i], expected[i], v)
+		}
+	}
+}
+
+func TestMetadataTLS(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	w := &tlsResponseWriter{state: &tls.ConnectionState{ServerName: "dot.example.org"}}
+
+	ctx := metadata.ContextWithMetadata(context.Background())
+	RequestInfo{}.Metadata(ctx, request.Request{W: w, Req: m})
+
+	labels := []string{"requestinfo/tls/sni", "requestinfo/tls/subject", "requestinfo/http/path"}
+	expected := []string{"dot.example.org", "-", "-"}
+	for i, v := range values(ctx, labels) {
+		if v != expected[i] {
+			t.Errorf("Expected %s to be %q, got %q", labels[i], expected[i], v)
+		}
+	}
+}
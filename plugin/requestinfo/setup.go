@@ -0,0 +1,33 @@
+package requestinfo
+
+import (
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+)
+
+const pluginName = "requestinfo"
+
+func init() { plugin.Register(pluginName, setup) }
+
+func setup(c *caddy.Controller) error {
+	i := 0
+	for c.Next() {
+		i++
+		if i > 1 {
+			return plugin.Error(pluginName, plugin.ErrOnce)
+		}
+		if c.NextArg() {
+			return plugin.Error(pluginName, c.ArgErr())
+		}
+		if c.NextBlock() {
+			return plugin.Error(pluginName, c.Errf("unknown property %q", c.Val()))
+		}
+	}
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		return RequestInfo{Next: next}
+	})
+
+	return nil
+}
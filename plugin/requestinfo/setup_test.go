@@ -0,0 +1,32 @@
+package requestinfo
+
+import (
+	"testing"
+
+	"github.com/coredns/caddy"
+)
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+	}{
+		{`requestinfo`, false},
+		// fails
+		{`requestinfo example.org`, true},
+		{`requestinfo {
+			edns0
+		}`, true},
+		{`requestinfo
+		requestinfo`, true},
+	}
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		err := setup(c)
+		if tc.shouldErr && err == nil {
+			t.Errorf("Test %d: Expected error but found nil", i)
+		} else if !tc.shouldErr && err != nil {
+			t.Errorf("Test %d: Expected no error but found error: %v", i, err)
+		}
+	}
+}
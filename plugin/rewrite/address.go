@@ -0,0 +1,102 @@
+package rewrite
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+// addressRule translates the addresses in A and AAAA records in the response from one network to another, keeping
+// the host part. PTR requests for an address in the translated network are rewritten to the original address.
+type addressRule struct {
+	nextAction string
+	from       *net.IPNet
+	to         *net.IPNet
+	qtype      uint16 // A or AAAA, depending on the family of the networks
+}
+
+func newAddressRule(nextAction string, args ...string) (Rule, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("address rules must have exactly two arguments")
+	}
+	_, from, err := net.ParseCIDR(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid network %q for an address rule", args[0])
+	}
+	_, to, err := net.ParseCIDR(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid network %q for an address rule", args[1])
+	}
+	fromOnes, fromBits := from.Mask.Size()
+	toOnes, toBits := to.Mask.Size()
+	if fromOnes != toOnes || fromBits != toBits {
+		return nil, fmt.Errorf("networks %s and %s of an address rule must be of the same family and size", from, to)
+	}
+	qtype := dns.TypeAAAA
+	if fromBits == net.IPv4len*8 {
+		qtype = dns.TypeA
+	}
+	return &addressRule{nextAction: nextAction, from: from, to: to, qtype: qtype}, nil
+}
+
+// Rewrite rewrites the current request. A and AAAA requests, depending on the family of the networks, get a
+// response rule that translates the addresses. PTR requests for an address in the translated network are
+// rewritten to the original address, and the name is reverted in the response.
+func (rule *addressRule) Rewrite(ctx context.Context, state request.Request) (ResponseRules, Result) {
+	switch state.QType() {
+	case rule.qtype:
+		return ResponseRules{&addressResponseRule{from: rule.from, to: rule.to}}, RewriteDone
+	case dns.TypePTR:
+		ip := net.ParseIP(dnsutil.ExtractAddressFromReverse(state.Name()))
+		if ip == nil || !rule.to.Contains(ip) {
+			return nil, RewriteIgnored
+		}
+		name, err := dns.ReverseAddr(translate(ip, rule.to, rule.from).String())
+		if err != nil {
+			return nil, RewriteIgnored
+		}
+		state.Req.Question[0].Name = name
+		return ResponseRules{&nameRewriterResponseRule{newRemapStringRewriter(name, state.Name())}}, RewriteDone
+	}
+	return nil, RewriteIgnored
+}
+
+// Mode returns the processing mode.
+func (rule *addressRule) Mode() string { return rule.nextAction }
+
+// addressResponseRule translates the address in A and AAAA records in from to the same address in to.
+type addressResponseRule struct {
+	from *net.IPNet
+	to   *net.IPNet
+}
+
+func (r *addressResponseRule) RewriteResponse(rr dns.RR) {
+	switch rr := rr.(type) {
+	case *dns.A:
+		if r.from.Contains(rr.A) {
+			rr.A = translate(rr.A, r.from, r.to)
+		}
+	case *dns.AAAA:
+		if r.from.Contains(rr.AAAA) {
+			rr.AAAA = translate(rr.AAAA, r.from, r.to)
+		}
+	}
+}
+
+// translate returns the address in to with the same host part as ip has in from. Both networks must be of the
+// same family and size, and from must contain ip.
+func translate(ip net.IP, from, to *net.IPNet) net.IP {
+	if ip4 := ip.To4(); ip4 != nil && len(from.IP) == net.IPv4len {
+		ip = ip4
+	}
+	out := make(net.IP, len(to.IP))
+	for i := range out {
+		out[i] = to.IP[i] | ip[i]&^to.Mask[i]
+	}
+	return out
+}
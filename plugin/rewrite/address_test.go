@@ -0,0 +1,122 @@
+package rewrite
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/horahoradev/dns"
+)
+
+func TestNewAddressRule(t *testing.T) {
+	tests := []struct {
+		args        []string
+		shouldError bool
+	}{
+		{[]string{"address", "10.1.0.0/16", "172.20.0.0/16"}, false},
+		{[]string{"continue", "address", "fd00::/64", "2001:db8::/64"}, false},
+		{[]string{"address", "10.1.0.0/16"}, true},
+		{[]string{"address", "10.1.0.0/16", "172.20.0.0/16", "10.2.0.0/16"}, true},
+		{[]string{"address", "10.1.0.0", "172.20.0.0/16"}, true},
+		{[]string{"address", "10.1.0.0/16", "172.20.0.0/24"}, true},
+		{[]string{"address", "10.1.0.0/16", "2001:db8::/16"}, true},
+	}
+	for i, tc := range tests {
+		r, err := newRule(tc.args...)
+		if err == nil && tc.shouldError {
+			t.Errorf("Test %d: expected error but got success", i)
+		} else if err != nil && !tc.shouldError {
+			t.Errorf("Test %d: expected success but got error: %s", i, err)
+		}
+		if !tc.shouldError && reflect.TypeOf(r) != reflect.TypeOf(&addressRule{}) {
+			t.Errorf("Test %d: expected an address rule but got %T", i, r)
+		}
+	}
+}
+
+// addressPrinter answers A and AAAA requests with an address in 10.1.0.0/16 or fd00::/64, and PTR requests with
+// a name, as long as the PTR request is for an address in those networks.
+func addressPrinter(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	q := r.Question[0]
+	switch q.Qtype {
+	case dns.TypeA:
+		m.Answer = []dns.RR{test.A(q.Name + " 5 IN A 10.1.2.3"), test.A(q.Name + " 5 IN A 192.0.2.1")}
+	case dns.TypeAAAA:
+		m.Answer = []dns.RR{test.AAAA(q.Name + " 5 IN AAAA fd00::1:2")}
+	case dns.TypePTR:
+		if q.Name != "3.2.1.10.in-addr.arpa." {
+			m.Rcode = dns.RcodeNameError
+			break
+		}
+		m.Answer = []dns.RR{test.PTR(q.Name + " 5 IN PTR host.example.org.")}
+	case dns.TypeMX:
+		m.Answer = []dns.RR{test.MX(q.Name + " 5 IN MX 10 mx.example.org.")}
+		m.Extra = []dns.RR{test.A("mx.example.org. 5 IN A 10.1.0.1")}
+	}
+	w.WriteMsg(m)
+	return dns.RcodeSuccess, nil
+}
+
+func TestAddressRewrite(t *testing.T) {
+	rules := []Rule{}
+	for _, args := range [][]string{
+		{"continue", "address", "10.1.0.0/16", "172.20.0.0/16"},
+		{"address", "fd00::/64", "2001:db8::/64"},
+	} {
+		r, err := newRule(args...)
+		if err != nil {
+			t.Fatalf("Unexpected error creating the rule: %s", err)
+		}
+		rules = append(rules, r)
+	}
+	rw := Rewrite{
+		Next:         plugin.HandlerFunc(addressPrinter),
+		Rules:        rules,
+		RevertPolicy: NewRevertPolicy(false, false),
+	}
+
+	tests := []struct {
+		name     string
+		qtype    uint16
+		expected []dns.RR
+		rcode    int
+	}{
+		{"example.org.", dns.TypeA, []dns.RR{test.A("example.org. 5 IN A 172.20.2.3"), test.A("example.org. 5 IN A 192.0.2.1")}, dns.RcodeSuccess},
+		{"example.org.", dns.TypeAAAA, []dns.RR{test.AAAA("example.org. 5 IN AAAA 2001:db8::1:2")}, dns.RcodeSuccess},
+		{"3.2.20.172.in-addr.arpa.", dns.TypePTR, []dns.RR{test.PTR("3.2.20.172.in-addr.arpa. 5 IN PTR host.example.org.")}, dns.RcodeSuccess},
+		// not in the translated network
+		{"3.2.1.10.in-addr.arpa.", dns.TypePTR, []dns.RR{test.PTR("3.2.1.10.in-addr.arpa. 5 IN PTR host.example.org.")}, dns.RcodeSuccess},
+		{"4.2.20.172.in-addr.arpa.", dns.TypePTR, nil, dns.RcodeNameError},
+		// the address rules don't apply, so the additional section isn't translated
+		{"example.org.", dns.TypeMX, []dns.RR{test.MX("example.org. 5 IN MX 10 mx.example.org.")}, dns.RcodeSuccess},
+	}
+
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.name, tc.qtype)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		rw.ServeDNS(context.TODO(), rec, m)
+
+		resp := rec.Msg
+		if resp.Question[0].Name != tc.name {
+			t.Errorf("Test %d: expected question %s, got %s", i, tc.name, resp.Question[0].Name)
+		}
+		if resp.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %d, got %d", i, tc.rcode, resp.Rcode)
+		}
+		if len(resp.Answer) != len(tc.expected) {
+			t.Fatalf("Test %d: expected %d answers, got %v", i, len(tc.expected), resp.Answer)
+		}
+		for j := range tc.expected {
+			if resp.Answer[j].String() != tc.expected[j].String() {
+				t.Errorf("Test %d: expected answer %s, got %s", i, tc.expected[j], resp.Answer[j])
+			}
+		}
+	}
+}
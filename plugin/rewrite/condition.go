@@ -0,0 +1,43 @@
+package rewrite
+
+import (
+	"context"
+	"strings"
+
+	"github.com/coredns/coredns/plugin/pkg/expression"
+	"github.com/coredns/coredns/request"
+
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/vm"
+)
+
+// If is the keyword that starts the condition of a rule.
+const If = "if"
+
+// condRule is a rule that only applies when its condition, an expression, evaluates to true.
+type condRule struct {
+	Rule
+	cond *vm.Program
+}
+
+// newCondRule returns a rule that applies rule only when the expression in args evaluates to true.
+func newCondRule(rule Rule, args ...string) (Rule, error) {
+	cond, err := expr.Compile(strings.Join(args, " "), expr.Env(expression.DefaultEnv(context.Background(), nil)))
+	if err != nil {
+		return nil, err
+	}
+	return &condRule{Rule: rule, cond: cond}, nil
+}
+
+// Rewrite rewrites the current request if the condition evaluates to true. Anything other than a boolean true,
+// including an error, is considered false.
+func (rule *condRule) Rewrite(ctx context.Context, state request.Request) (ResponseRules, Result) {
+	result, err := expr.Run(rule.cond, expression.DefaultEnv(ctx, &state))
+	if err != nil {
+		return nil, RewriteIgnored
+	}
+	if b, ok := result.(bool); !ok || !b {
+		return nil, RewriteIgnored
+	}
+	return rule.Rule.Rewrite(ctx, state)
+}
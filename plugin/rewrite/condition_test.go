@@ -0,0 +1,93 @@
+package rewrite
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+func TestNewCondRule(t *testing.T) {
+	tests := []struct {
+		args        []string
+		shouldError bool
+		expType     reflect.Type
+	}{
+		{[]string{"name", "a.com", "b.com", "if", "type()", "==", "'A'"}, false, reflect.TypeOf(&condRule{})},
+		{[]string{"continue", "type", "any", "a", "IF", "incidr(client_ip(),", "'10.0.0.0/8')"}, false, reflect.TypeOf(&condRule{})},
+		{[]string{"edns0", "nsid", "set", "if", "proto()", "==", "'udp'"}, false, reflect.TypeOf(&condRule{})},
+		{[]string{"name", "a.com", "b.com", "if"}, true, nil},
+		{[]string{"name", "a.com", "if", "type()", "==", "'A'"}, true, nil},
+		{[]string{"name", "a.com", "b.com", "if", "type(", "==", "'A'"}, true, nil},
+		{[]string{"name", "a.com", "b.com", "if", "nosuchfunc()"}, true, nil},
+	}
+
+	for i, tc := range tests {
+		r, err := newRule(tc.args...)
+		if err == nil && tc.shouldError {
+			t.Errorf("Test %d: expected error but got success", i)
+		} else if err != nil && !tc.shouldError {
+			t.Errorf("Test %d: expected success but got error: %s", i, err)
+		}
+		if !tc.shouldError && reflect.TypeOf(r) != tc.expType {
+			t.Errorf("Test %d: expected %q but got %q", i, tc.expType, r)
+		}
+	}
+}
+
+func TestRewriteCondition(t *testing.T) {
+	// test.ResponseWriter has a remote address of 10.240.0.1.
+	tests := []struct {
+		rule     []string
+		qtype    uint16
+		expected string
+	}{
+		{[]string{"name", "a.com.", "b.com.", "if", "incidr(client_ip(),", "'10.240.0.0/16')"}, dns.TypeA, "b.com."},
+		{[]string{"name", "a.com.", "b.com.", "if", "incidr(client_ip(),", "'192.168.0.0/16')"}, dns.TypeA, "a.com."},
+		{[]string{"name", "a.com.", "b.com.", "if", "type()", "==", "'AAAA'"}, dns.TypeA, "a.com."},
+		{[]string{"name", "a.com.", "b.com.", "if", "type()", "==", "'AAAA'"}, dns.TypeAAAA, "b.com."},
+		{[]string{"name", "a.com.", "b.com.", "if", "metadata('test/country')", "==", "'NL'"}, dns.TypeA, "b.com."},
+		{[]string{"name", "a.com.", "b.com.", "if", "metadata('test/country')", "==", "'US'"}, dns.TypeA, "a.com."},
+		// not a boolean
+		{[]string{"name", "a.com.", "b.com.", "if", "name()"}, dns.TypeA, "a.com."},
+		// an error at run time
+		{[]string{"name", "a.com.", "b.com.", "if", "incidr(client_ip(),", "'bogus')"}, dns.TypeA, "a.com."},
+	}
+
+	for i, tc := range tests {
+		rule, err := newRule(tc.rule...)
+		if err != nil {
+			t.Fatalf("Test %d: unexpected error creating the rule: %s", i, err)
+		}
+		var got string
+		rw := Rewrite{
+			Next: plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+				got = r.Question[0].Name
+				return msgPrinter(ctx, w, r)
+			}),
+			Rules:        []Rule{rule},
+			RevertPolicy: NoRevertPolicy(),
+		}
+		meta := metadata.Metadata{
+			Zones:     []string{"."},
+			Providers: []metadata.Provider{testProvider{"test/country": func() string { return "NL" }}},
+			Next:      &rw,
+		}
+
+		m := new(dns.Msg)
+		m.SetQuestion("a.com.", tc.qtype)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		ctx := meta.Collect(context.TODO(), request.Request{W: rec, Req: m})
+		meta.ServeDNS(ctx, rec, m)
+		if got != tc.expected {
+			t.Errorf("Test %d: expected name %s, got %s", i, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package rewrite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/coredns/coredns/plugin/pkg/edns"
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+// edeRule adds an Extended DNS Error (RFC 8914) option to the response for requests with a matching name.
+type edeRule struct {
+	nextAction string
+	match      func(name string) bool
+	response   edeResponseRule
+}
+
+func newEDERule(nextAction string, args ...string) (Rule, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("too few (%d) arguments for an ede rule", len(args))
+	}
+	// The name match type is optional, and so is the extra text.
+	n := 1
+	switch strings.ToLower(args[0]) {
+	case ExactMatch, PrefixMatch, SuffixMatch, SubstringMatch, RegexMatch:
+		if len(args) > 2 {
+			n = 2
+		}
+	}
+	if len(args) > n+2 {
+		return nil, fmt.Errorf("too many (%d) arguments for an ede rule", len(args))
+	}
+	match, err := newNameMatcher("ede", args[:n]...)
+	if err != nil {
+		return nil, err
+	}
+	code, err := edns.ParseEDECode(args[n])
+	if err != nil {
+		return nil, err
+	}
+	response := edeResponseRule{code: code}
+	if len(args) > n+1 {
+		response.text = args[n+1]
+	}
+	return &edeRule{nextAction: nextAction, match: match, response: response}, nil
+}
+
+// Rewrite rewrites the current request.
+func (rule *edeRule) Rewrite(ctx context.Context, state request.Request) (ResponseRules, Result) {
+	if rule.match(state.Name()) {
+		return ResponseRules{&rule.response}, RewriteDone
+	}
+	return nil, RewriteIgnored
+}
+
+// Mode returns the processing mode.
+func (rule *edeRule) Mode() string { return rule.nextAction }
+
+// edeResponseRule adds an Extended DNS Error option to responses with an OPT record, i.e. to clients that use EDNS,
+// unless the response already has one with the same code.
+type edeResponseRule struct {
+	code uint16
+	text string
+}
+
+func (r *edeResponseRule) RewriteResponse(rr dns.RR) {}
+
+func (r *edeResponseRule) RewriteResponseMsg(res *dns.Msg) {
+	o := res.IsEdns0()
+	if o == nil {
+		return
+	}
+	// Don't add the code twice, e.g. when the upstream already set it.
+	for _, opt := range o.Option {
+		if e, ok := opt.(*dns.EDNS0_EDE); ok && e.InfoCode == r.code {
+			return
+		}
+	}
+	o.Option = append(o.Option, &dns.EDNS0_EDE{InfoCode: r.code, ExtraText: r.text})
+}
@@ -0,0 +1,134 @@
+package rewrite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/horahoradev/dns"
+)
+
+func TestNewEDERule(t *testing.T) {
+	tests := []struct {
+		args         []string
+		expectedFail bool
+		code         uint16
+		text         string
+	}{
+		{[]string{"example.org", "15"}, false, dns.ExtendedErrorCodeBlocked, ""},
+		{[]string{"example.org", "blocked", "by policy"}, false, dns.ExtendedErrorCodeBlocked, "by policy"},
+		{[]string{"suffix", ".example.org", "NotAuthoritative"}, false, dns.ExtendedErrorCodeNotAuthoritative, ""},
+		{[]string{"regex", `^ads\.`, "Filtered", "ads"}, false, dns.ExtendedErrorCodeFiltered, "ads"},
+		{[]string{"example.org"}, true, 0, ""},
+		{[]string{"example.org", "bogus"}, true, 0, ""},
+		{[]string{"example.org", "70000"}, true, 0, ""},
+		{[]string{"suffix", ".example.org", "15", "text", "more"}, true, 0, ""},
+	}
+	for i, tc := range tests {
+		r, err := newRule(append([]string{"ede"}, tc.args...)...)
+		if failed := err != nil; failed != tc.expectedFail {
+			t.Errorf("Test %d: expected fail=%t, got error %v", i, tc.expectedFail, err)
+			continue
+		}
+		if tc.expectedFail {
+			continue
+		}
+		rule := r.(*edeRule)
+		if rule.response.code != tc.code || rule.response.text != tc.text {
+			t.Errorf("Test %d: expected code %d and text %q, got %d and %q", i, tc.code, tc.text, rule.response.code, rule.response.text)
+		}
+	}
+}
+
+func TestEDERewrite(t *testing.T) {
+	rules := []Rule{}
+	for _, args := range [][]string{
+		{"continue", "rcode", "suffix", ".nx.example.org", "NXDOMAIN", "REFUSED"},
+		{"ede", "suffix", ".nx.example.org", "Blocked", "by policy"},
+	} {
+		r, err := newRule(args...)
+		if err != nil {
+			t.Fatalf("Unexpected error creating the rule: %s", err)
+		}
+		rules = append(rules, r)
+	}
+	rw := Rewrite{
+		Next: plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+			m := new(dns.Msg)
+			m.SetRcode(r, dns.RcodeNameError)
+			if r.IsEdns0() != nil {
+				m.SetEdns0(4096, false)
+			}
+			w.WriteMsg(m)
+			return dns.RcodeSuccess, nil
+		}),
+		Rules:        rules,
+		RevertPolicy: NewRevertPolicy(false, false),
+	}
+
+	tests := []struct {
+		name  string
+		edns  bool
+		ede   bool
+		rcode int
+	}{
+		{"a.nx.example.org.", true, true, dns.RcodeRefused},
+		{"a.nx.example.org.", false, false, dns.RcodeRefused},
+		{"a.example.org.", true, false, dns.RcodeNameError},
+	}
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.name, dns.TypeA)
+		if tc.edns {
+			m.SetEdns0(4096, false)
+		}
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		rw.ServeDNS(context.TODO(), rec, m)
+
+		if rec.Msg.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %d, got %d", i, tc.rcode, rec.Msg.Rcode)
+		}
+		var ede *dns.EDNS0_EDE
+		if o := rec.Msg.IsEdns0(); o != nil {
+			for _, opt := range o.Option {
+				if e, ok := opt.(*dns.EDNS0_EDE); ok {
+					ede = e
+				}
+			}
+		}
+		if (ede != nil) != tc.ede {
+			t.Fatalf("Test %d: expected an EDE option %t, got %v", i, tc.ede, ede)
+		}
+		if ede != nil && (ede.InfoCode != dns.ExtendedErrorCodeBlocked || ede.ExtraText != "by policy") {
+			t.Errorf("Test %d: expected EDE 15 with text, got %v", i, ede)
+		}
+	}
+}
+
+func TestEDEResponseDuplicate(t *testing.T) {
+	rule := &edeResponseRule{code: dns.ExtendedErrorCodeBlocked, text: "by policy"}
+	tests := []struct {
+		existing []uint16
+		expected int
+	}{
+		{nil, 1},
+		{[]uint16{dns.ExtendedErrorCodeBlocked}, 1},
+		{[]uint16{dns.ExtendedErrorCodeFiltered}, 2},
+	}
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetEdns0(4096, false)
+		o := m.IsEdns0()
+		for _, code := range tc.existing {
+			o.Option = append(o.Option, &dns.EDNS0_EDE{InfoCode: code})
+		}
+		rule.RewriteResponseMsg(m)
+		rule.RewriteResponseMsg(m)
+		if len(o.Option) != tc.expected {
+			t.Errorf("Test %d: expected %d EDE options, got %d", i, tc.expected, len(o.Option))
+		}
+	}
+}
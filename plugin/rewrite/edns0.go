@@ -38,6 +38,12 @@ type edns0NsidRule struct {
 	action string
 }
 
+// edns0UnsetRule is a rewrite rule that removes EDNS0 options.
+type edns0UnsetRule struct {
+	mode  string
+	codes []uint16 // the option codes to remove, all options if empty
+}
+
 // setupEdns0Opt will retrieve the EDNS0 OPT or create it if it does not exist.
 func setupEdns0Opt(r *dns.Msg) *dns.OPT {
 	o := r.IsEdns0()
@@ -100,8 +106,46 @@ func (rule *edns0LocalRule) Rewrite(ctx context.Context, state request.Request)
 // Mode returns the processing mode.
 func (rule *edns0LocalRule) Mode() string { return rule.mode }
 
+// Rewrite will remove the EDNS0 options from the request.
+func (rule *edns0UnsetRule) Rewrite(ctx context.Context, state request.Request) (ResponseRules, Result) {
+	o := state.Req.IsEdns0()
+	if o == nil {
+		return nil, RewriteIgnored
+	}
+
+	result := RewriteIgnored
+	options := o.Option[:0]
+	for _, s := range o.Option {
+		if rule.remove(s.Option()) {
+			result = RewriteDone
+			continue
+		}
+		options = append(options, s)
+	}
+	o.Option = options
+	return nil, result
+}
+
+func (rule *edns0UnsetRule) remove(code uint16) bool {
+	if len(rule.codes) == 0 {
+		return true
+	}
+	for _, c := range rule.codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Mode returns the processing mode.
+func (rule *edns0UnsetRule) Mode() string { return rule.mode }
+
 // newEdns0Rule creates an EDNS0 rule of the appropriate type based on the args
 func newEdns0Rule(mode string, args ...string) (Rule, error) {
+	if len(args) > 0 && strings.ToLower(args[0]) == Strip {
+		return newEdns0StripRule(mode, args[1:]...)
+	}
 	if len(args) < 2 {
 		return nil, fmt.Errorf("too few arguments for an EDNS0 rule")
 	}
@@ -112,6 +156,8 @@ func newEdns0Rule(mode string, args ...string) (Rule, error) {
 	case Append:
 	case Replace:
 	case Set:
+	case Unset:
+		return newEdns0UnsetRule(mode, ruleType, args[2:]...)
 	default:
 		return nil, fmt.Errorf("invalid action: %q", action)
 	}
@@ -141,6 +187,48 @@ func newEdns0Rule(mode string, args ...string) (Rule, error) {
 	}
 }
 
+// newEdns0UnsetRule creates an EDNS0 rule that removes the option of ruleType, for local options the one with the code
+// in args.
+func newEdns0UnsetRule(mode, ruleType string, args ...string) (*edns0UnsetRule, error) {
+	switch ruleType {
+	case "local":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("EDNS0 local unset rules require exactly one arg")
+		}
+		c, err := strconv.ParseUint(args[0], 0, 16)
+		if err != nil {
+			return nil, err
+		}
+		return &edns0UnsetRule{mode: mode, codes: []uint16{uint16(c)}}, nil
+	case "nsid":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("EDNS0 NSID rules do not accept args")
+		}
+		return &edns0UnsetRule{mode: mode, codes: []uint16{dns.EDNS0NSID}}, nil
+	case "subnet":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("EDNS0 subnet unset rules do not accept args")
+		}
+		return &edns0UnsetRule{mode: mode, codes: []uint16{dns.EDNS0SUBNET}}, nil
+	default:
+		return nil, fmt.Errorf("invalid rule type %q", ruleType)
+	}
+}
+
+// newEdns0StripRule creates an EDNS0 rule that removes the options with the codes in args, or all options if there
+// are none.
+func newEdns0StripRule(mode string, args ...string) (*edns0UnsetRule, error) {
+	rule := &edns0UnsetRule{mode: mode}
+	for _, arg := range args {
+		c, err := strconv.ParseUint(arg, 0, 16)
+		if err != nil {
+			return nil, err
+		}
+		rule.codes = append(rule.codes, uint16(c))
+	}
+	return rule, nil
+}
+
 func newEdns0LocalRule(mode, action, code, data string) (*edns0LocalRule, error) {
 	c, err := strconv.ParseUint(code, 0, 16)
 	if err != nil {
@@ -357,6 +445,9 @@ const (
 	Replace = "replace"
 	Set     = "set"
 	Append  = "append"
+	Unset   = "unset"
+	// Strip removes EDNS0 options by code, it isn't tied to an option type.
+	Strip = "strip"
 )
 
 // Supported local EDNS0 variables
@@ -0,0 +1,98 @@
+package rewrite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+// flagsRule sets or clears flags in the header of the response for requests with a matching name.
+type flagsRule struct {
+	nextAction string
+	match      func(name string) bool
+	response   flagsResponseRule
+}
+
+// These are the actions of a flags rule.
+const (
+	// SetFlags sets the flags that follow.
+	SetFlags = "set"
+	// ClearFlags clears the flags that follow.
+	ClearFlags = "clear"
+)
+
+func newFlagsRule(nextAction string, args ...string) (Rule, error) {
+	start := -1
+	for i, arg := range args {
+		if a := strings.ToLower(arg); a == SetFlags || a == ClearFlags {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("flags rule must have a %s or %s action", SetFlags, ClearFlags)
+	}
+	match, err := newNameMatcher("flags", args[:start]...)
+	if err != nil {
+		return nil, err
+	}
+
+	var response flagsResponseRule
+	set := true
+	for i, arg := range args[start:] {
+		switch a := strings.ToLower(arg); a {
+		case SetFlags, ClearFlags:
+			if i+start == len(args)-1 || strings.EqualFold(args[i+start+1], SetFlags) || strings.EqualFold(args[i+start+1], ClearFlags) {
+				return nil, fmt.Errorf("%s in a flags rule must be followed by flags", a)
+			}
+			set = a == SetFlags
+		default:
+			f, ok := flags[a]
+			if !ok {
+				return nil, fmt.Errorf("invalid flag %q in a flags rule", arg)
+			}
+			response.flags = append(response.flags, f)
+			response.values = append(response.values, set)
+		}
+	}
+	return &flagsRule{nextAction: nextAction, match: match, response: response}, nil
+}
+
+// Rewrite rewrites the current request.
+func (rule *flagsRule) Rewrite(ctx context.Context, state request.Request) (ResponseRules, Result) {
+	if rule.match(state.Name()) {
+		return ResponseRules{&rule.response}, RewriteDone
+	}
+	return nil, RewriteIgnored
+}
+
+// Mode returns the processing mode.
+func (rule *flagsRule) Mode() string { return rule.nextAction }
+
+// flagsResponseRule sets each of flags to the value at the same index in values.
+type flagsResponseRule struct {
+	flags  []func(m *dns.Msg, v bool)
+	values []bool
+}
+
+func (r *flagsResponseRule) RewriteResponse(rr dns.RR) {}
+
+func (r *flagsResponseRule) RewriteResponseMsg(res *dns.Msg) {
+	for i, f := range r.flags {
+		f(res, r.values[i])
+	}
+}
+
+// flags are the flags in the header of a response that a flags rule can change.
+var flags = map[string]func(m *dns.Msg, v bool){
+	"aa": func(m *dns.Msg, v bool) { m.Authoritative = v },
+	"tc": func(m *dns.Msg, v bool) { m.Truncated = v },
+	"rd": func(m *dns.Msg, v bool) { m.RecursionDesired = v },
+	"ra": func(m *dns.Msg, v bool) { m.RecursionAvailable = v },
+	"ad": func(m *dns.Msg, v bool) { m.AuthenticatedData = v },
+	"cd": func(m *dns.Msg, v bool) { m.CheckingDisabled = v },
+}
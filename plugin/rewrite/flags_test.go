@@ -0,0 +1,64 @@
+package rewrite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/horahoradev/dns"
+)
+
+func TestNewFlagsRule(t *testing.T) {
+	tests := []struct {
+		args         []string
+		expectedFail bool
+	}{
+		{[]string{"example.org", "set", "aa"}, false},
+		{[]string{"suffix", ".example.org", "clear", "RA", "set", "aa", "ad"}, false},
+		{[]string{"example.org"}, true},
+		{[]string{"set", "aa"}, true},
+		{[]string{"example.org", "set"}, true},
+		{[]string{"example.org", "set", "clear", "aa"}, true},
+		{[]string{"example.org", "set", "qr"}, true},
+	}
+	for i, tc := range tests {
+		_, err := newRule(append([]string{"flags"}, tc.args...)...)
+		if failed := err != nil; failed != tc.expectedFail {
+			t.Errorf("Test %d: expected fail=%t, got error %v", i, tc.expectedFail, err)
+		}
+	}
+}
+
+func TestFlagsRewrite(t *testing.T) {
+	r, err := newRule("flags", "suffix", ".example.org", "set", "aa", "clear", "ra")
+	if err != nil {
+		t.Fatalf("Unexpected error creating the rule: %s", err)
+	}
+	rw := Rewrite{
+		Next:         plugin.HandlerFunc(rcodePrinter),
+		Rules:        []Rule{r},
+		RevertPolicy: NewRevertPolicy(false, false),
+	}
+
+	tests := []struct {
+		name string
+		aa   bool
+		ra   bool
+	}{
+		{"a.example.org.", true, false},
+		{"a.example.net.", false, true},
+	}
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.name, dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		rw.ServeDNS(context.TODO(), rec, m)
+
+		if rec.Msg.Authoritative != tc.aa || rec.Msg.RecursionAvailable != tc.ra {
+			t.Errorf("Test %d: expected aa=%t ra=%t, got aa=%t ra=%t", i, tc.aa, tc.ra, rec.Msg.Authoritative, rec.Msg.RecursionAvailable)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package rewrite
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/coredns/coredns/plugin"
+)
+
+// newNameMatcher returns a function that reports whether a name matches. args is the optional match type, which
+// defaults to exact, and the string to match, as in ttl rules.
+func newNameMatcher(ruleType string, args ...string) (func(name string) bool, error) {
+	switch len(args) {
+	case 1:
+		from := plugin.Name(args[0]).Normalize()
+		return func(name string) bool { return name == from }, nil
+	case 2:
+	default:
+		return nil, fmt.Errorf("a %s rule needs a name to match, optionally preceded by the match type", ruleType)
+	}
+
+	s := plugin.Name(args[1]).Normalize()
+	switch strings.ToLower(args[0]) {
+	case ExactMatch:
+		return func(name string) bool { return name == s }, nil
+	case PrefixMatch:
+		return func(name string) bool { return strings.HasPrefix(name, s) }, nil
+	case SuffixMatch:
+		return func(name string) bool { return strings.HasSuffix(name, s) }, nil
+	case SubstringMatch:
+		return func(name string) bool { return strings.Contains(name, s) }, nil
+	case RegexMatch:
+		pattern, err := regexp.Compile(args[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex pattern in a %s rule: %s", ruleType, args[1])
+		}
+		return func(name string) bool { return pattern.MatchString(name) }, nil
+	}
+	return nil, fmt.Errorf("%s rule supports only exact, prefix, suffix, substring, and regex name matching", ruleType)
+}
@@ -0,0 +1,103 @@
+package rewrite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/coredns/coredns/plugin/pkg/rcode"
+	"github.com/coredns/coredns/request"
+
+	"github.com/horahoradev/dns"
+)
+
+// rcodeRule changes the rcode of the response, and optionally replaces its answer section, for requests with a
+// matching name.
+type rcodeRule struct {
+	nextAction string
+	match      func(name string) bool
+	response   rcodeResponseRule
+}
+
+func newRcodeRule(nextAction string, args ...string) (Rule, error) {
+	var answers []dns.RR
+	for i, arg := range args {
+		if strings.ToLower(arg) != AnswerMatch {
+			continue
+		}
+		if i == len(args)-1 {
+			return nil, fmt.Errorf("answer in an rcode rule must be followed by records")
+		}
+		for _, s := range args[i+1:] {
+			rr, err := dns.NewRR(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid record %q in an rcode rule: %s", s, err)
+			}
+			answers = append(answers, rr)
+		}
+		args = args[:i]
+		break
+	}
+	if len(args) < 3 {
+		return nil, fmt.Errorf("too few (%d) arguments for an rcode rule", len(args))
+	}
+
+	from, err := rcode.Parse(args[len(args)-2])
+	if err != nil {
+		return nil, err
+	}
+	to, err := rcode.Parse(args[len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+	match, err := newNameMatcher("rcode", args[:len(args)-2]...)
+	if err != nil {
+		return nil, err
+	}
+	return &rcodeRule{
+		nextAction: nextAction,
+		match:      match,
+		response:   rcodeResponseRule{from: from, to: to, answers: answers},
+	}, nil
+}
+
+// Rewrite rewrites the current request.
+func (rule *rcodeRule) Rewrite(ctx context.Context, state request.Request) (ResponseRules, Result) {
+	if rule.match(state.Name()) {
+		return ResponseRules{&rule.response}, RewriteDone
+	}
+	return nil, RewriteIgnored
+}
+
+// Mode returns the processing mode.
+func (rule *rcodeRule) Mode() string { return rule.nextAction }
+
+// rcodeResponseRule changes the rcode from one to another. If there are answers, those of the type in the question
+// replace the answer section, with the name in the question.
+type rcodeResponseRule struct {
+	from    int
+	to      int
+	answers []dns.RR
+}
+
+func (r *rcodeResponseRule) RewriteResponse(rr dns.RR) {}
+
+func (r *rcodeResponseRule) RewriteResponseMsg(res *dns.Msg) {
+	if res.Rcode != r.from {
+		return
+	}
+	res.Rcode = r.to
+	if r.answers == nil {
+		return
+	}
+	res.Answer = nil
+	q := res.Question[0]
+	for _, answer := range r.answers {
+		if answer.Header().Rrtype != q.Qtype && q.Qtype != dns.TypeANY {
+			continue
+		}
+		rr := dns.Copy(answer)
+		rr.Header().Name = q.Name
+		res.Answer = append(res.Answer, rr)
+	}
+}
@@ -0,0 +1,144 @@
+package rewrite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/horahoradev/dns"
+)
+
+func TestNewRcodeRule(t *testing.T) {
+	tests := []struct {
+		args         []string
+		expectedFail bool
+	}{
+		{[]string{"example.org", "NXDOMAIN", "NOERROR"}, false},
+		{[]string{"suffix", ".example.org", "nxdomain", "noerror"}, false},
+		{[]string{"regex", `^a\.`, "SERVFAIL", "5"}, false},
+		{[]string{"example.org", "NXDOMAIN", "NOERROR", "answer", "example.org. 60 IN A 10.0.0.1"}, false},
+		{[]string{"example.org", "NXDOMAIN"}, true},
+		{[]string{"example.org", "NXDOMAIN", "BOGUS"}, true},
+		{[]string{"example.org", "NXDOMAIN", "5000"}, true},
+		{[]string{"example.org", "NXDOMAIN", "23"}, true},
+		{[]string{"example.org", "BADCOOKIE", "NOERROR"}, true},
+		{[]string{"fuzzy", "example.org", "NXDOMAIN", "NOERROR"}, true},
+		{[]string{"regex", "(", "NXDOMAIN", "NOERROR"}, true},
+		{[]string{"example.org", "NXDOMAIN", "NOERROR", "answer"}, true},
+		{[]string{"example.org", "NXDOMAIN", "NOERROR", "answer", "example.org. 60 IN A bogus"}, true},
+	}
+	for i, tc := range tests {
+		_, err := newRule(append([]string{"rcode"}, tc.args...)...)
+		if failed := err != nil; failed != tc.expectedFail {
+			t.Errorf("Test %d: expected fail=%t, got error %v", i, tc.expectedFail, err)
+		}
+	}
+}
+
+// rcodePrinter answers NXDOMAIN with an SOA record for names in nx.example.org., SERVFAIL for broken.example.org.
+// and an A record for anything else.
+func rcodePrinter(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.RecursionAvailable = true
+	switch name := r.Question[0].Name; {
+	case dns.IsSubDomain("nx.example.org.", name):
+		m.Rcode = dns.RcodeNameError
+		m.Ns = []dns.RR{test.SOA("example.org. 60 IN SOA ns.example.org. admin.example.org. 1 3600 600 86400 60")}
+	case name == "broken.example.org.":
+		m.Rcode = dns.RcodeServerFailure
+	default:
+		m.Answer = []dns.RR{test.A(name + " 5 IN A 10.0.0.1")}
+	}
+	w.WriteMsg(m)
+	return dns.RcodeSuccess, nil
+}
+
+func TestRcodeRewrite(t *testing.T) {
+	rules := []Rule{}
+	for _, args := range [][]string{
+		{"rcode", "a.nx.example.org", "NXDOMAIN", "NOERROR", "answer", "x. 60 IN A 192.0.2.1", "x. 60 IN AAAA 2001:db8::1"},
+		{"rcode", "suffix", ".nx.example.org", "NXDOMAIN", "NOERROR"},
+		{"rcode", "broken.example.org", "SERVFAIL", "REFUSED"},
+	} {
+		r, err := newRule(args...)
+		if err != nil {
+			t.Fatalf("Unexpected error creating the rule: %s", err)
+		}
+		rules = append(rules, r)
+	}
+	rw := Rewrite{
+		Next:         plugin.HandlerFunc(rcodePrinter),
+		Rules:        rules,
+		RevertPolicy: NewRevertPolicy(false, false),
+	}
+
+	tests := []struct {
+		name     string
+		qtype    uint16
+		rcode    int
+		expected []dns.RR
+		ns       int
+	}{
+		{"a.nx.example.org.", dns.TypeA, dns.RcodeSuccess, []dns.RR{test.A("a.nx.example.org. 60 IN A 192.0.2.1")}, 1},
+		{"a.nx.example.org.", dns.TypeMX, dns.RcodeSuccess, nil, 1},
+		{"b.nx.example.org.", dns.TypeA, dns.RcodeSuccess, nil, 1},
+		{"nx.example.org.", dns.TypeA, dns.RcodeNameError, nil, 1},
+		{"broken.example.org.", dns.TypeA, dns.RcodeRefused, nil, 0},
+		// the rcode doesn't match
+		{"a.example.org.", dns.TypeA, dns.RcodeSuccess, []dns.RR{test.A("a.example.org. 5 IN A 10.0.0.1")}, 0},
+	}
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.name, tc.qtype)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		rw.ServeDNS(context.TODO(), rec, m)
+
+		resp := rec.Msg
+		if resp.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %s, got %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[resp.Rcode])
+		}
+		if len(resp.Ns) != tc.ns {
+			t.Errorf("Test %d: expected %d records in the authority section, got %d", i, tc.ns, len(resp.Ns))
+		}
+		if len(resp.Answer) != len(tc.expected) {
+			t.Fatalf("Test %d: expected %d answers, got %v", i, len(tc.expected), resp.Answer)
+		}
+		for j := range tc.expected {
+			if resp.Answer[j].String() != tc.expected[j].String() {
+				t.Errorf("Test %d: expected answer %s, got %s", i, tc.expected[j], resp.Answer[j])
+			}
+		}
+	}
+}
+
+func TestRcodeRewriteNoWrite(t *testing.T) {
+	for _, mode := range []string{Stop, Continue} {
+		r, err := newRule(mode, "rcode", "broken.example.org", "SERVFAIL", "REFUSED")
+		if err != nil {
+			t.Fatalf("Unexpected error creating the rule: %s", err)
+		}
+		rw := Rewrite{
+			// The next plugin returns SERVFAIL without writing a response.
+			Next: plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+				return dns.RcodeServerFailure, nil
+			}),
+			Rules:        []Rule{r},
+			RevertPolicy: NewRevertPolicy(false, false),
+		}
+
+		m := new(dns.Msg)
+		m.SetQuestion("broken.example.org.", dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		rcode, _ := rw.ServeDNS(context.TODO(), rec, m)
+		if !plugin.ClientWrite(rcode) {
+			t.Errorf("Mode %s: expected the response to be written, got rcode %s", mode, dns.RcodeToString[rcode])
+		}
+		if rec.Msg == nil || rec.Msg.Rcode != dns.RcodeRefused {
+			t.Errorf("Mode %s: expected a REFUSED response, got %v", mode, rec.Msg)
+		}
+	}
+}
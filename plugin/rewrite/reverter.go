@@ -44,6 +44,13 @@ type ResponseRule interface {
 	RewriteResponse(rr dns.RR)
 }
 
+// ResponseMsgRule is a ResponseRule that also rewrites the response as a whole, such as its rcode or flags.
+// RewriteResponseMsg is called before the records in the response are rewritten.
+type ResponseMsgRule interface {
+	ResponseRule
+	RewriteResponseMsg(res *dns.Msg)
+}
+
 // ResponseRules describes an ordered list of response rules to apply
 // after a name rewrite
 type ResponseRules = []ResponseRule
@@ -75,6 +82,11 @@ func (r *ResponseReverter) WriteMsg(res1 *dns.Msg) error {
 	if r.revertPolicy.DoQuestionRestore() {
 		res.Question[0] = r.originalQuestion
 	}
+	for _, rule := range r.ResponseRules {
+		if rule, ok := rule.(ResponseMsgRule); ok {
+			rule.RewriteResponseMsg(res)
+		}
+	}
 	if len(r.ResponseRules) > 0 {
 		for _, rr := range res.Ns {
 			r.rewriteResourceRecord(res, rr)
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/expression"
 	"github.com/coredns/coredns/request"
 
 	"github.com/horahoradev/dns"
@@ -57,24 +58,29 @@ func (rw Rewrite) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 				if !rw.RevertPolicy.DoRevert() {
 					return plugin.NextOrFailure(rw.Name(), rw.Next, ctx, w, r)
 				}
-				rcode, err := plugin.NextOrFailure(rw.Name(), rw.Next, ctx, wr, r)
-				if plugin.ClientWrite(rcode) {
-					return rcode, err
-				}
-				// The next plugins didn't write a response, so write one now with the ResponseReverter.
-				// If server.ServeDNS does this then it will create an answer mismatch.
-				res := new(dns.Msg).SetRcode(r, rcode)
-				state.SizeAndDo(res)
-				wr.WriteMsg(res)
-				// return success, so server does not write a second error response to client
-				return dns.RcodeSuccess, err
+				return rw.serveNext(ctx, state, wr)
 			}
 		}
 	}
 	if !rw.RevertPolicy.DoRevert() || len(wr.ResponseRules) == 0 {
 		return plugin.NextOrFailure(rw.Name(), rw.Next, ctx, w, r)
 	}
-	return plugin.NextOrFailure(rw.Name(), rw.Next, ctx, wr, r)
+	return rw.serveNext(ctx, state, wr)
+}
+
+// serveNext calls the next plugin with the ResponseReverter wr, so the response rules are applied to the response.
+func (rw Rewrite) serveNext(ctx context.Context, state request.Request, wr *ResponseReverter) (int, error) {
+	rcode, err := plugin.NextOrFailure(rw.Name(), rw.Next, ctx, wr, state.Req)
+	if plugin.ClientWrite(rcode) {
+		return rcode, err
+	}
+	// The next plugins didn't write a response, so write one now with the ResponseReverter.
+	// If server.ServeDNS does this then it will create an answer mismatch.
+	res := new(dns.Msg).SetRcode(state.Req, rcode)
+	state.SizeAndDo(res)
+	wr.WriteMsg(res)
+	// return success, so server does not write a second error response to client
+	return dns.RcodeSuccess, err
 }
 
 // Name implements the Handler interface.
@@ -92,6 +98,16 @@ func newRule(args ...string) (Rule, error) {
 	if len(args) == 0 {
 		return nil, fmt.Errorf("no rule type specified for rewrite")
 	}
+	if ruleArgs, cond := expression.SplitCondition(args); cond != nil {
+		if len(cond) == 0 {
+			return nil, fmt.Errorf("%s must be followed by an expression", If)
+		}
+		rule, err := newRule(ruleArgs...)
+		if err != nil {
+			return nil, err
+		}
+		return newCondRule(rule, cond...)
+	}
 
 	arg0 := strings.ToLower(args[0])
 	var ruleType string
@@ -139,6 +155,14 @@ func newRule(args ...string) (Rule, error) {
 		return newEdns0Rule(mode, args[startArg:]...)
 	case "ttl":
 		return newTTLRule(mode, args[startArg:]...)
+	case "rcode":
+		return newRcodeRule(mode, args[startArg:]...)
+	case "flags":
+		return newFlagsRule(mode, args[startArg:]...)
+	case "ede":
+		return newEDERule(mode, args[startArg:]...)
+	case "address":
+		return newAddressRule(mode, args[startArg:]...)
 	default:
 		return nil, fmt.Errorf("invalid rule type %q", args[0])
 	}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"net"
 	"reflect"
 	"testing"
 
@@ -110,6 +111,17 @@ func TestNewRule(t *testing.T) {
 		{[]string{"edns0", "subnet", "set", "24", "56"}, false, reflect.TypeOf(&edns0SubnetRule{})},
 		{[]string{"edns0", "subnet", "append", "24", "56"}, false, reflect.TypeOf(&edns0SubnetRule{})},
 		{[]string{"edns0", "subnet", "replace", "24", "56"}, false, reflect.TypeOf(&edns0SubnetRule{})},
+		{[]string{"edns0", "local", "unset", "0xffee"}, false, reflect.TypeOf(&edns0UnsetRule{})},
+		{[]string{"edns0", "local", "unset"}, true, nil},
+		{[]string{"edns0", "local", "unset", "0xfffff"}, true, nil},
+		{[]string{"edns0", "nsid", "unset"}, false, reflect.TypeOf(&edns0UnsetRule{})},
+		{[]string{"edns0", "nsid", "unset", "junk"}, true, nil},
+		{[]string{"edns0", "subnet", "unset"}, false, reflect.TypeOf(&edns0UnsetRule{})},
+		{[]string{"edns0", "subnet", "unset", "24", "56"}, true, nil},
+		{[]string{"edns0", "foo", "unset"}, true, nil},
+		{[]string{"edns0", "strip"}, false, reflect.TypeOf(&edns0UnsetRule{})},
+		{[]string{"edns0", "strip", "10", "0xffee"}, false, reflect.TypeOf(&edns0UnsetRule{})},
+		{[]string{"edns0", "strip", "cookie"}, true, nil},
 		{[]string{"unknown-action", "name", "a.com", "b.com"}, true, nil},
 		{[]string{"stop", "name", "a.com", "b.com"}, false, reflect.TypeOf(&exactNameRule{})},
 		{[]string{"continue", "name", "a.com", "b.com"}, false, reflect.TypeOf(&exactNameRule{})},
@@ -387,6 +399,69 @@ func TestRewriteEDNS0Local(t *testing.T) {
 	}
 }
 
+func TestRewriteEDNS0Unset(t *testing.T) {
+	rw := Rewrite{
+		Next:         plugin.HandlerFunc(msgPrinter),
+		RevertPolicy: NoRevertPolicy(),
+	}
+
+	local := &dns.EDNS0_LOCAL{Code: 0xffee, Data: []byte("abc")}
+	nsid := &dns.EDNS0_NSID{Code: dns.EDNS0NSID}
+	subnet := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("10.0.0.0").To4()}
+	all := []dns.EDNS0{local, nsid, subnet}
+
+	tests := []struct {
+		args   []string
+		toOpts []dns.EDNS0
+	}{
+		{[]string{"local", "unset", "0xffee"}, []dns.EDNS0{nsid, subnet}},
+		{[]string{"local", "unset", "0xfffe"}, all},
+		{[]string{"local", "unset", "3"}, []dns.EDNS0{local, subnet}},
+		{[]string{"nsid", "unset"}, []dns.EDNS0{local, subnet}},
+		{[]string{"subnet", "unset"}, []dns.EDNS0{local, nsid}},
+		{[]string{"strip", "8", "0xffee"}, []dns.EDNS0{nsid}},
+		{[]string{"strip"}, []dns.EDNS0{}},
+	}
+
+	ctx := context.TODO()
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion("example.com.", dns.TypeA)
+		m.SetEdns0(4096, false)
+		m.IsEdns0().Option = append([]dns.EDNS0(nil), all...)
+
+		r, err := newEdns0Rule("stop", tc.args...)
+		if err != nil {
+			t.Errorf("Error creating test rule: %s", err)
+			continue
+		}
+		rw.Rules = []Rule{r}
+
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		rw.ServeDNS(ctx, rec, m)
+
+		o := rec.Msg.IsEdns0()
+		if o == nil {
+			t.Errorf("Test %d: Expected an OPT record", i)
+			continue
+		}
+		if !optsEqual(o.Option, tc.toOpts) {
+			t.Errorf("Test %d: Expected %v but got %v", i, tc.toOpts, o.Option)
+		}
+	}
+
+	// Without EDNS0 there is nothing to remove, and no OPT record is added.
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	r, _ := newEdns0Rule("stop", "strip")
+	if _, result := r.Rewrite(ctx, request.Request{W: &test.ResponseWriter{}, Req: m}); result != RewriteIgnored {
+		t.Errorf("Expected the rewrite to be ignored without EDNS0")
+	}
+	if m.IsEdns0() != nil {
+		t.Errorf("Expected no OPT record to be added")
+	}
+}
+
 func TestEdns0LocalMultiRule(t *testing.T) {
 	rules := []Rule{}
 	r, _ := newEdns0Rule("stop", "local", "replace", "0xffee", "abcdef")
@@ -2,8 +2,10 @@ package request
 
 import (
 	"fmt"
+	"net"
 	"testing"
 
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
 	"github.com/coredns/coredns/plugin/test"
 
 	"github.com/horahoradev/dns"
@@ -281,3 +283,43 @@ func TestRequestClear(t *testing.T) {
 		t.Errorf("Expected st.port to be cleared after Clear")
 	}
 }
+
+func TestScrubWriterUnrequested(t *testing.T) {
+	ecs := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, SourceScope: 24, Address: net.ParseIP("192.0.2.0").To4()}
+	nsid := &dns.EDNS0_NSID{Code: dns.EDNS0NSID}
+
+	tests := []struct {
+		edns, ecs bool
+		opt       bool // reply has an OPT RR
+		options   int  // options in the reply
+	}{
+		{false, false, false, 0},
+		{true, false, true, 1},
+		{true, true, true, 2},
+	}
+	for i, tc := range tests {
+		req := new(dns.Msg)
+		req.SetQuestion("example.org.", dns.TypeA)
+		if tc.edns {
+			req.SetEdns0(4096, false)
+			if tc.ecs {
+				req.IsEdns0().Option = append(req.IsEdns0().Option, ecs)
+			}
+		}
+		m := new(dns.Msg)
+		m.SetReply(req)
+		m.SetEdns0(4096, false)
+		m.IsEdns0().Option = append(m.IsEdns0().Option, nsid, ecs)
+
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		NewScrubWriter(req, rec).WriteMsg(m)
+
+		o := rec.Msg.IsEdns0()
+		if (o != nil) != tc.opt {
+			t.Fatalf("Test %d: expected an OPT RR %t, got %v", i, tc.opt, o)
+		}
+		if o != nil && len(o.Option) != tc.options {
+			t.Errorf("Test %d: expected %d options, got %v", i, tc.options, o.Option)
+		}
+	}
+}
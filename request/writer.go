@@ -14,8 +14,46 @@ func NewScrubWriter(req *dns.Msg, w dns.ResponseWriter) *ScrubWriter { return &S
 // WriteMsg overrides the default implementation of the underlying dns.ResponseWriter and calls
 // scrub on the message m and will then write it to the client.
 func (s *ScrubWriter) WriteMsg(m *dns.Msg) error {
+	removeUnrequested(s.req, m)
 	state := Request{Req: s.req, W: s.ResponseWriter}
 	state.SizeAndDo(m)
 	state.Scrub(m)
 	return s.ResponseWriter.WriteMsg(m)
 }
+
+// removeUnrequested removes the OPT RR from m if req doesn't have one, and the ECS option if req doesn't have one.
+// Plugins may leave them in for the plugins before them, e.g. forward keeps the ECS option it added to the query
+// so the cache knows the subnet a reply applies to.
+func removeUnrequested(req, m *dns.Msg) {
+	o := req.IsEdns0()
+	if o == nil {
+		for i := len(m.Extra) - 1; i >= 0; i-- {
+			if m.Extra[i].Header().Rrtype == dns.TypeOPT {
+				m.Extra = append(m.Extra[:i], m.Extra[i+1:]...)
+			}
+		}
+		return
+	}
+	for _, opt := range o.Option {
+		if opt.Option() == dns.EDNS0SUBNET {
+			return
+		}
+	}
+	mo := m.IsEdns0()
+	if mo == nil {
+		return
+	}
+	for i, opt := range mo.Option {
+		if opt.Option() == dns.EDNS0SUBNET {
+			// Don't filter in place, the options may be shared with another message.
+			options := append([]dns.EDNS0{}, mo.Option[:i]...)
+			for _, opt := range mo.Option[i+1:] {
+				if opt.Option() != dns.EDNS0SUBNET {
+					options = append(options, opt)
+				}
+			}
+			mo.Option = options
+			return
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package test
 
 import (
+	"net"
 	"testing"
 
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
 	"github.com/coredns/coredns/plugin/test"
 
 	"github.com/horahoradev/dns"
@@ -155,3 +157,69 @@ func TestLookupCacheWithoutEdns(t *testing.T) {
 	}
 	t.Fatalf("Expected empty additional section, got %v", resp.Extra)
 }
+
+func TestLookupCacheECSAdd(t *testing.T) {
+	// The upstream answers with the address of the ECS option, which only applies to that /24.
+	up := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		if o := r.IsEdns0(); o != nil {
+			ret.SetEdns0(o.UDPSize(), false)
+			for _, opt := range o.Option {
+				if ecs, ok := opt.(*dns.EDNS0_SUBNET); ok {
+					e := *ecs
+					e.SourceScope = 24
+					ret.IsEdns0().Option = append(ret.IsEdns0().Option, &e)
+					ret.Answer = append(ret.Answer, test.A("example.org. 60 IN A "+ecs.Address.String()))
+				}
+			}
+		}
+		w.WriteMsg(ret)
+	})
+	defer up.Close()
+
+	corefile := `example.org:0 {
+		cache
+		forward . ` + up.Addr + ` {
+			ecs add 24
+		}
+	}`
+	i, udp, _, err := CoreDNSServerAndPorts(corefile)
+	if err != nil {
+		t.Fatalf("Could not get CoreDNS serving instance: %s", err)
+	}
+	defer i.Stop()
+
+	tests := []struct {
+		from     string
+		edns     bool
+		expected string
+	}{
+		{"127.0.0.1", true, "127.0.0.0"},
+		{"127.0.1.1", false, "127.0.1.0"},
+		{"127.0.0.2", false, "127.0.0.0"},
+		{"127.0.1.2", true, "127.0.1.0"},
+	}
+	for _, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeA)
+		if tc.edns {
+			m.SetEdns0(4096, false)
+		}
+		c := &dns.Client{Dialer: &net.Dialer{LocalAddr: &net.UDPAddr{IP: net.ParseIP(tc.from)}}}
+		resp, _, err := c.Exchange(m, udp)
+		if err != nil {
+			t.Fatalf("Expected to receive reply from %s, but didn't: %s", tc.from, err)
+		}
+		if len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != tc.expected {
+			t.Errorf("Expected the answer for %s to be %s, got %v", tc.from, tc.expected, resp.Answer)
+		}
+		o := resp.IsEdns0()
+		if !tc.edns && o != nil {
+			t.Errorf("Expected no OPT RR in the reply to %s, got %s", tc.from, o)
+		}
+		if o != nil && len(o.Option) > 0 {
+			t.Errorf("Expected no ECS option in the reply to %s, got %s", tc.from, o)
+		}
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
@@ -377,6 +379,56 @@ func TestReloadUnreadyPlugin(t *testing.T) {
 	c1.Stop()
 }
 
+func TestReloadCachePersist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache.snapshot")
+	corefile := func(ip string) string {
+		return `example.org:0 {
+		cache {
+			persist ` + file + ` 0
+		}
+		template IN A example.org {
+			answer "{{ .Name }} 60 IN A ` + ip + `"
+		}
+	}`
+	}
+
+	c, err := CoreDNSServer(corefile("127.0.0.1"))
+	if err != nil {
+		t.Fatalf("Could not get CoreDNS serving instance: %s", err)
+	}
+	udp, _ := CoreDNSServerPorts(c, 0)
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	if _, err := dns.Exchange(m, udp); err != nil {
+		t.Fatalf("Expected to receive reply, but didn't: %s", err)
+	}
+
+	// The new instance must load the entry cached by the old one, not answer from its own template.
+	c1, err := c.Restart(NewInput(corefile("127.0.0.2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Stop()
+	udp, _ = CoreDNSServerPorts(c1, 0)
+
+	var a string
+	for i := 0; i < 50; i++ {
+		r, err := dns.Exchange(m, udp)
+		if err != nil {
+			t.Fatalf("Expected to receive reply, but didn't: %s", err)
+		}
+		if len(r.Answer) == 1 {
+			a = r.Answer[0].(*dns.A).A.String()
+			if a == "127.0.0.1" {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Expected the cached answer 127.0.0.1 after the reload, got %q", a)
+}
+
 type unready struct {
 	next plugin.Handler
 }